@@ -4,6 +4,7 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -68,6 +69,11 @@ func setCollectdVersionEnvvar() {
 
 // Print out status about an existing instance of the agent.
 func doStatus() {
+	if len(os.Args) > 2 && os.Args[2] == "filters" {
+		doFilterStatus()
+		return
+	}
+
 	set := flag.NewFlagSet("status", flag.ExitOnError)
 	configPath := set.String("config", defaultConfigPath, "agent config path")
 
@@ -84,6 +90,97 @@ func doStatus() {
 	fmt.Println("")
 }
 
+// dimensionFlags collects repeated -dimension key=value flags
+type dimensionFlags []string
+
+func (d *dimensionFlags) String() string {
+	return strings.Join(*d, ",")
+}
+
+func (d *dimensionFlags) Set(v string) error {
+	if !strings.Contains(v, "=") {
+		return fmt.Errorf("dimension %q must be of the form key=value", v)
+	}
+	*d = append(*d, v)
+	return nil
+}
+
+// Print out how the datapoint filters of an existing instance of the agent
+// treat a datapoint, or the match counts of all filters if no metric is given.
+func doFilterStatus() {
+	set := flag.NewFlagSet("status filters", flag.ExitOnError)
+	configPath := set.String("config", defaultConfigPath, "agent config path")
+	metric := set.String("metric", "", "the metric name of the datapoint to trace through the filters")
+	value := set.String("value", "", "the value of the datapoint, if any filters depend on it")
+	monitorType := set.String("monitorType", "", "the type of the monitor that sends the datapoint")
+	monitorID := set.String("monitorID", "", "the id of the monitor that sends the datapoint, if only one of the monitors of the type should be considered")
+	var dims dimensionFlags
+	set.Var(&dims, "dimension", "a dimension of the datapoint as key=value, can be given more than once")
+
+	set.Parse(os.Args[3:])
+
+	log.SetLevel(log.ErrorLevel)
+
+	query := url.Values{}
+	for k, v := range map[string]string{"metric": *metric, "value": *value, "monitorType": *monitorType, "monitorID": *monitorID} {
+		if v != "" {
+			query.Set(k, v)
+		}
+	}
+	for _, d := range dims {
+		query.Add("dimension", d)
+	}
+
+	out, err := core.FilterTrace(*configPath, query)
+	if err != nil {
+		fmt.Printf("Could not get filter status: %s\nAre you sure the agent is currently running?\n", err)
+		os.Exit(1)
+	}
+	fmt.Print(string(out))
+}
+
+// Check the config file for problems without running the agent, exiting
+// non-zero if there are any.
+func doValidate() {
+	set := flag.NewFlagSet("validate", flag.ExitOnError)
+	configPath := set.String("config", defaultConfigPath, "agent config path")
+	noRemote := set.Bool("no-remote", false, "don't contact remote config sources, values from them will be empty unless they have a default")
+
+	set.Parse(os.Args[2:])
+
+	log.SetLevel(log.ErrorLevel)
+
+	errs := core.ValidateConfig(*configPath, *noRemote)
+	if len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+		}
+		fmt.Fprintf(os.Stderr, "Config %s is invalid: %d problem(s) found\n", *configPath, len(errs))
+		os.Exit(1)
+	}
+	fmt.Printf("Config %s is valid\n", *configPath)
+}
+
+// Print out the config that the agent would run with, with secrets redacted
+func doRenderConfig() {
+	set := flag.NewFlagSet("render-config", flag.ExitOnError)
+	configPath := set.String("config", defaultConfigPath, "agent config path")
+	noRemote := set.Bool("no-remote", false, "don't contact remote config sources, values from them will be empty unless they have a default")
+	annotate := set.Bool("annotate", false, "annotate each value that came from a dynamic value with its source")
+
+	set.Parse(os.Args[2:])
+
+	log.SetOutput(os.Stderr)
+	log.SetLevel(log.WarnLevel)
+
+	out, err := core.RenderConfig(*configPath, *noRemote, *annotate)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not render config %s: %s\n", *configPath, err)
+		os.Exit(1)
+	}
+	fmt.Print(out)
+}
+
 // Print out agent self-description of config/metadata
 func doSelfDescribe() {
 	log.SetOutput(os.Stderr)
@@ -195,8 +292,8 @@ func main() {
 
 	// Make it so the symlink from agent-status to this binary invokes the
 	// status command
-	if len(os.Args) == 1 && strings.HasSuffix(os.Args[0], "agent-status") {
-		os.Args = append(os.Args, "status")
+	if strings.HasSuffix(os.Args[0], "agent-status") && (len(os.Args) == 1 || os.Args[1] != "status") {
+		os.Args = append([]string{os.Args[0], "status"}, os.Args[1:]...)
 	}
 
 	var firstArg string
@@ -209,6 +306,10 @@ func main() {
 		doStatus()
 	case "selfdescribe":
 		doSelfDescribe()
+	case "validate":
+		doValidate()
+	case "render-config":
+		doRenderConfig()
 	default:
 		if firstArg != "" && !strings.HasPrefix(firstArg, "-") {
 			log.Errorf("Unknown subcommand '%s'", firstArg)
@@ -6,6 +6,7 @@ import (
 	"context"
 	"net/http"
 	"os"
+	"sync"
 
 	log "github.com/sirupsen/logrus"
 
@@ -45,8 +46,20 @@ type Agent struct {
 	propertyChan chan *types.DimProperties
 	spanChan     chan *trace.Span
 
-	diagnosticServer     *http.Server
-	profileServerRunning bool
+	// Held while the monitors are being configured, since that can also
+	// happen through the management API
+	configLock    sync.Mutex
+	configPath    string
+	adhocMonitors *adhocMonitors
+	// Configs reloaded through the management API
+	reloads chan *config.Config
+	// Held while queueing a reloaded config so that there is always room for
+	// it after the previous one is dropped
+	reloadLock sync.Mutex
+
+	diagnosticServer         *http.Server
+	diagnosticServerSettings diagnosticServerSettings
+	profileServerRunning     bool
 }
 
 // NewAgent creates an unconfigured agent instance
@@ -56,6 +69,10 @@ func NewAgent() *Agent {
 		eventChan:    make(chan *event.Event, eventChanCapacity),
 		propertyChan: make(chan *types.DimProperties, dimPropChanCapacity),
 		spanChan:     make(chan *trace.Span, traceSpanChanCapacity),
+		adhocMonitors: &adhocMonitors{
+			confs: make(map[string]config.MonitorConfig),
+		},
+		reloads: make(chan *config.Config, 1),
 	}
 
 	agent.observers = &observers.ObserverManager{
@@ -75,6 +92,9 @@ func NewAgent() *Agent {
 }
 
 func (a *Agent) configure(conf *config.Config) {
+	a.configLock.Lock()
+	defer a.configLock.Unlock()
+
 	level := conf.Logging.LogrusLevel()
 	if level != nil {
 		log.SetLevel(*level)
@@ -106,7 +126,8 @@ func (a *Agent) configure(conf *config.Config) {
 	a.meta.InternalStatusPort = conf.InternalStatusPort
 
 	// The order of Configure calls is very important!
-	a.monitors.Configure(conf.Monitors, &conf.Collectd, conf.IntervalSeconds)
+	monitorConfigs, _ := a.allMonitorConfigs(conf)
+	a.monitors.Configure(monitorConfigs, &conf.Collectd, conf.IntervalSeconds)
 	a.observers.Configure(conf.Observers)
 	a.lastConfig = conf
 }
@@ -142,9 +163,21 @@ func Startup(configPath string) (context.CancelFunc, <-chan struct{}) {
 	}
 
 	agent := NewAgent()
+	agent.configPath = configPath
 
 	shutdownComplete := make(chan struct{})
 
+	applyConfig := func(config *config.Config) {
+		agent.configure(config)
+		log.Info("Done configuring agent")
+
+		if config.InternalStatusHost != "" {
+			if err := agent.serveDiagnosticInfo(config.InternalStatusHost, config.InternalStatusPort, config.ManagementAPI); err != nil {
+				log.WithError(err).Error("Could not start internal status server")
+			}
+		}
+	}
+
 	go func(ctx context.Context) {
 		for {
 			select {
@@ -158,14 +191,11 @@ func Startup(configPath string) (context.CancelFunc, <-chan struct{}) {
 					os.Exit(2)
 				}
 
-				agent.configure(config)
-				log.Info("Done configuring agent")
+				applyConfig(config)
 
-				if config.InternalStatusHost != "" {
-					if err := agent.serveDiagnosticInfo(config.InternalStatusHost, config.InternalStatusPort); err != nil {
-						log.WithError(err).Error("Could not start internal status server")
-					}
-				}
+			case config := <-agent.reloads:
+				log.Info("Config reloaded through management API")
+				applyConfig(config)
 
 			case <-ctx.Done():
 				agent.shutdown()
@@ -180,6 +210,12 @@ func Startup(configPath string) (context.CancelFunc, <-chan struct{}) {
 
 // Status reads the text from the diagnostic socket and returns it if available.
 func Status(configPath string) ([]byte, error) {
+	return readStatusPath(configPath, "/")
+}
+
+// Reads the given path from the diagnostic server of the agent running with
+// the given config
+func readStatusPath(configPath string, path string) ([]byte, error) {
 	configLoads, err := config.LoadConfig(context.Background(), configPath)
 	if err != nil {
 		return nil, err
@@ -187,6 +223,6 @@ func Status(configPath string) ([]byte, error) {
 
 	select {
 	case conf := <-configLoads:
-		return readStatusInfo(conf.InternalStatusHost, conf.InternalStatusPort)
+		return readStatusInfo(conf.InternalStatusHost, conf.InternalStatusPort, path)
 	}
 }
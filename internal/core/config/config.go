@@ -57,6 +57,10 @@ type Config struct {
 	// How often to send metrics to SignalFx.  Monitors can override this
 	// individually.
 	IntervalSeconds int `yaml:"intervalSeconds" default:"10"`
+	// The default limit on the number of distinct metric time series that a
+	// single monitor instance can send.  See the `maxMetricTimeSeries` monitor
+	// config option.  If 0 (the default), the number is not limited.
+	MaxMetricTimeSeriesPerMonitor int `yaml:"maxMetricTimeSeriesPerMonitor" default:"0"`
 	// Dimensions (key:value pairs) that will be added to every datapoint emitted by the agent.
 	// To specify that all metrics should be high-resolution, add the dimension `sf_hires: 1`
 	GlobalDimensions map[string]string `yaml:"globalDimensions" default:"{}"`
@@ -81,6 +85,19 @@ type Config struct {
 	MetricsToExclude []MetricFilter `yaml:"metricsToExclude" default:"[]"`
 	// A list of properties filters
 	PropertiesToExclude []PropertyFilterConfig `yaml:"propertiesToExclude" default:"[]"`
+	// A list of event filters that will whitelist/include events.  These
+	// filters take priority over the filters specified in `eventsToExclude`.
+	EventsToInclude []EventFilter `yaml:"eventsToInclude" default:"[]"`
+	// A list of event filters
+	EventsToExclude []EventFilter `yaml:"eventsToExclude" default:"[]"`
+	// A list of trace span filters
+	SpansToExclude []SpanFilter `yaml:"spansToExclude" default:"[]"`
+	// A list of transforms that rename metrics and change dimensions on
+	// datapoints before they are sent.  Transforms are applied in order, after
+	// the metric filters, so each one sees the result of the ones before it.
+	// This makes it possible to normalize naming across monitors without
+	// changing the monitors themselves.
+	MetricTransforms []MetricTransform `yaml:"metricTransforms" default:"[]"`
 
 	// The host on which the internal status server will listen.  The internal
 	// status HTTP server serves internal metrics and diagnostic information
@@ -92,6 +109,11 @@ type Config struct {
 	// The port on which the internal status server will listen.  See
 	// `internalStatusHost`.
 	InternalStatusPort uint16 `yaml:"internalStatusPort" default:"8095"`
+	// Configuration of the management API on the internal status server,
+	// which can list the active monitors, add and remove monitors without
+	// changing the config file, and reload the config.  See
+	// [Management API](./management-api.md).
+	ManagementAPI ManagementAPIConfig `yaml:"managementAPI" default:"{}"`
 
 	// Enables Go pprof endpoint on port 6060 that serves profiling data for
 	// development
@@ -186,6 +208,18 @@ func (c *Config) validate() error {
 		}
 	}
 
+	if err := c.Writer.validate(); err != nil {
+		return err
+	}
+
+	if _, err := makeTransforms(c.MetricTransforms); err != nil {
+		return errors.WithMessage(err, "metricTransforms is invalid")
+	}
+
+	if err := c.ManagementAPI.validate(); err != nil {
+		return err
+	}
+
 	return c.Collectd.Validate()
 }
 
@@ -193,6 +227,7 @@ func (c *Config) validate() error {
 // need them
 func (c *Config) propagateValuesDown() error {
 	for i := range c.Monitors {
+		c.Monitors[i].MaxMetricTimeSeries = utils.FirstNonZero(c.Monitors[i].MaxMetricTimeSeries, c.MaxMetricTimeSeriesPerMonitor)
 		if err := c.Monitors[i].initialize(); err != nil {
 			return errors.WithMessage(err, fmt.Sprintf("Could not initialize monitor %s", c.Monitors[i].Type))
 		}
@@ -203,11 +238,16 @@ func (c *Config) propagateValuesDown() error {
 
 	c.Writer.MetricsToInclude = c.MetricsToInclude
 	c.Writer.MetricsToExclude = c.MetricsToExclude
+	c.Writer.MetricTransforms = c.MetricTransforms
+	c.Writer.EventsToInclude = c.EventsToInclude
+	c.Writer.EventsToExclude = c.EventsToExclude
+	c.Writer.SpansToExclude = c.SpansToExclude
 	c.Writer.IngestURL = c.IngestURL
 	c.Writer.APIURL = c.APIURL
 	c.Writer.TraceEndpointURL = c.TraceEndpointURL
 	c.Writer.SignalFxAccessToken = c.SignalFxAccessToken
 	c.Writer.GlobalDimensions = c.GlobalDimensions
+	c.Writer.propagateToDestinations()
 
 	return nil
 }
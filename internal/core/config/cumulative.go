@@ -0,0 +1,51 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/signalfx/signalfx-agent/internal/core/dpfilters"
+)
+
+// Modes of converting cumulative counters
+const (
+	CumulativeToRate  = "rate"
+	CumulativeToDelta = "delta"
+)
+
+// CumulativeConversion describes cumulative counters emitted by a monitor
+// that should be converted to something else before being sent.  Only
+// datapoints that are cumulative counters are ever converted.
+type CumulativeConversion struct {
+	// A list of metric names to match against, OR'd together
+	MetricNames []string `yaml:"metricNames" json:"metricNames"`
+	// A single metric name to match against
+	MetricName string `yaml:"metricName" json:"metricName"`
+	// A map of dimension key/values to match against.  All key/values must
+	// match a datapoint for it to be converted.
+	Dimensions map[string]string `yaml:"dimensions" json:"dimensions" default:"{}"`
+	// Either `rate`, which sends a gauge of the per-second rate of change of
+	// the counter, or `delta`, which sends a counter of the change in value
+	// since the previous datapoint.  The first datapoint of each time series
+	// is not sent since there is nothing to compare it to.  If the counter
+	// goes down, it is assumed to have been reset to 0.
+	Mode string `yaml:"mode" json:"mode" default:"rate"`
+}
+
+func (cc *CumulativeConversion) validate() error {
+	switch cc.Mode {
+	case CumulativeToRate, CumulativeToDelta:
+		return nil
+	default:
+		return fmt.Errorf("cumulative conversion mode %s is invalid, must be %s or %s",
+			cc.Mode, CumulativeToRate, CumulativeToDelta)
+	}
+}
+
+// MakeFilter returns a filter that matches the datapoints to convert
+func (cc *CumulativeConversion) MakeFilter() (dpfilters.DatapointFilter, error) {
+	metricNames := cc.MetricNames
+	if cc.MetricName != "" {
+		metricNames = append(append([]string(nil), metricNames...), cc.MetricName)
+	}
+	return dpfilters.New("", metricNames, cc.Dimensions, false)
+}
@@ -0,0 +1,57 @@
+package config
+
+import "github.com/signalfx/signalfx-agent/internal/core/eventfilters"
+
+// EventFilter describes a set of subtractive filters applied to events
+type EventFilter struct {
+	// A list of event types to match against, OR'd together
+	EventTypes []string `yaml:"eventTypes"`
+	// A single event type to match against
+	EventType string `yaml:"eventType"`
+	// A list of event categories to match against, OR'd together.  These
+	// are the category names used by the SignalFx API, e.g. `USER_DEFINED`,
+	// `COLLECTD` or `AGENT`.
+	Categories []string `yaml:"categories"`
+	// A map of dimension key/values to match against.  All key/values must
+	// match an event for it to be matched.
+	Dimensions map[string]string `yaml:"dimensions" default:"{}"`
+	// (**Only applicable for the top level filters**) Limits this scope of the
+	// filter to events from a specific monitor. If specified, any events not
+	// from this monitor type will never match against this filter.
+	MonitorType string `yaml:"monitorType"`
+	// Negates the result of the match so that it matches all events that do
+	// NOT match the event type, category and dimension values given. This
+	// does not negate monitorType, if given.
+	Negated bool `yaml:"negated"`
+}
+
+// MakeFilter returns an actual filter instance from the config
+func (ef *EventFilter) MakeFilter() (eventfilters.EventFilter, error) {
+	eventTypes := ef.EventTypes
+	if ef.EventType != "" {
+		eventTypes = append(append([]string(nil), eventTypes...), ef.EventType)
+	}
+	return eventfilters.New(ef.MonitorType, eventTypes, ef.Categories, ef.Dimensions, ef.Negated)
+}
+
+func makeEventFilterSet(excludes []EventFilter, includes []EventFilter) (*eventfilters.FilterSet, error) {
+	fs := &eventfilters.FilterSet{}
+
+	for i := range excludes {
+		f, err := excludes[i].MakeFilter()
+		if err != nil {
+			return nil, err
+		}
+		fs.ExcludeFilters = append(fs.ExcludeFilters, f)
+	}
+
+	for i := range includes {
+		f, err := includes[i].MakeFilter()
+		if err != nil {
+			return nil, err
+		}
+		fs.IncludeFilters = append(fs.IncludeFilters, f)
+	}
+
+	return fs, nil
+}
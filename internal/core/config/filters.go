@@ -1,6 +1,11 @@
 package config
 
-import "github.com/signalfx/signalfx-agent/internal/core/dpfilters"
+import (
+	"fmt"
+	"strings"
+
+	"github.com/signalfx/signalfx-agent/internal/core/dpfilters"
+)
 
 // MetricFilter describes a set of subtractive filters applied to datapoints
 // right before they are sent.
@@ -17,6 +22,16 @@ type MetricFilter struct {
 	// datapoints not from this monitor type will never match against this
 	// filter.
 	MonitorType string `yaml:"monitorType"`
+	// A boolean expression that must be true for a datapoint to match, in
+	// addition to any metric names and dimensions given.  It can use the
+	// variables `metric`, `value`, `type` (`gauge`, `counter` or
+	// `cumulative_counter`), `monitorType` and `dimensions.<key>`, e.g.
+	// `metric =~ "^jvm" && dimensions.env != "prod" && value == 0`.
+	// Dimensions that aren't on a datapoint have a blank value.  Dimension
+	// keys with characters other than letters, numbers and underscores can
+	// be referred to like `[dimensions.host-name]`.  The syntax is that of
+	// https://github.com/Knetic/govaluate.
+	Expression string `yaml:"expression"`
 	// Negates the result of the match so that it matches all datapoints that
 	// do NOT match the metric name and dimension values given. This does not
 	// negate monitorType, if given.
@@ -28,7 +43,30 @@ func (mf *MetricFilter) MakeFilter() (dpfilters.DatapointFilter, error) {
 	if mf.MetricName != "" {
 		mf.MetricNames = append(mf.MetricNames, mf.MetricName)
 	}
-	return dpfilters.New(mf.MonitorType, mf.MetricNames, mf.Dimensions, mf.Negated)
+	return dpfilters.NewWithExpression(mf.MonitorType, mf.MetricNames, mf.Dimensions, mf.Expression, mf.Negated)
+}
+
+// Returns a short summary of what the filter matches on for diagnostics.
+// This should be called after MakeFilter so that metricName is already in
+// metricNames.
+func (mf *MetricFilter) describe() string {
+	var parts []string
+	if len(mf.MetricNames) > 0 {
+		parts = append(parts, fmt.Sprintf("metricNames: %v", mf.MetricNames))
+	}
+	if len(mf.Dimensions) > 0 {
+		parts = append(parts, fmt.Sprintf("dimensions: %v", mf.Dimensions))
+	}
+	if mf.Expression != "" {
+		parts = append(parts, "expression: "+mf.Expression)
+	}
+	if mf.MonitorType != "" {
+		parts = append(parts, "monitorType: "+mf.MonitorType)
+	}
+	if mf.Negated {
+		parts = append(parts, "negated")
+	}
+	return strings.Join(parts, ", ")
 }
 
 func makeFilterSet(excludes []MetricFilter, includes []MetricFilter) (*dpfilters.FilterSet, error) {
@@ -50,7 +88,7 @@ func makeFilterSet(excludes []MetricFilter, includes []MetricFilter) (*dpfilters
 		if err != nil {
 			return nil, err
 		}
-		excludeSet = append(excludeSet, f)
+		excludeSet = append(excludeSet, dpfilters.Tracked(f, mte.describe()))
 	}
 
 	for _, mti := range mtis {
@@ -58,7 +96,7 @@ func makeFilterSet(excludes []MetricFilter, includes []MetricFilter) (*dpfilters
 		if err != nil {
 			return nil, err
 		}
-		includeSet = append(includeSet, f)
+		includeSet = append(includeSet, dpfilters.Tracked(f, mti.describe()))
 	}
 
 	return &dpfilters.FilterSet{
@@ -90,11 +128,15 @@ func (mf *MetricFilter) MergeWith(mf2 MetricFilter) MetricFilter {
 }
 
 // ShouldMerge checks if mf2 MetricFilter should be merged into receiver mf MetricFilter
-// Filters with same monitorType, negation, and dimensions should be merged
+// Filters with same monitorType, negation, expression and dimensions should be
+// merged
 func (mf *MetricFilter) ShouldMerge(mf2 MetricFilter) bool {
 	if mf.MonitorType != mf2.MonitorType {
 		return false
 	}
+	if mf.Expression != mf2.Expression {
+		return false
+	}
 	if mf.Negated != mf2.Negated {
 		return false
 	}
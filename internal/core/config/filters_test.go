@@ -102,4 +102,17 @@ func TestFilters(t *testing.T) {
 		assert.False(t, f.Matches(&datapoint.Datapoint{Metric: "disk.utilization"}))
 		assert.False(t, f.Matches(&datapoint.Datapoint{Metric: "random.metric"}))
 	})
+	t.Run("Monitor configs include metrics over excludes", func(t *testing.T) {
+		mc := &MonitorConfig{
+			MetricsToExclude: []MetricFilter{
+				{MetricNames: []string{"*"}},
+			},
+			MetricsToInclude: []MetricFilter{
+				{MetricNames: []string{"cpu.utilization"}},
+			},
+		}
+		assert.Nil(t, mc.initialize())
+		assert.False(t, mc.Filter.Matches(&datapoint.Datapoint{Metric: "cpu.utilization"}))
+		assert.True(t, mc.Filter.Matches(&datapoint.Datapoint{Metric: "memory.utilization"}))
+	})
 }
@@ -96,6 +96,37 @@ func LoadConfig(ctx context.Context, configPath string) (<-chan *Config, error)
 	return loads, nil
 }
 
+// LoadConfigOnce loads and renders the main config file a single time without
+// watching it or any of its dynamic values for changes.  It also returns
+// where each of the dynamic values in the config came from.  If noRemote is
+// true, remote config sources are not contacted and dynamic values from them
+// resolve to their default, or are left empty if they have none.
+func LoadConfigOnce(configPath string, noRemote bool) (*Config, sources.ValueSources, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	configYAML, _, err := sources.ReadConfig(configPath, ctx.Done())
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "Could not read config file "+configPath)
+	}
+
+	dynamicProvider := sources.DynamicValueProvider{NoRemote: noRemote}
+	// Nothing is watched, so the sources aren't needed after the values are
+	// read
+	defer dynamicProvider.Stop()
+
+	finalYAML, _, err := dynamicProvider.ReadDynamicValues(configYAML, ctx.Done())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	config, err := loadYAML(finalYAML)
+	if err != nil {
+		return nil, nil, err
+	}
+	return config, dynamicProvider.ValueSources(), nil
+}
+
 func loadYAML(fileContent []byte) (*Config, error) {
 	config := &Config{}
 
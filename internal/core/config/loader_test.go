@@ -11,6 +11,7 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/signalfx/signalfx-agent/internal/utils"
+	yaml "gopkg.in/yaml.v2"
 )
 
 var _ = Describe("Config Loader", func() {
@@ -91,6 +92,54 @@ var _ = Describe("Config Loader", func() {
 		Expect(config.SignalFxAccessToken).To(Equal("abcd"))
 	})
 
+	It("Stubs out remote config sources when loading without them", func() {
+		path := mkFile("agent/agent.yaml", outdent(`
+			signalFxAccessToken: {"#from": 'zookeeper:/agent/token', default: abcd}
+			hostname: {"#from": 'zookeeper:/agent/hostname'}
+			configSources:
+			  zookeeper:
+			    endpoints: ['127.0.0.1:1']
+		`))
+
+		config, _, err := LoadConfigOnce(path, true)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(config.SignalFxAccessToken).To(Equal("abcd"))
+		Expect(config.Hostname).To(Equal(""))
+	})
+
+	It("Renders config with secrets redacted and values annotated with their source", func() {
+		tokenPath := mkFile("agent/token", "abcd")
+		mkFile("agent/conf/dims.yaml", outdent(`
+			env: dev
+		`))
+		mkFile("agent/filters/exclude.yaml", outdent(`
+			- metricName: b
+		`))
+		path := mkFile("agent/agent.yaml", outdent(fmt.Sprintf(`
+			signalFxAccessToken: {"#from": '%s'}
+			globalDimensions:
+			  _dims: {"#from": '%s/agent/conf/*.yaml', flatten: true}
+			metricsToExclude:
+			 - metricName: a
+			 - {"#from": '%s/agent/filters/*.yaml', flatten: true}
+		`, tokenPath, dir, dir)))
+
+		config, valueSources, err := LoadConfigOnce(path, false)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		out := (&YAMLRenderer{ValueSources: valueSources}).Render(config)
+
+		Expect(out).To(ContainSubstring(fmt.Sprintf("signalFxAccessToken: '***************' # from file:%s\n", tokenPath)))
+		Expect(out).To(ContainSubstring(fmt.Sprintf("  env: dev # from file:%s/agent/conf/*.yaml\n", dir)))
+		Expect(out).To(ContainSubstring(fmt.Sprintf("- # from file:%s/agent/filters/*.yaml\n  dimensions: {}\n", dir)))
+		Expect(out).ToNot(ContainSubstring("abcd"))
+
+		var parsed map[string]interface{}
+		Expect(yaml.Unmarshal([]byte(out), &parsed)).To(Succeed())
+		Expect(parsed["intervalSeconds"]).To(Equal(10))
+	})
+
 	It("Will merge seq into single seq", func() {
 		mkFile("agent/conf/mon1.yaml", outdent(`
 			- a
@@ -306,6 +355,71 @@ var _ = Describe("Config Loader", func() {
 		Expect(config.SignalFxAccessToken).To(Equal("1234"))
 	})
 
+	It("Merges the files in a config directory", func() {
+		mkFile("agent.d/00-base.yaml", outdent(`
+			signalFxAccessToken: abcd
+			intervalSeconds: 10
+			globalDimensions:
+			  env: dev
+			  team: a
+			monitors:
+			- type: cpu
+		`))
+		mkFile("agent.d/10-mysql.yaml", outdent(`
+			intervalSeconds: 20
+			globalDimensions:
+			  env: prod
+			monitors:
+			- type: collectd/mysql
+			  host: db
+		`))
+		mkFile("agent.d/README", "not: config")
+
+		config, _, err := LoadConfigOnce(filepath.Join(dir, "agent.d"), false)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(config.SignalFxAccessToken).To(Equal("abcd"))
+		Expect(config.IntervalSeconds).To(Equal(20))
+		Expect(config.GlobalDimensions).To(Equal(map[string]string{"env": "prod", "team": "a"}))
+		Expect(len(config.Monitors)).To(Equal(2))
+		Expect(config.Monitors[0].Type).To(Equal("cpu"))
+		Expect(config.Monitors[1].Type).To(Equal("collectd/mysql"))
+	})
+
+	It("Errors on a config directory without any config files", func() {
+		mkFile("agent.d/README", "not: config")
+
+		_, _, err := LoadConfigOnce(filepath.Join(dir, "agent.d"), false)
+		Expect(err).Should(HaveOccurred())
+	})
+
+	It("Watches a config directory for new files", func() {
+		mkFile("agent.d/00-base.yaml", outdent(`
+			signalFxAccessToken: abcd
+			configSources:
+			  file:
+			    pollRateSeconds: 1
+			monitors:
+			- type: cpu
+		`))
+
+		loads, err := LoadConfig(ctx, filepath.Join(dir, "agent.d"))
+		Expect(err).ShouldNot(HaveOccurred())
+
+		var config *Config
+		Eventually(loads).Should(Receive(&config))
+		Expect(len(config.Monitors)).To(Equal(1))
+
+		mkFile("agent.d/10-memory.yaml", outdent(`
+			monitors:
+			- type: memory
+		`))
+		Eventually(loads, 3).Should(Receive(&config))
+
+		Expect(len(config.Monitors)).To(Equal(2))
+		Expect(config.Monitors[1].Type).To(Equal("memory"))
+	})
+
 	It("Recursively watches dynamic value source for changes", func() {
 		passwordPath := mkFile("agent/password", "s3cr3t")
 
@@ -458,6 +572,63 @@ var _ = Describe("Config Loader", func() {
 		Expect(config.Monitors[0].OtherConfig["password"]).To(Equal("s3cr3t"))
 	})
 
+	It("Transforms dynamic values before deserializing them", func() {
+		mkFile("agent/db.json.b64", "eyJjcmVkcyI6IHsidXNlcm5hbWUiOiAiYWRtaW4iLCAicGFzc3dvcmQiOiAiMDEyMyJ9LCAiaG9zdHMiOiBbImRiMSIsICJkYjIiXX0=\n")
+		mkFile("agent/servers/a.txt", "db1:3306\n")
+		mkFile("agent/servers/b.txt", "db2:3306\n")
+
+		path := mkFile("agent/agent.yaml", outdent(fmt.Sprintf(`
+			signalFxAccessToken: abcd
+			monitors:
+			- type: collectd/mysql
+			  password: {"#from": '%[1]s/agent/db.json.b64', transform: [base64, jsonpath: ".creds.password"]}
+			  host: {"#from": '%[1]s/agent/db.json.b64', transform: [base64, jsonpath: ".hosts[1]", template: "{{.}}.internal"]}
+			  servers: {"#from": '%[1]s/agent/servers/*.txt', transform: [trim, join: ","]}
+		`, dir)))
+
+		loads, err := LoadConfig(ctx, path)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		var config *Config
+		Eventually(loads).Should(Receive(&config))
+
+		// Extracted strings aren't deserialized again, so this isn't a number
+		Expect(config.Monitors[0].OtherConfig["password"]).To(Equal("0123"))
+		Expect(config.Monitors[0].OtherConfig["host"]).To(Equal("db2.internal"))
+		Expect(config.Monitors[0].OtherConfig["servers"]).To(Equal("db1:3306,db2:3306"))
+	})
+
+	It("Joins the values of multiple #from paths from different sources", func() {
+		mkFile("agent/host", "db1\n")
+		os.Setenv("LOADER_TEST_DB_PORT", "3306")
+		defer os.Unsetenv("LOADER_TEST_DB_PORT")
+
+		path := mkFile("agent/agent.yaml", outdent(fmt.Sprintf(`
+			signalFxAccessToken: abcd
+			monitors:
+			- type: collectd/mysql
+			  host: {"#from": ['%[1]s/agent/host', 'env:LOADER_TEST_DB_PORT'], transform: [trim, join: ":"]}
+		`, dir)))
+
+		loads, err := LoadConfig(ctx, path)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		var config *Config
+		Eventually(loads).Should(Receive(&config))
+
+		Expect(config.Monitors[0].OtherConfig["host"]).To(Equal("db1:3306"))
+	})
+
+	It("Errors on invalid transforms", func() {
+		mkFile("agent/token", "abcd")
+		path := mkFile("agent/agent.yaml", outdent(fmt.Sprintf(`
+			signalFxAccessToken: {"#from": '%s/agent/token', transform: [rot13]}
+		`, dir)))
+
+		_, err := LoadConfig(ctx, path)
+		Expect(err).Should(HaveOccurred())
+	})
+
 	It("Will render raw seq into seq position", func() {
 		mkFile("agent/conf/config.yaml", outdent(`
 		    LoadPlugin "cpufreq"
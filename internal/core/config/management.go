@@ -0,0 +1,25 @@
+package config
+
+import (
+	"github.com/pkg/errors"
+)
+
+// ManagementAPIConfig configures the REST API on the internal status server
+// that can be used to inspect and change the monitors of a running agent.
+type ManagementAPIConfig struct {
+	// Whether to serve the management API under `/api/v1` on the internal
+	// status server.  The internal status server must be enabled for this to
+	// have any effect.
+	Enabled bool `yaml:"enabled" default:"false"`
+	// The token that requests to the management API must provide in the
+	// `Authorization: Bearer <token>` header.  Required if the API is
+	// enabled.
+	Token string `yaml:"token" neverLog:"true"`
+}
+
+func (mac *ManagementAPIConfig) validate() error {
+	if mac.Enabled && mac.Token == "" {
+		return errors.New("managementAPI.token is required if the management API is enabled")
+	}
+	return nil
+}
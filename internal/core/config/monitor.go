@@ -1,12 +1,19 @@
 package config
 
 import (
+	"fmt"
 	"reflect"
 
+	"github.com/creasty/defaults"
 	"github.com/mitchellh/hashstructure"
+	"github.com/pkg/errors"
 	"github.com/signalfx/signalfx-agent/internal/core/dpfilters"
+	"github.com/signalfx/signalfx-agent/internal/core/eventfilters"
+	"github.com/signalfx/signalfx-agent/internal/core/spanfilters"
 	"github.com/signalfx/signalfx-agent/internal/monitors/types"
+	"github.com/signalfx/signalfx-agent/internal/utils"
 	log "github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
 )
 
 // MonitorConfig is used to configure monitor instances.  One instance of
@@ -38,8 +45,21 @@ type MonitorConfig struct {
 	// If one or more configurations have this set to true, only those
 	// configurations will be considered -- useful for testing
 	Solo bool `yaml:"solo" json:"solo"`
+	// A list of metric filters that will whitelist/include metrics from this
+	// monitor.  These filters take priority over the filters specified in
+	// `metricsToExclude`, so they only let through datapoints that would
+	// otherwise be excluded.
+	MetricsToInclude []MetricFilter `yaml:"metricsToInclude" json:"metricsToInclude" default:"[]"`
 	// A list of metric filters
 	MetricsToExclude []MetricFilter `yaml:"metricsToExclude" json:"metricsToExclude" default:"[]"`
+	// A list of event filters that will whitelist/include events from this
+	// monitor.  These filters take priority over the filters specified in
+	// `eventsToExclude`.
+	EventsToInclude []EventFilter `yaml:"eventsToInclude" json:"eventsToInclude" default:"[]"`
+	// A list of event filters
+	EventsToExclude []EventFilter `yaml:"eventsToExclude" json:"eventsToExclude" default:"[]"`
+	// A list of trace span filters
+	SpansToExclude []SpanFilter `yaml:"spansToExclude" json:"spansToExclude" default:"[]"`
 	// Some monitors pull metrics from services not running on the same host
 	// and should not get the host-specific dimensions set on them (e.g.
 	// `host`, `AWSUniqueId`, etc).  Setting this to `true` causes those
@@ -51,15 +71,33 @@ type MonitorConfig struct {
 	// is useful when you have an endpoint whose identity is not particularly
 	// important since it acts largely as a proxy or adapter for other metrics.
 	DisableEndpointDimensions bool `yaml:"disableEndpointDimensions" json:"disableEndpointDimensions"`
+	// The maximum number of distinct metric time series (unique combinations
+	// of metric name and dimensions) that each monitor instance created from
+	// this configuration can send.  Datapoints for new time series beyond
+	// this are dropped and an event is sent to say that the limit was hit,
+	// which is not subject to the monitor's `eventsToExclude`.  Time series
+	// that haven't been sent for 10 minutes stop counting towards the limit.
+	// If not set (or set to 0), the global `maxMetricTimeSeriesPerMonitor`
+	// config option will be used instead.
+	// Set to -1 to disable the limit for this monitor.
+	MaxMetricTimeSeries int `yaml:"maxMetricTimeSeries" json:"maxMetricTimeSeries"`
+	// Cumulative counters emitted by this monitor that should be converted to
+	// per-second rates or deltas before they are sent.  The previous value of
+	// each time series is tracked by the agent, so this is more reliable at
+	// detecting counter resets than computing rates in the backend.  Only
+	// series that are within `maxMetricTimeSeries` are tracked.
+	CumulativeConversions []CumulativeConversion `yaml:"cumulativeConversions" json:"cumulativeConversions" default:"[]"`
 	// OtherConfig is everything else that is custom to a particular monitor
 	OtherConfig map[string]interface{} `yaml:",inline" neverLog:"omit"`
 	// ValidationError is where a message concerning validation issues can go
 	// so that diagnostics can output it.
-	Hostname        string               `yaml:"-" json:"-"`
-	BundleDir       string               `yaml:"-" json:"-"`
-	ValidationError string               `yaml:"-" json:"-" hash:"ignore"`
-	MonitorID       types.MonitorID      `yaml:"-" hash:"ignore"`
-	Filter          *dpfilters.FilterSet `yaml:"-" json:"-" hash:"ignore"`
+	Hostname        string                  `yaml:"-" json:"-"`
+	BundleDir       string                  `yaml:"-" json:"-"`
+	ValidationError string                  `yaml:"-" json:"-" hash:"ignore"`
+	MonitorID       types.MonitorID         `yaml:"-" hash:"ignore"`
+	Filter          *dpfilters.FilterSet    `yaml:"-" json:"-" hash:"ignore"`
+	EventFilter     *eventfilters.FilterSet `yaml:"-" json:"-" hash:"ignore"`
+	SpanFilter      *spanfilters.FilterSet  `yaml:"-" json:"-" hash:"ignore"`
 }
 
 var _ CustomConfigurable = &MonitorConfig{}
@@ -68,13 +106,58 @@ var _ CustomConfigurable = &MonitorConfig{}
 // deserialization.
 func (mc *MonitorConfig) initialize() error {
 	var err error
-	mc.Filter, err = makeFilterSet(mc.MetricsToExclude, nil)
+	mc.Filter, err = makeFilterSet(mc.MetricsToExclude, mc.MetricsToInclude)
 	if err != nil {
 		return err
 	}
+
+	if len(mc.EventsToExclude) > 0 {
+		mc.EventFilter, err = makeEventFilterSet(mc.EventsToExclude, mc.EventsToInclude)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(mc.SpansToExclude) > 0 {
+		mc.SpanFilter, err = makeSpanFilterSet(mc.SpansToExclude)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := range mc.CumulativeConversions {
+		// Defaults aren't set on structs within slices
+		if err := defaults.Set(&mc.CumulativeConversions[i]); err != nil {
+			return err
+		}
+		if err := mc.CumulativeConversions[i].validate(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// DecodeMonitorConfig deserializes a single monitor config from YAML (or
+// JSON) outside of the main config file and sets it up the same way as the
+// monitors in the main config, using the top-level values of c where the
+// monitor doesn't override them.
+func (c *Config) DecodeMonitorConfig(content []byte) (*MonitorConfig, error) {
+	mc := &MonitorConfig{}
+	if err := yaml.UnmarshalStrict(content, mc); err != nil {
+		return nil, errors.WithMessage(err, "could not deserialize monitor config")
+	}
+
+	if err := defaults.Set(mc); err != nil {
+		panic(fmt.Sprintf("Monitor config defaults are wrong types: %s", err))
+	}
+
+	mc.MaxMetricTimeSeries = utils.FirstNonZero(mc.MaxMetricTimeSeries, c.MaxMetricTimeSeriesPerMonitor)
+	if err := mc.initialize(); err != nil {
+		return nil, errors.WithMessage(err, fmt.Sprintf("Could not initialize monitor %s", mc.Type))
+	}
+	return mc, nil
+}
+
 // Equals tests if two monitor configs are sufficiently equal to each other.
 // Two monitors should only be equal if it doesn't make sense for two
 // configurations to be active at the same time.
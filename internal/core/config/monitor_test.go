@@ -0,0 +1,29 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCumulativeConversionModeValidation(t *testing.T) {
+	for _, tc := range []struct {
+		mode  string
+		valid bool
+	}{
+		{"", true},
+		{CumulativeToRate, true},
+		{CumulativeToDelta, true},
+		{"gauge", false},
+	} {
+		mc := &MonitorConfig{
+			Type:                  "cpu",
+			CumulativeConversions: []CumulativeConversion{{MetricName: "a", Mode: tc.mode}},
+		}
+		err := mc.initialize()
+		assert.Equal(t, tc.valid, err == nil, "mode %q: %v", tc.mode, err)
+		if err == nil {
+			assert.NotEqual(t, "", mc.CumulativeConversions[0].Mode)
+		}
+	}
+}
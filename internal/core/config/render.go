@@ -0,0 +1,294 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/signalfx/signalfx-agent/internal/core/config/sources"
+	log "github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const redactedValue = "***************"
+
+// YAMLRenderer converts config structs to YAML that shows exactly what the
+// agent runs with, including defaults.  Unlike ToString, the output is valid
+// YAML that could be used as a config file.  If a struct field has the
+// 'neverLog' tag, its value will be replaced by asterisks, or completely
+// omitted if the tag value is 'omit' and there is no replacement for it.
+type YAMLRenderer struct {
+	// Where dynamic values came from.  If set, each value that came from a
+	// dynamic value will be annotated with a comment naming its source.
+	ValueSources sources.ValueSources
+	// Values to render in place of struct fields, keyed by the path of the
+	// field as built by sources.ValuePath
+	Replacements map[string]interface{}
+}
+
+// Render the given config struct as a YAML document
+func (r *YAMLRenderer) Render(conf interface{}) string {
+	n := r.node(reflect.ValueOf(conf), "")
+	if n == nil {
+		return ""
+	}
+
+	var sb strings.Builder
+	if n.kind == scalarNode {
+		sb.WriteString(n.scalar + "\n")
+	} else {
+		n.write(&sb, 0)
+	}
+	return sb.String()
+}
+
+type renderedNodeKind int
+
+const (
+	scalarNode renderedNodeKind = iota
+	mapNode
+	seqNode
+)
+
+type renderedNode struct {
+	kind renderedNodeKind
+	// Already in YAML form
+	scalar  string
+	keys    []string
+	values  []*renderedNode
+	comment string
+}
+
+func scalar(v interface{}) *renderedNode {
+	out, err := yaml.Marshal(v)
+	if err != nil {
+		log.WithError(err).Error("Could not marshal config value to YAML")
+		return &renderedNode{kind: scalarNode, scalar: "null"}
+	}
+	s := strings.TrimSuffix(string(out), "\n")
+	// Long strings and strings with newlines get split onto multiple lines
+	// by the YAML encoder, so use the equivalent double quoted form to keep
+	// them on one line.
+	if strings.Contains(s, "\n") {
+		if j, err := json.Marshal(v); err == nil {
+			s = string(j)
+		}
+	}
+	return &renderedNode{kind: scalarNode, scalar: s}
+}
+
+func (r *YAMLRenderer) node(v reflect.Value, path string) *renderedNode {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return nil
+	}
+
+	var n *renderedNode
+	switch {
+	case v.Type() == reflect.TypeOf(time.Duration(0)):
+		n = scalar(time.Duration(v.Int()).String())
+	case v.Kind() == reflect.Struct:
+		n = r.structNode(v, path)
+	case v.Kind() == reflect.Map:
+		n = r.mapNode(v, path)
+	case v.Kind() == reflect.Slice || v.Kind() == reflect.Array:
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			n = scalar(v.Interface())
+			break
+		}
+		n = &renderedNode{kind: seqNode}
+		for i := 0; i < v.Len(); i++ {
+			elem := r.node(v.Index(i), sources.ValuePath(path, i))
+			if elem == nil {
+				elem = &renderedNode{kind: scalarNode, scalar: "null"}
+			}
+			n.values = append(n.values, elem)
+		}
+		if len(n.values) == 0 {
+			n = &renderedNode{kind: scalarNode, scalar: "[]"}
+		}
+	default:
+		n = scalar(v.Interface())
+	}
+
+	if src, ok := r.ValueSources[path]; ok && path != "" {
+		n.comment = "from " + src
+	}
+	return n
+}
+
+func (r *YAMLRenderer) mapNode(v reflect.Value, path string) *renderedNode {
+	if v.Len() == 0 {
+		return &renderedNode{kind: scalarNode, scalar: "{}"}
+	}
+
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+	})
+
+	n := &renderedNode{kind: mapNode}
+	for _, k := range keys {
+		val := r.node(v.MapIndex(k), sources.ValuePath(path, k.Interface()))
+		if val == nil {
+			val = &renderedNode{kind: scalarNode, scalar: "null"}
+		}
+		n.keys = append(n.keys, scalar(k.Interface()).scalar)
+		n.values = append(n.values, val)
+	}
+	return n
+}
+
+func (r *YAMLRenderer) structNode(v reflect.Value, path string) *renderedNode {
+	n := &renderedNode{kind: mapNode}
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		// PkgPath is empty only for exported fields, so it it's non-empty the
+		// field is private
+		if field.PkgPath != "" {
+			continue
+		}
+
+		key, inline := yamlKeyOfField(field)
+		if key == "" && !inline {
+			continue
+		}
+
+		fieldPath := path
+		if !inline {
+			fieldPath = sources.ValuePath(path, key)
+		}
+
+		fieldValue := v.Field(i)
+		neverLogVal, neverLogPresent := field.Tag.Lookup("neverLog")
+
+		var val *renderedNode
+		if replacement, ok := r.Replacements[fieldPath]; ok && !inline {
+			val = r.node(reflect.ValueOf(replacement), fieldPath)
+		} else if neverLogVal == "omit" {
+			continue
+		} else if neverLogPresent && !isEmptyValue(fieldValue) {
+			val = scalar(redactedValue)
+			if src, ok := r.ValueSources[fieldPath]; ok {
+				val.comment = "from " + src
+			}
+		} else {
+			val = r.node(fieldValue, fieldPath)
+		}
+
+		if val == nil {
+			continue
+		}
+
+		// Flatten embedded struct's representation
+		if inline {
+			if val.kind == mapNode {
+				n.keys = append(n.keys, val.keys...)
+				n.values = append(n.values, val.values...)
+			}
+			continue
+		}
+
+		n.keys = append(n.keys, key)
+		n.values = append(n.values, val)
+	}
+
+	if len(n.keys) == 0 {
+		return &renderedNode{kind: scalarNode, scalar: "{}"}
+	}
+	return n
+}
+
+// Returns the key that the YAML decoder uses for the field, and whether the
+// field's own fields are inlined into the parent instead.  The key is blank
+// if the field isn't decoded at all.
+func yamlKeyOfField(field reflect.StructField) (string, bool) {
+	parts := strings.Split(field.Tag.Get("yaml"), ",")
+	if parts[0] == "-" {
+		return "", false
+	}
+	for _, opt := range parts[1:] {
+		if opt == "inline" {
+			return "", true
+		}
+	}
+	if field.Anonymous && parts[0] == "" {
+		return "", true
+	}
+	if parts[0] != "" {
+		return parts[0], false
+	}
+	return strings.ToLower(field.Name), false
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Map, reflect.Slice:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+func (n *renderedNode) write(sb *strings.Builder, indent int) {
+	prefix := strings.Repeat(" ", indent)
+
+	switch n.kind {
+	case mapNode:
+		for i, key := range n.keys {
+			val := n.values[i]
+			sb.WriteString(prefix + key + ":")
+			if val.kind == scalarNode {
+				sb.WriteString(" " + val.scalar)
+				writeComment(sb, val.comment)
+				continue
+			}
+			writeComment(sb, val.comment)
+
+			if val.kind == seqNode {
+				val.write(sb, indent)
+			} else {
+				val.write(sb, indent+2)
+			}
+		}
+	case seqNode:
+		for _, val := range n.values {
+			if val.kind == scalarNode {
+				sb.WriteString(prefix + "- " + val.scalar)
+				writeComment(sb, val.comment)
+				continue
+			}
+
+			if val.comment != "" {
+				sb.WriteString(prefix + "-")
+				writeComment(sb, val.comment)
+				val.write(sb, indent+2)
+				continue
+			}
+
+			// Put the first line of the element on the same line as the dash
+			var elem strings.Builder
+			val.write(&elem, indent+2)
+			sb.WriteString(prefix + "- " + strings.TrimPrefix(elem.String(), prefix+"  "))
+		}
+	}
+}
+
+func writeComment(sb *strings.Builder, comment string) {
+	if comment != "" {
+		sb.WriteString(" # " + comment)
+	}
+	sb.WriteString("\n")
+}
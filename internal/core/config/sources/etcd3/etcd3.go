@@ -0,0 +1,197 @@
+// Package etcd3 contains a config source that gets values from the etcd v3
+// API.  Paths can be single keys or globs, in which case all keys under the
+// glob's prefix are read and matched against it.  Changes are detected with
+// etcd watches starting from the revision that the values were read at, so no
+// change in between is missed.
+package etcd3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/signalfx/signalfx-agent/internal/core/config/types"
+	"github.com/signalfx/signalfx-agent/internal/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// Config for an Etcd 3 source
+type Config struct {
+	// A list of Etcd 3 servers to use
+	Endpoints []string `yaml:"endpoints"`
+	// An optional username to use when connecting
+	Username string `yaml:"username"`
+	// An optional password to use when connecting
+	Password string `yaml:"password" neverLog:"true"`
+	// How long to wait to connect to the servers
+	DialTimeoutSeconds int `yaml:"dialTimeoutSeconds" default:"5"`
+	// How long to wait for a response to a read
+	RequestTimeoutSeconds int `yaml:"requestTimeoutSeconds" default:"10"`
+	// Path to a CA cert to use in addition to the system certs when
+	// verifying the servers' TLS certificates.  TLS is used if any of the TLS
+	// options are set.
+	CACertPath string `yaml:"caCertPath"`
+	// Path to a client cert to present to the servers
+	ClientCertPath string `yaml:"clientCertPath"`
+	// Path to the key of the client cert
+	ClientKeyPath string `yaml:"clientKeyPath"`
+	// Whether to skip verification of the servers' TLS certificates
+	SkipVerify bool `yaml:"skipVerify"`
+}
+
+// New creates a new Etcd3 remote config source from the target config
+func (c *Config) New() (types.ConfigSource, error) {
+	return New(c)
+}
+
+// Validate the config
+func (c *Config) Validate() error {
+	if len(c.Endpoints) == 0 {
+		return errors.New("at least one etcd3 endpoint must be specified")
+	}
+	if (c.ClientCertPath == "") != (c.ClientKeyPath == "") {
+		return errors.New("clientCertPath and clientKeyPath must be specified together")
+	}
+	return nil
+}
+
+func (c *Config) usesTLS() bool {
+	return c.CACertPath != "" || c.ClientCertPath != "" || c.SkipVerify
+}
+
+var _ types.ConfigSourceConfig = &Config{}
+
+type etcd3ConfigSource struct {
+	client         *clientv3.Client
+	requestTimeout time.Duration
+}
+
+var _ types.Stoppable = &etcd3ConfigSource{}
+
+// New creates a new etcd3 config source
+func New(conf *Config) (types.ConfigSource, error) {
+	clientConf := clientv3.Config{
+		Endpoints:   conf.Endpoints,
+		Username:    conf.Username,
+		Password:    conf.Password,
+		DialTimeout: time.Duration(conf.DialTimeoutSeconds) * time.Second,
+	}
+
+	if conf.usesTLS() {
+		tlsConfig, err := utils.MakeTLSConfig(conf.CACertPath, conf.ClientCertPath, conf.ClientKeyPath, conf.SkipVerify)
+		if err != nil {
+			return nil, err
+		}
+		clientConf.TLS = tlsConfig
+	}
+
+	c, err := clientv3.New(clientConf)
+	if err != nil {
+		return nil, err
+	}
+
+	return &etcd3ConfigSource{
+		client:         c,
+		requestTimeout: time.Duration(conf.RequestTimeoutSeconds) * time.Second,
+	}, nil
+}
+
+func (e *etcd3ConfigSource) Name() string {
+	return "etcd3"
+}
+
+// Globs read and watch everything under their prefix
+func keyOptions(isGlob bool) []clientv3.OpOption {
+	if isGlob {
+		return []clientv3.OpOption{clientv3.WithPrefix()}
+	}
+	return nil
+}
+
+// Get returns the values of the matching keys.  The version is the revision of
+// the store when the keys were read, which is also returned along with
+// ErrNotFound so that the path can be watched for the key's creation.
+func (e *etcd3ConfigSource) Get(path string) (map[string][]byte, uint64, error) {
+	prefix, g, isGlob, err := types.PrefixAndGlob(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.requestTimeout)
+	defer cancel()
+
+	resp, err := e.client.Get(ctx, prefix, keyOptions(isGlob)...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	contentMap := make(map[string][]byte)
+	for _, kv := range resp.Kvs {
+		if g.Match(string(kv.Key)) {
+			contentMap[string(kv.Key)] = kv.Value
+		}
+	}
+
+	version := uint64(resp.Header.Revision)
+	if len(contentMap) == 0 {
+		return nil, version, types.NewNotFoundError(fmt.Sprintf("etcd3 key %s not found", path))
+	}
+
+	return contentMap, version, nil
+}
+
+// WaitForChange watches for changes to matching keys after the given
+// revision.  Deletions count as changes.
+func (e *etcd3ConfigSource) WaitForChange(path string, version uint64, stop <-chan struct{}) error {
+	prefix, g, isGlob, err := types.PrefixAndGlob(path)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stop:
+			cancel()
+		}
+	}()
+
+	opts := keyOptions(isGlob)
+	// A version of 0 means that the path was never successfully read, so
+	// just watch from now on
+	if version > 0 {
+		opts = append(opts, clientv3.WithRev(int64(version)+1))
+	}
+
+	for resp := range e.client.Watch(ctx, prefix, opts...) {
+		if resp.CompactRevision != 0 {
+			// The revision that was read has been compacted away so there
+			// is no way of knowing what changed since then.  Just treat it
+			// as changed so that the path gets read again.
+			log.Debugf("etcd3 revision %d for %s was compacted", version, path)
+			return nil
+		}
+		if err := resp.Err(); err != nil {
+			return err
+		}
+
+		for _, ev := range resp.Events {
+			if g.Match(string(ev.Kv.Key)) {
+				return nil
+			}
+		}
+	}
+
+	// The watch channel is only closed when the context is cancelled
+	return nil
+}
+
+// Stop closes the client, cancelling any watches
+func (e *etcd3ConfigSource) Stop() error {
+	return e.client.Close()
+}
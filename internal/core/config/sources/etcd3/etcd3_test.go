@@ -0,0 +1,231 @@
+package etcd3
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/embed"
+	"github.com/signalfx/signalfx-agent/internal/core/config/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// Returns a localhost URL with a port that is free at the time of the call
+func freeLocalURL(t *testing.T) url.URL {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	return url.URL{Scheme: "http", Host: l.Addr().String()}
+}
+
+// Starts a single member etcd server with its data in a temp dir and returns
+// its client URL and a function that shuts it down and cleans up
+func startEmbeddedEtcd(t *testing.T) (string, func()) {
+	if raceEnabled {
+		t.Skip("The embedded etcd server cannot run under the race detector")
+	}
+
+	dir, err := ioutil.TempDir("", "etcd3-source")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := embed.NewConfig()
+	cfg.Dir = dir
+	clientURL := freeLocalURL(t)
+	peerURL := freeLocalURL(t)
+	cfg.LCUrls, cfg.ACUrls = []url.URL{clientURL}, []url.URL{clientURL}
+	cfg.LPUrls, cfg.APUrls = []url.URL{peerURL}, []url.URL{peerURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(30 * time.Second):
+		e.Close()
+		os.RemoveAll(dir)
+		t.Fatal("Embedded etcd server did not start")
+	}
+
+	return clientURL.String(), func() {
+		e.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestEtcd3Source(t *testing.T) {
+	endpoint, cleanup := startEmbeddedEtcd(t)
+	defer cleanup()
+
+	client, err := clientv3.New(clientv3.Config{Endpoints: []string{endpoint}, DialTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	source, err := (&Config{
+		Endpoints:             []string{endpoint},
+		DialTimeoutSeconds:    5,
+		RequestTimeoutSeconds: 5,
+	}).New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.(types.Stoppable).Stop()
+
+	// Returns the revision of the store after the change
+	put := func(key, value string) uint64 {
+		resp, err := client.Put(context.Background(), key, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return uint64(resp.Header.Revision)
+	}
+
+	del := func(key string) {
+		_, err := client.Delete(context.Background(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	put("/agent/monitors/a", "- type: cpu")
+	put("/agent/monitors/b", "- type: memory")
+	rev := put("/agent/token", "abcd")
+
+	waitForChange := func(path string, version uint64) chan error {
+		changed := make(chan error)
+		go func() {
+			changed <- source.WaitForChange(path, version, make(chan struct{}))
+		}()
+		return changed
+	}
+
+	assertChanged := func(t *testing.T, changed chan error, expected bool) {
+		if expected {
+			select {
+			case err := <-changed:
+				assert.Nil(t, err)
+			case <-time.After(5 * time.Second):
+				t.Fatal("WaitForChange did not return after a change")
+			}
+			return
+		}
+		select {
+		case <-changed:
+			t.Fatal("WaitForChange returned without a relevant change")
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+
+	t.Run("Gets single keys", func(t *testing.T) {
+		content, version, err := source.Get("/agent/token")
+		assert.Nil(t, err)
+		assert.Equal(t, map[string][]byte{"/agent/token": []byte("abcd")}, content)
+		assert.Equal(t, rev, version)
+	})
+
+	t.Run("Gets globbed keys", func(t *testing.T) {
+		content, _, err := source.Get("/agent/monitors/*")
+		assert.Nil(t, err)
+		assert.Equal(t, map[string][]byte{
+			"/agent/monitors/a": []byte("- type: cpu"),
+			"/agent/monitors/b": []byte("- type: memory"),
+		}, content)
+	})
+
+	t.Run("Returns not found errors", func(t *testing.T) {
+		_, version, err := source.Get("/agent/missing")
+		assert.IsType(t, types.ErrNotFound{}, err)
+		assert.Equal(t, rev, version)
+
+		_, _, err = source.Get("/agent/missing/*")
+		assert.IsType(t, types.ErrNotFound{}, err)
+	})
+
+	t.Run("Sees changes made after the read revision", func(t *testing.T) {
+		_, version, err := source.Get("/agent/token")
+		assert.Nil(t, err)
+
+		// Changed in between the read and the watch starting
+		put("/agent/token", "efgh")
+
+		assertChanged(t, waitForChange("/agent/token", version), true)
+	})
+
+	t.Run("Waits for the creation of missing keys", func(t *testing.T) {
+		_, version, err := source.Get("/agent/new")
+		assert.IsType(t, types.ErrNotFound{}, err)
+
+		changed := waitForChange("/agent/new", version)
+		assertChanged(t, changed, false)
+
+		put("/agent/new", "value")
+		assertChanged(t, changed, true)
+	})
+
+	t.Run("Waits for matching globbed keys", func(t *testing.T) {
+		_, version, err := source.Get("/agent/monitors/*")
+		assert.Nil(t, err)
+
+		changed := waitForChange("/agent/monitors/*", version)
+
+		put("/agent/monitors-old/c", "- type: disk")
+		assertChanged(t, changed, false)
+
+		del("/agent/monitors/a")
+		assertChanged(t, changed, true)
+	})
+
+	t.Run("Treats compacted revisions as changed", func(t *testing.T) {
+		_, version, err := source.Get("/agent/token")
+		assert.Nil(t, err)
+
+		// The watch starts from the revision after the read, so compact past it
+		put("/agent/other", "x")
+		compactRev := put("/agent/other", "y")
+		_, err = client.Compact(context.Background(), int64(compactRev))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assertChanged(t, waitForChange("/agent/token", version), true)
+	})
+
+	t.Run("Stops watching", func(t *testing.T) {
+		stop := make(chan struct{})
+		changed := make(chan error)
+		go func() {
+			changed <- source.WaitForChange("/agent/token", 0, stop)
+		}()
+		close(stop)
+		assertChanged(t, changed, true)
+	})
+
+	t.Run("Returns errors for unreachable servers", func(t *testing.T) {
+		unreachable, err := (&Config{
+			Endpoints:             []string{fmt.Sprintf("http://%s", freeLocalURL(t).Host)},
+			DialTimeoutSeconds:    1,
+			RequestTimeoutSeconds: 1,
+		}).New()
+		if err == nil {
+			defer unreachable.(types.Stoppable).Stop()
+			_, _, err = unreachable.Get("/agent/token")
+		}
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,5 @@
+// +build !race
+
+package etcd3
+
+const raceEnabled = false
@@ -0,0 +1,8 @@
+// +build race
+
+package etcd3
+
+// The vendored bbolt trips the race detector's pointer checks (checkptr),
+// which kills the whole test binary, so the tests that run an embedded etcd
+// server are skipped under -race.
+const raceEnabled = true
@@ -0,0 +1,224 @@
+// Package http contains a config source that fetches config content from
+// HTTP(S) URLs.  URLs are polled for changes with conditional requests, so a
+// server that supports ETags or Last-Modified headers doesn't have to send the
+// content again unless it has changed.
+package http
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io/ioutil"
+	"net"
+	nethttp "net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/signalfx/signalfx-agent/internal/core/config/types"
+	"github.com/signalfx/signalfx-agent/internal/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// Config for the HTTP config source.  Values are referred to by their full
+// URL, e.g. `http:https://cfg.internal/agent/web.yaml`.
+type Config struct {
+	// Headers to send with every request, e.g. for authentication
+	Headers map[string]string `yaml:"headers" neverLog:"true"`
+	// A token to send in an `Authorization: Bearer` header with every request
+	BearerToken string `yaml:"bearerToken" neverLog:"true"`
+	// How often to poll URLs for changes when watching
+	PollRateSeconds int `yaml:"pollRateSeconds" default:"60"`
+	// How long to wait for a response before giving up on a request
+	TimeoutSeconds int `yaml:"timeoutSeconds" default:"10"`
+	// Whether to skip verification of the server's TLS certificate
+	SkipVerify bool `yaml:"skipVerify"`
+	// Path to a CA cert to use in addition to the system certs when
+	// verifying the server's TLS certificate
+	CACertPath string `yaml:"caCertPath"`
+	// Path to a client cert to present to the server
+	ClientCertPath string `yaml:"clientCertPath"`
+	// Path to the key of the client cert
+	ClientKeyPath string `yaml:"clientKeyPath"`
+}
+
+// New creates a new HTTP config source from the target config
+func (c *Config) New() (types.ConfigSource, error) {
+	return New(c)
+}
+
+// Validate the config
+func (c *Config) Validate() error {
+	if c.PollRateSeconds <= 0 {
+		return errors.New("pollRateSeconds must be greater than 0")
+	}
+	if (c.ClientCertPath == "") != (c.ClientKeyPath == "") {
+		return errors.New("clientCertPath and clientKeyPath must be specified together")
+	}
+	return nil
+}
+
+var _ types.ConfigSourceConfig = &Config{}
+
+type httpConfigSource struct {
+	sync.Mutex
+	client       *nethttp.Client
+	headers      map[string]string
+	bearerToken  string
+	pollInterval time.Duration
+	table        *crc64.Table
+	// The last successful response for each URL, used to make conditional
+	// requests
+	responses map[string]*response
+}
+
+type response struct {
+	body         []byte
+	etag         string
+	lastModified string
+	version      uint64
+}
+
+// New creates a new HTTP config source
+func New(conf *Config) (types.ConfigSource, error) {
+	tlsConfig, err := utils.MakeTLSConfig(conf.CACertPath, conf.ClientCertPath, conf.ClientKeyPath, conf.SkipVerify)
+	if err != nil {
+		return nil, err
+	}
+
+	return &httpConfigSource{
+		client: &nethttp.Client{
+			// Same as the default transport but with the TLS config
+			Transport: &nethttp.Transport{
+				Proxy: nethttp.ProxyFromEnvironment,
+				DialContext: (&net.Dialer{
+					Timeout:   30 * time.Second,
+					KeepAlive: 30 * time.Second,
+					DualStack: true,
+				}).DialContext,
+				MaxIdleConns:          100,
+				IdleConnTimeout:       90 * time.Second,
+				TLSHandshakeTimeout:   10 * time.Second,
+				ExpectContinueTimeout: 1 * time.Second,
+				TLSClientConfig:       tlsConfig,
+			},
+			Timeout: time.Duration(conf.TimeoutSeconds) * time.Second,
+		},
+		headers:      conf.Headers,
+		bearerToken:  conf.BearerToken,
+		pollInterval: time.Duration(conf.PollRateSeconds) * time.Second,
+		table:        crc64.MakeTable(crc64.ECMA),
+		responses:    make(map[string]*response),
+	}, nil
+}
+
+func (h *httpConfigSource) Name() string {
+	return "http"
+}
+
+// Get fetches the URL, making it a conditional request if it has been
+// fetched before.
+func (h *httpConfigSource) Get(path string) (map[string][]byte, uint64, error) {
+	resp, err := h.fetch(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	return map[string][]byte{path: resp.body}, resp.version, nil
+}
+
+func (h *httpConfigSource) fetch(path string) (*response, error) {
+	u, err := url.Parse(path)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%s is not an http or https URL", path)
+	}
+
+	req, err := nethttp.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range h.headers {
+		req.Header.Set(k, v)
+	}
+	if h.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+h.bearerToken)
+	}
+
+	h.Lock()
+	prev := h.responses[path]
+	h.Unlock()
+
+	if prev != nil {
+		if prev.etag != "" {
+			req.Header.Set("If-None-Match", prev.etag)
+		}
+		if prev.lastModified != "" {
+			req.Header.Set("If-Modified-Since", prev.lastModified)
+		}
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == nethttp.StatusNotModified && prev != nil:
+		return prev, nil
+	case resp.StatusCode == nethttp.StatusNotFound:
+		h.Lock()
+		delete(h.responses, path)
+		h.Unlock()
+		return nil, types.NewNotFoundError(fmt.Sprintf("%s returned 404", path))
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		return nil, fmt.Errorf("%s returned unexpected status %s", path, resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not read response body from %s: %v", path, err)
+	}
+
+	r := &response{
+		body:         body,
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+		version:      crc64.Checksum(body, h.table),
+	}
+
+	h.Lock()
+	h.responses[path] = r
+	h.Unlock()
+
+	return r, nil
+}
+
+// WaitForChange polls the URL with conditional requests until the content
+// differs from the given version.  A URL that disappears counts as a change.
+func (h *httpConfigSource) WaitForChange(path string, version uint64, stop <-chan struct{}) error {
+	ticker := time.NewTicker(h.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+			var newVersion uint64
+			resp, err := h.fetch(path)
+			if err != nil {
+				if _, ok := err.(types.ErrNotFound); !ok {
+					return err
+				}
+			} else {
+				newVersion = resp.version
+			}
+
+			if newVersion != version {
+				log.Debugf("Content of %s changed", path)
+				return nil
+			}
+		}
+	}
+}
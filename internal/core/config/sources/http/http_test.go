@@ -0,0 +1,107 @@
+package http
+
+import (
+	nethttp "net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/signalfx/signalfx-agent/internal/core/config/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPSource(t *testing.T) {
+	var lock sync.Mutex
+	content := "a: 1"
+	etag := `"v1"`
+	var fullResponses int64
+
+	server := httptest.NewServer(nethttp.HandlerFunc(func(rw nethttp.ResponseWriter, r *nethttp.Request) {
+		if r.URL.Path != "/agent.yaml" {
+			rw.WriteHeader(nethttp.StatusNotFound)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer s3cr3t" || r.Header.Get("X-Env") != "test" {
+			rw.WriteHeader(nethttp.StatusUnauthorized)
+			return
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+		if r.Header.Get("If-None-Match") == etag {
+			rw.WriteHeader(nethttp.StatusNotModified)
+			return
+		}
+		atomic.AddInt64(&fullResponses, 1)
+		rw.Header().Set("ETag", etag)
+		_, _ = rw.Write([]byte(content))
+	}))
+	defer server.Close()
+
+	source, err := New(&Config{
+		Headers:         map[string]string{"X-Env": "test"},
+		BearerToken:     "s3cr3t",
+		PollRateSeconds: 1,
+		TimeoutSeconds:  5,
+	})
+	assert.Nil(t, err)
+	source.(*httpConfigSource).pollInterval = 10 * time.Millisecond
+
+	url := server.URL + "/agent.yaml"
+
+	t.Run("Gets the URL and reuses unchanged content", func(t *testing.T) {
+		values, version, err := source.Get(url)
+		assert.Nil(t, err)
+		assert.Equal(t, map[string][]byte{url: []byte("a: 1")}, values)
+
+		values, version2, err := source.Get(url)
+		assert.Nil(t, err)
+		assert.Equal(t, map[string][]byte{url: []byte("a: 1")}, values)
+		assert.Equal(t, version, version2)
+		assert.Equal(t, int64(1), atomic.LoadInt64(&fullResponses))
+	})
+
+	t.Run("Returns not found errors", func(t *testing.T) {
+		_, _, err := source.Get(server.URL + "/missing.yaml")
+		assert.IsType(t, types.ErrNotFound{}, err)
+	})
+
+	t.Run("Rejects paths that aren't URLs", func(t *testing.T) {
+		_, _, err := source.Get("/etc/agent.yaml")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("Waits for the content to change", func(t *testing.T) {
+		_, version, err := source.Get(url)
+		assert.Nil(t, err)
+
+		changed := make(chan error)
+		go func() {
+			changed <- source.WaitForChange(url, version, make(chan struct{}))
+		}()
+
+		select {
+		case <-changed:
+			t.Fatal("WaitForChange returned before the content changed")
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		lock.Lock()
+		content = "a: 2"
+		etag = `"v2"`
+		lock.Unlock()
+
+		select {
+		case err := <-changed:
+			assert.Nil(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("WaitForChange did not return after the content changed")
+		}
+
+		values, _, err := source.Get(url)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("a: 2"), values[url])
+	})
+}
@@ -0,0 +1,306 @@
+// Package kubernetes contains config sources that get values from the keys of
+// ConfigMaps and Secrets in the Kubernetes API.  Objects are watched with
+// informers, so changes are seen immediately without any polling.
+package kubernetes
+
+import (
+	"fmt"
+	"hash/crc64"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gobwas/glob"
+	"github.com/signalfx/signalfx-agent/internal/core/common/kubernetes"
+	"github.com/signalfx/signalfx-agent/internal/core/config/types"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8s "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// ConfigMapSourceName is the source name for ConfigMap values, e.g.
+	// `k8s:namespace/configmap-name/key`
+	ConfigMapSourceName = "k8s"
+	// SecretSourceName is the source name for Secret values, e.g.
+	// `secret:namespace/secret-name/key`
+	SecretSourceName = "secret"
+)
+
+// How long to wait for the initial list of an object from the API server
+const syncTimeout = 30 * time.Second
+
+// Config for the Kubernetes ConfigMap and Secret config sources.  Values are
+// referred to with paths of the form `<namespace>/<name>/<key>`, where the
+// key can be globbed to get multiple keys, e.g.
+// `k8s:monitoring/agent-config/*.yaml`.  The agent's service account must be
+// allowed to list and watch the ConfigMaps and Secrets that are referred to.
+type Config struct {
+	// Configuration for the K8s API client
+	KubernetesAPI *kubernetes.APIConfig `yaml:"kubernetesAPI" default:"{}"`
+}
+
+// Validate the config
+func (c *Config) Validate() error {
+	return c.KubernetesAPI.Validate()
+}
+
+// NewSources creates the ConfigMap and Secret config sources
+func (c *Config) NewSources() ([]types.ConfigSource, error) {
+	configMaps, secrets, err := New(c)
+	if err != nil {
+		return nil, err
+	}
+	return []types.ConfigSource{configMaps, secrets}, nil
+}
+
+var _ types.MultiConfigSourceConfig = &Config{}
+
+// New creates the ConfigMap and Secret config sources, which share a single
+// API client.
+func New(conf *Config) (configMaps types.ConfigSource, secrets types.ConfigSource, err error) {
+	client, err := kubernetes.MakeClient(conf.KubernetesAPI)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	configMaps = newObjectSource(client, ConfigMapSourceName, "configmaps", &v1.ConfigMap{}, configMapData)
+	secrets = newObjectSource(client, SecretSourceName, "secrets", &v1.Secret{}, secretData)
+
+	return configMaps, secrets, nil
+}
+
+func configMapData(obj interface{}) map[string][]byte {
+	cm := obj.(*v1.ConfigMap)
+	data := make(map[string][]byte, len(cm.Data)+len(cm.BinaryData))
+	for k, v := range cm.Data {
+		data[k] = []byte(v)
+	}
+	for k, v := range cm.BinaryData {
+		data[k] = v
+	}
+	return data
+}
+
+// The client already decodes the base64 encoded values of secrets
+func secretData(obj interface{}) map[string][]byte {
+	return obj.(*v1.Secret).Data
+}
+
+// objectSource is a config source for the keys of a single type of K8s object
+type objectSource struct {
+	sync.Mutex
+	client   *k8s.Clientset
+	name     string
+	resource string
+	objType  runtime.Object
+	// Returns the key/value data of an object
+	dataOf func(interface{}) map[string][]byte
+
+	table    *crc64.Table
+	watchers map[string]*objectWatcher
+	stop     chan struct{}
+}
+
+// objectWatcher keeps an up to date copy of a single object
+type objectWatcher struct {
+	sync.Mutex
+	store      cache.Store
+	controller cache.Controller
+	// Closed and replaced whenever the object changes
+	changed chan struct{}
+}
+
+func (w *objectWatcher) notify() {
+	w.Lock()
+	defer w.Unlock()
+	close(w.changed)
+	w.changed = make(chan struct{})
+}
+
+func (w *objectWatcher) changes() <-chan struct{} {
+	w.Lock()
+	defer w.Unlock()
+	return w.changed
+}
+
+var _ types.Stoppable = &objectSource{}
+
+func newObjectSource(client *k8s.Clientset, name, resource string, objType runtime.Object, dataOf func(interface{}) map[string][]byte) *objectSource {
+	return &objectSource{
+		client:   client,
+		name:     name,
+		resource: resource,
+		objType:  objType,
+		dataOf:   dataOf,
+		table:    crc64.MakeTable(crc64.ECMA),
+		watchers: make(map[string]*objectWatcher),
+		stop:     make(chan struct{}),
+	}
+}
+
+func (s *objectSource) Name() string {
+	return s.name
+}
+
+// Splits a path into the namespace and name of the object and the glob of
+// keys within it
+func parsePath(path string) (namespace string, name string, keys glob.Glob, err error) {
+	parts := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return "", "", nil, fmt.Errorf("path %s should be of the form <namespace>/<name>/<key>", path)
+	}
+
+	keys, err = glob.Compile(parts[2])
+	if err != nil {
+		return "", "", nil, err
+	}
+	return parts[0], parts[1], keys, nil
+}
+
+// Returns the watcher for the given object, starting it and waiting for it
+// to get the object if it isn't already running.
+func (s *objectSource) watcher(namespace, name string) (*objectWatcher, error) {
+	s.Lock()
+	w, ok := s.watchers[namespace+"/"+name]
+	if !ok {
+		w = &objectWatcher{
+			changed: make(chan struct{}),
+		}
+
+		watchList := cache.NewListWatchFromClient(s.client.Core().RESTClient(), s.resource, namespace,
+			fields.OneTermEqualSelector("metadata.name", name))
+
+		w.store, w.controller = cache.NewInformer(watchList, s.objType, 0, cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				w.notify()
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				w.notify()
+			},
+			DeleteFunc: func(obj interface{}) {
+				w.notify()
+			},
+		})
+
+		go w.controller.Run(s.stop)
+		s.watchers[namespace+"/"+name] = w
+	}
+	s.Unlock()
+
+	if !w.controller.HasSynced() {
+		// Closed if it takes too long to sync or the source is stopped
+		syncStop := make(chan struct{})
+		synced := make(chan struct{})
+		defer close(synced)
+		go func() {
+			timer := time.NewTimer(syncTimeout)
+			defer timer.Stop()
+
+			select {
+			case <-timer.C:
+			case <-s.stop:
+			case <-synced:
+				return
+			}
+			close(syncStop)
+		}()
+
+		if !cache.WaitForCacheSync(syncStop, w.controller.HasSynced) {
+			return nil, fmt.Errorf("timed out getting %s %s/%s from the K8s API", s.resource, namespace, name)
+		}
+	}
+	return w, nil
+}
+
+func (s *objectSource) Get(path string) (map[string][]byte, uint64, error) {
+	namespace, name, keys, err := parsePath(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	w, err := s.watcher(namespace, name)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return s.getFromStore(w, namespace, name, keys)
+}
+
+func (s *objectSource) getFromStore(w *objectWatcher, namespace, name string, keys glob.Glob) (map[string][]byte, uint64, error) {
+	objs := w.store.List()
+	if len(objs) == 0 {
+		return nil, 0, types.NewNotFoundError(fmt.Sprintf("%s %s/%s not found", s.resource, namespace, name))
+	}
+
+	data := s.dataOf(objs[0])
+
+	var matches []string
+	for k := range data {
+		if keys.Match(k) {
+			matches = append(matches, k)
+		}
+	}
+	if len(matches) == 0 {
+		return nil, 0, types.NewNotFoundError(fmt.Sprintf("no keys in %s %s/%s matched", s.resource, namespace, name))
+	}
+
+	// sort so the checksum is consistent
+	sort.Strings(matches)
+
+	contentMap := make(map[string][]byte)
+	var sums string
+	for _, k := range matches {
+		contentMap[namespace+"/"+name+"/"+k] = data[k]
+		sums = fmt.Sprintf("%s:%s:%d", sums, k, crc64.Checksum(data[k], s.table))
+	}
+
+	return contentMap, crc64.Checksum([]byte(sums), s.table), nil
+}
+
+// WaitForChange waits for the informer of the object to be notified of a
+// change that affects the keys in the path.
+func (s *objectSource) WaitForChange(path string, version uint64, stop <-chan struct{}) error {
+	namespace, name, keys, err := parsePath(path)
+	if err != nil {
+		return err
+	}
+
+	w, err := s.watcher(namespace, name)
+	if err != nil {
+		return err
+	}
+
+	for {
+		// Get the channel before checking the store so that changes in
+		// between aren't missed
+		changed := w.changes()
+
+		_, newVersion, err := s.getFromStore(w, namespace, name, keys)
+		if err != nil {
+			if _, ok := err.(types.ErrNotFound); !ok {
+				return err
+			}
+		}
+		if newVersion != version {
+			return nil
+		}
+
+		select {
+		case <-stop:
+			return nil
+		case <-changed:
+			log.Debugf("K8s %s %s/%s changed", s.resource, namespace, name)
+		}
+	}
+}
+
+// Stop all of the informers
+func (s *objectSource) Stop() error {
+	close(s.stop)
+	return nil
+}
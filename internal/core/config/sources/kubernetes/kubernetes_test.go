@@ -0,0 +1,221 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/signalfx/signalfx-agent/internal/core/config/types"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	k8s "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// fakeSecretsAPI is a minimal K8s API server that serves list and watch
+// requests for the secrets in a single namespace.  Like the real API server,
+// it narrows the secrets down with the `metadata.name` field selector, which
+// it requires so that the source never watches a whole namespace.
+type fakeSecretsAPI struct {
+	sync.Mutex
+	namespace string
+	secrets   map[string]*v1.Secret
+	watchers  map[string][]chan watch.Event
+	version   int
+	done      chan struct{}
+}
+
+func newFakeSecretsAPI(namespace string) *fakeSecretsAPI {
+	return &fakeSecretsAPI{
+		namespace: namespace,
+		secrets:   make(map[string]*v1.Secret),
+		watchers:  make(map[string][]chan watch.Event),
+		done:      make(chan struct{}),
+	}
+}
+
+// Adds or updates a secret, notifying any watchers of it.  The secret is
+// copied so that the caller can keep changing it.
+func (f *fakeSecretsAPI) update(secret *v1.Secret) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.version++
+	secret = secret.DeepCopy()
+	secret.TypeMeta = metav1.TypeMeta{Kind: "Secret", APIVersion: "v1"}
+	secret.ResourceVersion = strconv.Itoa(f.version)
+
+	eventType := watch.Modified
+	if _, ok := f.secrets[secret.Name]; !ok {
+		eventType = watch.Added
+	}
+	f.secrets[secret.Name] = secret
+
+	for _, ch := range f.watchers[secret.Name] {
+		ch <- watch.Event{Type: eventType, Object: secret}
+	}
+}
+
+func (f *fakeSecretsAPI) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != fmt.Sprintf("/api/v1/namespaces/%s/secrets", f.namespace) {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Query().Get("fieldSelector"), "metadata.name=")
+	if name == "" || name == r.URL.Query().Get("fieldSelector") {
+		rw.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(rw, "secrets must be selected by name")
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	if r.URL.Query().Get("watch") == "true" {
+		f.serveWatch(rw, r, name)
+		return
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	list := &v1.SecretList{
+		TypeMeta: metav1.TypeMeta{Kind: "SecretList", APIVersion: "v1"},
+		ListMeta: metav1.ListMeta{ResourceVersion: strconv.Itoa(f.version)},
+	}
+	if secret, ok := f.secrets[name]; ok {
+		list.Items = append(list.Items, *secret)
+	}
+	json.NewEncoder(rw).Encode(list)
+}
+
+func (f *fakeSecretsAPI) serveWatch(rw http.ResponseWriter, r *http.Request, name string) {
+	events := make(chan watch.Event, 10)
+	f.Lock()
+	f.watchers[name] = append(f.watchers[name], events)
+	f.Unlock()
+
+	defer func() {
+		f.Lock()
+		defer f.Unlock()
+		for i, ch := range f.watchers[name] {
+			if ch == events {
+				f.watchers[name] = append(f.watchers[name][:i], f.watchers[name][i+1:]...)
+				break
+			}
+		}
+	}()
+
+	rw.WriteHeader(http.StatusOK)
+	rw.(http.Flusher).Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-f.done:
+			return
+		case e := <-events:
+			json.NewEncoder(rw).Encode(&metav1.WatchEvent{
+				Type:   string(e.Type),
+				Object: runtime.RawExtension{Object: e.Object},
+			})
+			rw.(http.Flusher).Flush()
+		}
+	}
+}
+
+func TestSecretSource(t *testing.T) {
+	api := newFakeSecretsAPI("monitoring")
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	client, err := k8s.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := newObjectSource(client, SecretSourceName, "secrets", &v1.Secret{}, secretData)
+	defer close(api.done)
+	defer source.Stop()
+
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "monitoring", Name: "db"},
+		Data: map[string][]byte{
+			"username": []byte("admin"),
+			"password": []byte("s3cr3t"),
+		},
+	}
+	api.update(secret)
+	api.update(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "monitoring", Name: "other"},
+		Data:       map[string][]byte{"password": []byte("other")},
+	})
+
+	t.Run("Gets single and globbed keys", func(t *testing.T) {
+		content, _, err := source.Get("monitoring/db/password")
+		assert.Nil(t, err)
+		assert.Equal(t, map[string][]byte{"monitoring/db/password": []byte("s3cr3t")}, content)
+
+		content, _, err = source.Get("monitoring/db/*")
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(content))
+	})
+
+	t.Run("Returns not found errors", func(t *testing.T) {
+		_, _, err := source.Get("monitoring/db/token")
+		assert.IsType(t, types.ErrNotFound{}, err)
+
+		_, _, err = source.Get("monitoring/missing/password")
+		assert.IsType(t, types.ErrNotFound{}, err)
+
+		_, _, err = source.Get("monitoring/db")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("Waits for changes to the keys in the path", func(t *testing.T) {
+		_, version, err := source.Get("monitoring/db/password")
+		assert.Nil(t, err)
+
+		changed := make(chan error)
+		go func() {
+			changed <- source.WaitForChange("monitoring/db/password", version, make(chan struct{}))
+		}()
+
+		// Changes to other keys and other secrets shouldn't count
+		secret.Data["username"] = []byte("root")
+		api.update(secret)
+		api.update(&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "monitoring", Name: "other"},
+			Data:       map[string][]byte{"password": []byte("changed")},
+		})
+
+		select {
+		case <-changed:
+			t.Fatal("WaitForChange returned for an unrelated change")
+		case <-time.After(200 * time.Millisecond):
+		}
+
+		secret.Data["password"] = []byte("n3w")
+		api.update(secret)
+
+		select {
+		case err := <-changed:
+			assert.Nil(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("WaitForChange did not return after the key changed")
+		}
+
+		content, _, err := source.Get("monitoring/db/password")
+		assert.Nil(t, err)
+		assert.Equal(t, map[string][]byte{"monitoring/db/password": []byte("n3w")}, content)
+	})
+}
@@ -0,0 +1,104 @@
+package sources
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// The glob of the files in a config directory that get merged together
+const configDirGlob = "*.yaml"
+
+// Top-level config keys whose lists are appended to by each file in a config
+// directory instead of being overridden
+var appendedConfigKeys = map[string]bool{
+	"monitors":  true,
+	"observers": true,
+}
+
+// mergeConfigFiles merges the given config files into a single config, in
+// lexical order of their paths.  The monitor and observer lists of each file
+// are appended together and maps are merged recursively.  Other values in
+// later files override those in earlier files.
+func mergeConfigFiles(contentMap map[string][]byte) ([]byte, error) {
+	if len(contentMap) == 0 {
+		return nil, errors.New("no config files found")
+	}
+
+	var paths []string
+	for path := range contentMap {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	merged := make(map[interface{}]interface{})
+	// The file that each value in the merged config came from
+	origins := make(map[string]string)
+
+	for _, path := range paths {
+		var content map[interface{}]interface{}
+		if err := yaml.Unmarshal(contentMap[path], &content); err != nil {
+			return nil, errors.WithMessage(err, "could not parse config file "+path)
+		}
+		mergeConfigMaps(merged, content, "", path, origins)
+	}
+
+	return yaml.Marshal(merged)
+}
+
+func mergeConfigMaps(dst, src map[interface{}]interface{}, keyPath string, file string, origins map[string]string) {
+	for k, v := range src {
+		path := fmt.Sprintf("%s%v", keyPath, k)
+
+		existing, ok := dst[k]
+		if !ok {
+			dst[k] = v
+			recordOrigins(v, path, file, origins)
+			continue
+		}
+
+		if keyStr, _ := k.(string); keyPath == "" && appendedConfigKeys[keyStr] {
+			existingSlice, ok1 := existing.([]interface{})
+			newSlice, ok2 := v.([]interface{})
+			if ok1 && ok2 {
+				dst[k] = append(existingSlice, newSlice...)
+				continue
+			}
+		}
+
+		// Dynamic values are replaced as a whole, since merging them with
+		// anything wouldn't make sense
+		existingMap, ok1 := existing.(map[interface{}]interface{})
+		newMap, ok2 := v.(map[interface{}]interface{})
+		if ok1 && ok2 && !isDynamicValue(existing) && !isDynamicValue(v) {
+			mergeConfigMaps(existingMap, newMap, path+".", file, origins)
+			continue
+		}
+
+		if !reflect.DeepEqual(existing, v) {
+			log.WithFields(log.Fields{
+				"key":          path,
+				"file":         file,
+				"overriddenIn": origins[path],
+			}).Warn("Config value in config directory overrides value from an earlier file")
+		}
+		dst[k] = v
+		recordOrigins(v, path, file, origins)
+	}
+}
+
+// Records file as the origin of the value at keyPath and of everything nested
+// in it, so that overrides of nested keys by later files can be attributed
+func recordOrigins(v interface{}, keyPath string, file string, origins map[string]string) {
+	origins[keyPath] = file
+
+	if m, ok := v.(map[interface{}]interface{}); ok && !isDynamicValue(v) {
+		for k, child := range m {
+			recordOrigins(child, fmt.Sprintf("%s.%v", keyPath, k), file, origins)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package sources
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func parseTestYAML(t *testing.T, content string) map[interface{}]interface{} {
+	var out map[interface{}]interface{}
+	assert.Nil(t, yaml.Unmarshal([]byte(content), &out))
+	return out
+}
+
+func TestMergeConfigMapsOrigins(t *testing.T) {
+	merged := make(map[interface{}]interface{})
+	origins := make(map[string]string)
+
+	mergeConfigMaps(merged, parseTestYAML(t, `
+globalDimensions:
+  env: dev
+  team: a
+writer:
+  traceSampling:
+    samplingPercentage: 10
+`), "", "00-base.yaml", origins)
+
+	assert.Equal(t, "00-base.yaml", origins["globalDimensions"])
+	assert.Equal(t, "00-base.yaml", origins["globalDimensions.env"])
+	assert.Equal(t, "00-base.yaml", origins["writer.traceSampling.samplingPercentage"])
+
+	mergeConfigMaps(merged, parseTestYAML(t, `
+globalDimensions:
+  env: prod
+`), "", "10-prod.yaml", origins)
+
+	assert.Equal(t, "10-prod.yaml", origins["globalDimensions.env"])
+	assert.Equal(t, "00-base.yaml", origins["globalDimensions.team"])
+	assert.Equal(t, map[interface{}]interface{}{"env": "prod", "team": "a"}, merged["globalDimensions"])
+}
+
+func TestMergeConfigFilesRequiresFiles(t *testing.T) {
+	_, err := mergeConfigFiles(map[string][]byte{})
+	assert.NotNil(t, err)
+
+	content, err := mergeConfigFiles(map[string][]byte{"a.yaml": []byte("intervalSeconds: 5")})
+	assert.Nil(t, err)
+	assert.Equal(t, "intervalSeconds: 5\n", string(content))
+}
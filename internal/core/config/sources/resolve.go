@@ -2,13 +2,14 @@ package sources
 
 import (
 	"fmt"
+	"sort"
 
 	"github.com/pkg/errors"
 
 	yaml "gopkg.in/yaml.v2"
 )
 
-type resolveFunc func(v RawDynamicValueSpec) ([]interface{}, string, *dynamicValueSpec, error)
+type resolveFunc func(v RawDynamicValueSpec) ([]interface{}, []string, *dynamicValueSpec, error)
 
 // The resolver is what aggregates together multiple source caches and converts
 // raw dynamic value specs (e.g. the {"#from": ...} values) to the actual
@@ -23,50 +24,99 @@ func newResolver(sources map[string]*configSourceCacher) *resolver {
 	}
 }
 
-func (r *resolver) Resolve(raw RawDynamicValueSpec) ([]interface{}, string, *dynamicValueSpec, error) {
+func (r *resolver) Resolve(raw RawDynamicValueSpec) ([]interface{}, []string, *dynamicValueSpec, error) {
 	spec, err := parseRawSpec(raw)
 	if err != nil {
-		return nil, "", nil, err
+		return nil, nil, nil, err
 	}
 
-	sourceName := spec.From.SourceName()
-	source, ok := r.sources[sourceName]
-	if !ok {
-		return nil, "", nil, fmt.Errorf("Source '%s' is not configured", sourceName)
-	}
+	var content []pathContent
+	var paths []string
+	found := 0
+	for _, from := range spec.From {
+		sourceName := from.SourceName()
+		source, ok := r.sources[sourceName]
+		if !ok {
+			return nil, nil, nil, fmt.Errorf("Source '%s' is not configured", sourceName)
+		}
 
-	contentMap, err := source.Get(spec.From.Path(), spec.Optional)
-	if err != nil {
-		return nil, "", nil, errors.WithMessage(err,
-			"could not resolve path "+spec.From.String())
+		contentMap, err := source.Get(from.Path(), spec.Optional)
+		if err != nil {
+			return nil, nil, nil, errors.WithMessage(err,
+				"could not resolve path "+from.String())
+		}
+		found += len(contentMap)
+		content = append(content, sortedContent(contentMap)...)
+		paths = append(paths, from.Path())
 	}
 
 	var value []interface{}
-	if len(contentMap) == 0 && spec.Default != nil {
+	if found == 0 && spec.Default != nil {
 		value = []interface{}{
 			spec.Default,
 		}
 	} else {
-		value, err = convertFileBytesToValues(contentMap, spec.Raw)
+		value, err = convertFileBytesToValues(content, spec.Raw, spec.Transform)
+		if err != nil {
+			err = errors.WithMessage(err, "could not process value of "+spec.From.String())
+		}
 	}
 
-	return value, spec.From.Path(), spec, err
+	return value, paths, spec, err
 }
 
-func convertFileBytesToValues(content map[string][]byte, raw bool) ([]interface{}, error) {
+// pathContent is the content of a single path that was read, which for a
+// globbed path is one of the paths that matched
+type pathContent struct {
+	path    string
+	content []byte
+}
+
+// Returns the non-empty content sorted by path so that the order of values,
+// e.g. when joined, doesn't change between reads
+func sortedContent(contentMap map[string][]byte) []pathContent {
+	var out []pathContent
+	for path := range contentMap {
+		if len(contentMap[path]) == 0 {
+			continue
+		}
+		out = append(out, pathContent{path: path, content: contentMap[path]})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].path < out[j].path
+	})
+	return out
+}
+
+func convertFileBytesToValues(content []pathContent, raw bool, ts transforms) ([]interface{}, error) {
+	values := make([]interface{}, len(content))
+	for i := range content {
+		values[i] = sourceText(content[i].content)
+	}
+
+	values, err := applyTransforms(ts, values)
+	if err != nil {
+		return nil, err
+	}
+
 	var out []interface{}
-	for path := range content {
-		if len(content[path]) == 0 {
+	for i := range values {
+		text, ok := values[i].(sourceText)
+		if !ok {
+			out = append(out, values[i])
 			continue
 		}
 
 		var v interface{}
 		if raw {
-			v = string(content[path])
+			v = string(text)
 		} else {
-			err := yaml.Unmarshal(content[path], &v)
+			err := yaml.Unmarshal([]byte(text), &v)
 			if err != nil {
-				return nil, errors.WithMessage(err, "deserialization error at path "+path)
+				if len(content) == len(values) {
+					return nil, errors.WithMessage(err, "deserialization error at path "+content[i].path)
+				}
+				return nil, errors.WithMessage(err, "deserialization error")
 			}
 		}
 
@@ -6,7 +6,10 @@ package sources
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sync"
 	"time"
 
 	"github.com/creasty/defaults"
@@ -15,7 +18,10 @@ import (
 	"github.com/signalfx/signalfx-agent/internal/core/config/sources/consul"
 	"github.com/signalfx/signalfx-agent/internal/core/config/sources/env"
 	"github.com/signalfx/signalfx-agent/internal/core/config/sources/etcd2"
+	"github.com/signalfx/signalfx-agent/internal/core/config/sources/etcd3"
 	"github.com/signalfx/signalfx-agent/internal/core/config/sources/file"
+	"github.com/signalfx/signalfx-agent/internal/core/config/sources/http"
+	"github.com/signalfx/signalfx-agent/internal/core/config/sources/kubernetes"
 	"github.com/signalfx/signalfx-agent/internal/core/config/sources/vault"
 	"github.com/signalfx/signalfx-agent/internal/core/config/sources/zookeeper"
 	"github.com/signalfx/signalfx-agent/internal/core/config/types"
@@ -45,10 +51,18 @@ type SourceConfig struct {
 	Zookeeper *zookeeper.Config `yaml:"zookeeper"`
 	// Configuration for an Etcd 2 remote config source
 	Etcd2 *etcd2.Config `yaml:"etcd2"`
+	// Configuration for an Etcd 3 remote config source
+	Etcd3 *etcd3.Config `yaml:"etcd3"`
 	// Configuration for a Consul remote config source
 	Consul *consul.Config `yaml:"consul"`
 	// Configuration for a Hashicorp Vault remote config source
 	Vault *vault.Config `yaml:"vault"`
+	// Configuration for Kubernetes ConfigMap (`k8s:`) and Secret (`secret:`)
+	// remote config sources
+	Kubernetes *kubernetes.Config `yaml:"kubernetes"`
+	// Configuration for a remote config source that fetches HTTP(S) URLs
+	// (`http:`)
+	HTTP *http.Config `yaml:"http"`
 }
 
 // Hash calculates a unique hash value for this config struct
@@ -63,6 +77,12 @@ func (sc *SourceConfig) Hash() uint64 {
 
 // SourceInstances returns a map of instantiated sources based on the config
 func (sc *SourceConfig) SourceInstances() (map[string]types.ConfigSource, error) {
+	return sc.sourceInstances(false)
+}
+
+// If noRemote is true, the remote source configs are still validated but
+// stubs are used in place of the actual sources so that nothing is contacted.
+func (sc *SourceConfig) sourceInstances(noRemote bool) (map[string]types.ConfigSource, error) {
 	sources := make(map[string]types.ConfigSource)
 
 	file := file.New(time.Duration(sc.File.PollRateSeconds) * time.Second)
@@ -71,12 +91,19 @@ func (sc *SourceConfig) SourceInstances() (map[string]types.ConfigSource, error)
 	env := env.New()
 	sources[env.Name()] = env
 
-	for _, csc := range []types.ConfigSourceConfig{
-		sc.Zookeeper,
-		sc.Etcd2,
-		sc.Consul,
-		sc.Vault,
+	for _, remote := range []struct {
+		names []string
+		csc   validation.Validatable
+	}{
+		{[]string{"zookeeper"}, sc.Zookeeper},
+		{[]string{"etcd2"}, sc.Etcd2},
+		{[]string{"etcd3"}, sc.Etcd3},
+		{[]string{"consul"}, sc.Consul},
+		{[]string{"vault"}, sc.Vault},
+		{[]string{kubernetes.ConfigMapSourceName, kubernetes.SecretSourceName}, sc.Kubernetes},
+		{[]string{"http"}, sc.HTTP},
 	} {
+		csc := remote.csc
 		if !reflect.ValueOf(csc).IsNil() {
 			err := defaults.Set(csc)
 			if err != nil {
@@ -91,11 +118,28 @@ func (sc *SourceConfig) SourceInstances() (map[string]types.ConfigSource, error)
 				return nil, errors.WithMessage(err, fmt.Sprintf("error validating remote config sources"))
 			}
 
-			s, err := csc.New()
+			if noRemote {
+				for _, name := range remote.names {
+					sources[name] = &stubConfigSource{name: name}
+				}
+				continue
+			}
+
+			var instances []types.ConfigSource
+			switch c := csc.(type) {
+			case types.MultiConfigSourceConfig:
+				instances, err = c.NewSources()
+			case types.ConfigSourceConfig:
+				var s types.ConfigSource
+				s, err = c.New()
+				instances = []types.ConfigSource{s}
+			}
 			if err != nil {
 				return nil, errors.WithMessage(err, "error initializing remote config source")
 			}
-			sources[s.Name()] = s
+			for _, s := range instances {
+				sources[s.Name()] = s
+			}
 		}
 	}
 	return sources, nil
@@ -121,22 +165,30 @@ func parseSourceConfig(config []byte) (SourceConfig, error) {
 
 // ReadConfig reads in the main agent config file and optionally watches for
 // changes on it.  It will be returned immediately, along with a channel that
-// will be sent any updated config content if watching is enabled.
+// will be sent any updated config content if watching is enabled.  If
+// configPath is a directory, all of the YAML files in it are merged together
+// into the config, and files that are added to or removed from it are picked
+// up when watching.
 func ReadConfig(configPath string, stop <-chan struct{}) ([]byte, <-chan []byte, error) {
+	readPath := configPath
+	isDir := false
+	if info, err := os.Stat(configPath); err == nil && info.IsDir() {
+		readPath = filepath.Join(configPath, configDirGlob)
+		isDir = true
+	}
+
 	// Fetch the config file with a dummy file source since we don't know what
 	// poll rate to configure on it yet.
-	contentMap, version, err := file.New(1 * time.Second).Get(configPath)
+	contentMap, version, err := file.New(1 * time.Second).Get(readPath)
 	if err != nil {
 		return nil, nil, err
 	}
-	if len(contentMap) > 1 {
-		return nil, nil, fmt.Errorf("Path %s resulted in multiple files", configPath)
-	}
-	if len(contentMap) == 0 {
-		return nil, nil, fmt.Errorf("Config file %s could not be found", configPath)
+
+	configContent, err := configFromFiles(configPath, contentMap, isDir)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	configContent := contentMap[configPath]
 	sourceConfig, err := parseSourceConfig(configContent)
 	if err != nil {
 		return nil, nil, err
@@ -151,7 +203,7 @@ func ReadConfig(configPath string, stop <-chan struct{}) ([]byte, <-chan []byte,
 		changes := make(chan []byte)
 		go func() {
 			for {
-				err := fileSource.WaitForChange(configPath, version, stop)
+				err := fileSource.WaitForChange(readPath, version, stop)
 
 				if utils.IsSignalChanClosed(stop) {
 					return
@@ -165,14 +217,20 @@ func ReadConfig(configPath string, stop <-chan struct{}) ([]byte, <-chan []byte,
 
 				log.Info("Config file changed")
 
-				contentMap, version, err = fileSource.Get(configPath)
+				contentMap, version, err = fileSource.Get(readPath)
 				if err != nil {
 					log.WithError(err).Error("Could not get config file after it was changed")
 					time.Sleep(5 * time.Second)
 					continue
 				}
 
-				changes <- contentMap[configPath]
+				content, err := configFromFiles(configPath, contentMap, isDir)
+				if err != nil {
+					log.WithError(err).Error("Could not read config after it was changed")
+					continue
+				}
+
+				changes <- content
 			}
 		}()
 		return configContent, changes, nil
@@ -181,11 +239,65 @@ func ReadConfig(configPath string, stop <-chan struct{}) ([]byte, <-chan []byte,
 	return configContent, nil, nil
 }
 
+func configFromFiles(configPath string, contentMap map[string][]byte, isDir bool) ([]byte, error) {
+	if isDir {
+		content, err := mergeConfigFiles(contentMap)
+		if err != nil {
+			return nil, errors.WithMessage(err, "could not read config directory "+configPath)
+		}
+		return content, nil
+	}
+	if len(contentMap) > 1 {
+		return nil, fmt.Errorf("Path %s resulted in multiple files", configPath)
+	}
+	if len(contentMap) == 0 {
+		return nil, fmt.Errorf("Config file %s could not be found", configPath)
+	}
+	return contentMap[configPath], nil
+}
+
 // DynamicValueProvider handles setting up and providing dynamic values from
 // remote config sources.
 type DynamicValueProvider struct {
+	// If true, remote config sources are never contacted and dynamic values
+	// from them resolve to their default, or are left empty if they have
+	// none.
+	NoRemote bool
+
 	lastRemoteConfigSourceHash uint64
 	sources                    map[string]types.ConfigSource
+
+	lock             sync.Mutex
+	lastValueSources ValueSources
+}
+
+// ValueSources returns where each of the dynamic values in the most recently
+// rendered config came from.
+func (dvp *DynamicValueProvider) ValueSources() ValueSources {
+	dvp.lock.Lock()
+	defer dvp.lock.Unlock()
+	return dvp.lastValueSources
+}
+
+func (dvp *DynamicValueProvider) setValueSources(vs ValueSources) {
+	dvp.lock.Lock()
+	defer dvp.lock.Unlock()
+	dvp.lastValueSources = vs
+}
+
+// Stop stops the remote config sources that keep watches, logins or
+// connections alive.  Reading dynamic values again creates new sources.
+func (dvp *DynamicValueProvider) Stop() {
+	for name, source := range dvp.sources {
+		if stoppable, ok := source.(types.Stoppable); ok {
+			log.Infof("Stopping %s remote config source", name)
+			if err := stoppable.Stop(); err != nil {
+				log.WithError(err).Errorf("Could not stop %s remote config source", name)
+			}
+		}
+	}
+	dvp.sources = nil
+	dvp.lastRemoteConfigSourceHash = 0
 }
 
 // ReadDynamicValues takes the config file content and processes it for any
@@ -201,15 +313,9 @@ func (dvp *DynamicValueProvider) ReadDynamicValues(configContent []byte, stop <-
 
 	hash := sourceConfig.Hash()
 	if hash != dvp.lastRemoteConfigSourceHash {
-		for name, source := range dvp.sources {
-			if stoppable, ok := source.(types.Stoppable); ok {
-				log.Infof("Stopping stale %s remote config source", name)
-				if err := stoppable.Stop(); err != nil {
-					log.WithError(err).Errorf("Could not stop stale %s remote config source", name)
-				}
-			}
-		}
-		dvp.sources, err = sourceConfig.SourceInstances()
+		// The old sources are stale
+		dvp.Stop()
+		dvp.sources, err = sourceConfig.sourceInstances(dvp.NoRemote)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -228,10 +334,11 @@ func (dvp *DynamicValueProvider) ReadDynamicValues(configContent []byte, stop <-
 
 	resolver := newResolver(cachers)
 
-	renderedContent, err := renderDynamicValues(configContent, resolver.Resolve)
+	renderedContent, valueSources, err := renderDynamicValues(configContent, resolver.Resolve)
 	if err != nil {
 		return nil, nil, err
 	}
+	dvp.setValueSources(valueSources)
 
 	var changes chan []byte
 	if sourceConfig.Watch {
@@ -243,12 +350,13 @@ func (dvp *DynamicValueProvider) ReadDynamicValues(configContent []byte, stop <-
 				case path := <-pathChanges:
 					log.Debugf("Dynamic value path %s changed", path)
 
-					renderedContent, err = renderDynamicValues(configContent, resolver.Resolve)
+					renderedContent, valueSources, err = renderDynamicValues(configContent, resolver.Resolve)
 					if err != nil {
 						log.WithError(err).Error("Could not render dynamic values in config after change")
 						time.Sleep(5 * time.Second)
 						continue
 					}
+					dvp.setValueSources(valueSources)
 
 					changes <- renderedContent
 				case <-stop:
@@ -10,11 +10,13 @@ import (
 )
 
 type dynamicValueSpec struct {
-	From     *fromPath   `yaml:"#from"`
+	From     fromPaths   `yaml:"#from"`
 	Flatten  bool        `yaml:"flatten"`
 	Optional bool        `yaml:"optional"`
 	Raw      bool        `yaml:"raw"`
 	Default  interface{} `yaml:"default"`
+	// Transforms to apply to the values before they are deserialized
+	Transform transforms `yaml:"transform"`
 }
 
 type fromPath struct {
@@ -60,6 +62,45 @@ func (fp *fromPath) String() string {
 	return fp.SourceName() + ":" + fp.Path()
 }
 
+// fromPaths is the value of `#from`, which is either a single path or a list
+// of paths whose values are all read in order, e.g. so that they can be
+// joined.
+type fromPaths []*fromPath
+
+func (fps *fromPaths) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var v interface{}
+	if err := unmarshal(&v); err != nil {
+		return err
+	}
+
+	if _, ok := v.([]interface{}); !ok {
+		var fp fromPath
+		if err := unmarshal(&fp); err != nil {
+			return err
+		}
+		*fps = fromPaths{&fp}
+		return nil
+	}
+
+	var list []*fromPath
+	if err := unmarshal(&list); err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		return errors.New("#from list is empty")
+	}
+	*fps = list
+	return nil
+}
+
+func (fps fromPaths) String() string {
+	strs := make([]string, len(fps))
+	for i := range fps {
+		strs[i] = fps[i].String()
+	}
+	return strings.Join(strs, ", ")
+}
+
 // RawDynamicValueSpec is a string that should deserialize to a dynamic value
 // path (e.g. {"#from": "/path/to/value"}).
 type RawDynamicValueSpec interface{}
@@ -73,7 +114,7 @@ func parseRawSpec(r RawDynamicValueSpec) (*dynamicValueSpec, error) {
 	var dvs dynamicValueSpec
 	err = yaml.UnmarshalStrict(text, &dvs)
 
-	if dvs.From == nil {
+	if len(dvs.From) == 0 {
 		// We should never get here for any given user input if the calling
 		// code is doing its job.
 		return nil, errors.New("#from field is missing")
@@ -0,0 +1,27 @@
+package sources
+
+import (
+	"github.com/signalfx/signalfx-agent/internal/core/config/types"
+)
+
+// stubConfigSource stands in for a remote config source when remote sources
+// shouldn't be contacted.  It never has any content, so dynamic values from
+// it resolve to their default, if any.
+type stubConfigSource struct {
+	name string
+}
+
+var _ types.ConfigSource = &stubConfigSource{}
+
+func (s *stubConfigSource) Name() string {
+	return s.name
+}
+
+func (s *stubConfigSource) Get(path string) (map[string][]byte, uint64, error) {
+	return map[string][]byte{}, 0, nil
+}
+
+func (s *stubConfigSource) WaitForChange(path string, version uint64, stop <-chan struct{}) error {
+	<-stop
+	return nil
+}
@@ -0,0 +1,306 @@
+package sources
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// sourceText is content that hasn't been deserialized yet, either as it came
+// from the source or as produced by a transform.  It is deserialized as YAML
+// once all of the transforms have run, unless the value is raw.
+type sourceText string
+
+// valueTransform is a single step of the `transform` list of a dynamic value.
+// Transforms run in order on all of the values that are read from the path.
+type valueTransform interface {
+	apply(values []interface{}) ([]interface{}, error)
+}
+
+type transforms []valueTransform
+
+// UnmarshalYAML accepts a list where each item is either the name of a
+// transform that takes no argument (e.g. `base64`) or a map with the name as
+// the only key and the argument as the value (e.g. `{jsonpath: .a.b}`).
+func (ts *transforms) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var items []interface{}
+	if err := unmarshal(&items); err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		t, err := parseTransform(item)
+		if err != nil {
+			return err
+		}
+		*ts = append(*ts, t)
+	}
+	return nil
+}
+
+func parseTransform(item interface{}) (valueTransform, error) {
+	var name string
+	var arg *string
+
+	switch v := item.(type) {
+	case string:
+		name = v
+	case map[interface{}]interface{}:
+		if len(v) != 1 {
+			return nil, fmt.Errorf("transform %v should have a single key", v)
+		}
+		for k, a := range v {
+			name = fmt.Sprint(k)
+			s, ok := a.(string)
+			if !ok {
+				return nil, fmt.Errorf("the argument of transform %s should be a string", name)
+			}
+			arg = &s
+		}
+	default:
+		return nil, fmt.Errorf("transform %v should be a name or a map of name to argument", item)
+	}
+
+	needsArg := func() (string, error) {
+		if arg == nil {
+			return "", fmt.Errorf("transform %s requires an argument", name)
+		}
+		return *arg, nil
+	}
+
+	switch name {
+	case "base64":
+		return textTransform(decodeBase64), nil
+	case "trim":
+		return textTransform(func(s string) (string, error) {
+			return strings.TrimSpace(s), nil
+		}), nil
+	case "jsonpath", "yamlpath":
+		a, err := needsArg()
+		if err != nil {
+			return nil, err
+		}
+		path, err := parseExtractPath(a)
+		if err != nil {
+			return nil, err
+		}
+		return path, nil
+	case "template":
+		a, err := needsArg()
+		if err != nil {
+			return nil, err
+		}
+		tmpl, err := template.New("transform").Option("missingkey=error").Parse(a)
+		if err != nil {
+			return nil, errors.WithMessage(err, "could not parse template transform")
+		}
+		return (*templateTransform)(tmpl), nil
+	case "join":
+		a, err := needsArg()
+		if err != nil {
+			return nil, err
+		}
+		return joinTransform(a), nil
+	}
+	return nil, fmt.Errorf("unknown transform %s", name)
+}
+
+func applyTransforms(ts transforms, values []interface{}) ([]interface{}, error) {
+	var err error
+	for _, t := range ts {
+		values, err = t.apply(values)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// Returns the text form of a value for transforms that work on strings
+func textOf(v interface{}) (string, error) {
+	switch val := v.(type) {
+	case sourceText:
+		return string(val), nil
+	case string:
+		return val, nil
+	case int, float64, bool:
+		return fmt.Sprint(val), nil
+	}
+	return "", fmt.Errorf("expected a string value but got %v", v)
+}
+
+// textTransform applies a function to the text of each value
+type textTransform func(string) (string, error)
+
+func (t textTransform) apply(values []interface{}) ([]interface{}, error) {
+	out := make([]interface{}, len(values))
+	for i := range values {
+		s, err := textOf(values[i])
+		if err != nil {
+			return nil, err
+		}
+		s, err = t(s)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = sourceText(s)
+	}
+	return out, nil
+}
+
+func decodeBase64(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	decoded, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		// Also accept unpadded values
+		decoded, err = base64.RawStdEncoding.DecodeString(s)
+		if err != nil {
+			return "", errors.WithMessage(err, "could not decode base64 value")
+		}
+	}
+	return string(decoded), nil
+}
+
+// templateTransform renders a Go template with each value as `.`
+type templateTransform template.Template
+
+func (t *templateTransform) apply(values []interface{}) ([]interface{}, error) {
+	out := make([]interface{}, len(values))
+	for i := range values {
+		data := values[i]
+		if s, ok := data.(sourceText); ok {
+			data = string(s)
+		}
+
+		var sb strings.Builder
+		if err := (*template.Template)(t).Execute(&sb, data); err != nil {
+			return nil, errors.WithMessage(err, "could not render template transform")
+		}
+		out[i] = sourceText(sb.String())
+	}
+	return out, nil
+}
+
+// joinTransform combines all of the values into one, with the separator in
+// between.  The values are those of all of the `#from` paths in order, with
+// globbed paths sorted.
+type joinTransform string
+
+func (t joinTransform) apply(values []interface{}) ([]interface{}, error) {
+	if len(values) == 0 {
+		return values, nil
+	}
+
+	parts := make([]string, len(values))
+	for i := range values {
+		s, err := textOf(values[i])
+		if err != nil {
+			return nil, err
+		}
+		parts[i] = s
+	}
+	return []interface{}{sourceText(strings.Join(parts, string(t)))}, nil
+}
+
+// extractPath is a path to a value within a JSON or YAML document of the form
+// `.key.nested[0].key`.  Each element is either a map key or a slice index.
+type extractPath struct {
+	text  string
+	elems []interface{}
+}
+
+func parseExtractPath(text string) (*extractPath, error) {
+	p := &extractPath{text: text}
+
+	rest := strings.TrimPrefix(text, "$")
+	for len(rest) > 0 {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			continue
+		case '[':
+			end := strings.Index(rest, "]")
+			if end == -1 {
+				return nil, fmt.Errorf("unterminated [ in path %s", text)
+			}
+			idx, err := strconv.Atoi(rest[1:end])
+			if err != nil {
+				return nil, fmt.Errorf("invalid index %s in path %s", rest[1:end], text)
+			}
+			p.elems = append(p.elems, idx)
+			rest = rest[end+1:]
+			continue
+		}
+
+		end := strings.IndexAny(rest, ".[")
+		if end == -1 {
+			end = len(rest)
+		}
+		p.elems = append(p.elems, rest[:end])
+		rest = rest[end:]
+	}
+
+	return p, nil
+}
+
+// apply extracts the value at the path of each value, deserializing any text
+// values first.  The extracted values are used as is and not deserialized
+// again.
+func (p *extractPath) apply(values []interface{}) ([]interface{}, error) {
+	out := make([]interface{}, len(values))
+	for i := range values {
+		v := values[i]
+		var text string
+		switch val := v.(type) {
+		case sourceText:
+			text = string(val)
+		case string:
+			text = val
+		}
+		if text != "" {
+			v = nil
+			if err := yaml.Unmarshal([]byte(text), &v); err != nil {
+				return nil, errors.WithMessage(err, "could not deserialize value to extract "+p.text)
+			}
+		}
+
+		for _, elem := range p.elems {
+			var ok bool
+			v, ok = lookupElem(v, elem)
+			if !ok {
+				return nil, fmt.Errorf("path %s not found in value", p.text)
+			}
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+func lookupElem(v interface{}, elem interface{}) (interface{}, bool) {
+	switch val := v.(type) {
+	case map[interface{}]interface{}:
+		child, ok := val[elem]
+		if !ok {
+			// Keys that look like numbers are decoded as ints
+			if s, isString := elem.(string); isString {
+				if n, err := strconv.Atoi(s); err == nil {
+					child, ok = val[n]
+				}
+			}
+		}
+		return child, ok
+	case []interface{}:
+		idx, ok := elem.(int)
+		if !ok || idx < 0 || idx >= len(val) {
+			return nil, false
+		}
+		return val[idx], true
+	}
+	return nil, false
+}
@@ -0,0 +1,127 @@
+package sources
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestParseExtractPath(t *testing.T) {
+	for _, tc := range []struct {
+		text  string
+		elems []interface{}
+		err   bool
+	}{
+		{".a.b", []interface{}{"a", "b"}, false},
+		{"$.a.b", []interface{}{"a", "b"}, false},
+		{".servers[0].host", []interface{}{"servers", 0, "host"}, false},
+		{"[1][2]", []interface{}{1, 2}, false},
+		// Numeric map keys stay strings and are only tried as ints on lookup
+		{".ports.8080", []interface{}{"ports", "8080"}, false},
+		{".a..b", []interface{}{"a", "b"}, false},
+		{"", nil, false},
+		{".servers[0", nil, true},
+		{".servers[first]", nil, true},
+		{".servers[]", nil, true},
+	} {
+		p, err := parseExtractPath(tc.text)
+		if tc.err {
+			assert.NotNil(t, err, tc.text)
+			continue
+		}
+		assert.Nil(t, err, tc.text)
+		assert.Equal(t, tc.elems, p.elems, tc.text)
+	}
+}
+
+func TestLookupElem(t *testing.T) {
+	m := map[interface{}]interface{}{
+		"a":  1,
+		8080: "int key",
+		"10": "string key",
+		10:   "shadowed int key",
+	}
+	s := []interface{}{"x", "y"}
+
+	for _, tc := range []struct {
+		desc     string
+		v        interface{}
+		elem     interface{}
+		expected interface{}
+		ok       bool
+	}{
+		{"map key", m, "a", 1, true},
+		{"numeric key decoded as an int", m, "8080", "int key", true},
+		{"string key preferred over int key", m, "10", "string key", true},
+		{"missing map key", m, "b", nil, false},
+		{"index", s, 1, "y", true},
+		{"index out of range", s, 2, nil, false},
+		{"negative index", s, -1, nil, false},
+		{"key of a slice", s, "0", nil, false},
+		{"index of a map", m, 0, nil, false},
+		{"scalar", "x", "a", nil, false},
+		{"nil", nil, "a", nil, false},
+	} {
+		v, ok := lookupElem(tc.v, tc.elem)
+		assert.Equal(t, tc.ok, ok, tc.desc)
+		assert.Equal(t, tc.expected, v, tc.desc)
+	}
+}
+
+func TestExtractPathApply(t *testing.T) {
+	extract := func(path string, content string) (interface{}, error) {
+		p, err := parseExtractPath(path)
+		if err != nil {
+			return nil, err
+		}
+		out, err := p.apply([]interface{}{sourceText(content)})
+		if err != nil {
+			return nil, err
+		}
+		return out[0], nil
+	}
+
+	t.Run("Extracts numeric keys from JSON and YAML", func(t *testing.T) {
+		v, err := extract(".ports.8080", `{"ports": {"8080": "http"}}`)
+		assert.Nil(t, err)
+		assert.Equal(t, "http", v)
+
+		v, err = extract(".ports.8080", "ports:\n  8080: http\n")
+		assert.Nil(t, err)
+		assert.Equal(t, "http", v)
+	})
+
+	t.Run("Errors on out of range indexes", func(t *testing.T) {
+		_, err := extract(".hosts[2]", `{"hosts": ["db1", "db2"]}`)
+		assert.EqualError(t, err, "path .hosts[2] not found in value")
+	})
+
+	t.Run("Keeps extracted values as they are", func(t *testing.T) {
+		v, err := extract(".creds", `{"creds": {"password": "0123"}}`)
+		assert.Nil(t, err)
+		assert.Equal(t, map[interface{}]interface{}{"password": "0123"}, v)
+	})
+}
+
+func TestFromPaths(t *testing.T) {
+	parse := func(content string) (*dynamicValueSpec, error) {
+		var raw interface{}
+		assert.Nil(t, yaml.Unmarshal([]byte(content), &raw))
+		return parseRawSpec(raw)
+	}
+
+	spec, err := parse(`{"#from": "consul:db/host"}`)
+	assert.Nil(t, err)
+	assert.Equal(t, "consul:db/host", spec.From.String())
+
+	spec, err = parse(`{"#from": ["consul:db/host", "env:DB_PORT", "/etc/db"], transform: [join: ":"]}`)
+	assert.Nil(t, err)
+	assert.Equal(t, "consul:db/host, env:DB_PORT, file:/etc/db", spec.From.String())
+
+	_, err = parse(`{"#from": []}`)
+	assert.NotNil(t, err)
+
+	_, err = parse(`{"#from": ["consul:db/host", ""]}`)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,119 @@
+package vault
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+const (
+	authMethodToken      = "token"
+	authMethodAppRole    = "approle"
+	authMethodKubernetes = "kubernetes"
+)
+
+// How long to wait between failed login attempts, doubling up to the max
+const (
+	minLoginRetryInterval = 5 * time.Second
+	maxLoginRetryInterval = 5 * time.Minute
+)
+
+// AppRoleConfig is the config for the AppRole auth method
+type AppRoleConfig struct {
+	// The role ID to log in with
+	RoleID string `yaml:"roleID"`
+	// The secret ID to log in with.  Can be omitted if the role doesn't
+	// require one.
+	SecretID string `yaml:"secretID" neverLog:"true"`
+	// The path that the AppRole auth method is mounted at
+	MountPath string `yaml:"mountPath" default:"approle"`
+}
+
+// KubernetesAuthConfig is the config for the Kubernetes auth method
+type KubernetesAuthConfig struct {
+	// The Vault role to log in as
+	Role string `yaml:"role"`
+	// The path to the service account token to log in with.  It is read
+	// again on every login since it may be rotated.
+	ServiceAccountTokenPath string `yaml:"serviceAccountTokenPath" default:"/var/run/secrets/kubernetes.io/serviceaccount/token"`
+	// The path that the Kubernetes auth method is mounted at
+	MountPath string `yaml:"mountPath" default:"kubernetes"`
+}
+
+// Returns the path and data of the login request for the auth method
+func (c *Config) loginRequest() (string, map[string]interface{}, error) {
+	switch c.AuthMethod {
+	case authMethodAppRole:
+		data := map[string]interface{}{
+			"role_id": c.AppRole.RoleID,
+		}
+		if c.AppRole.SecretID != "" {
+			data["secret_id"] = c.AppRole.SecretID
+		}
+		return loginPath(c.AppRole.MountPath), data, nil
+	case authMethodKubernetes:
+		jwt, err := ioutil.ReadFile(c.Kubernetes.ServiceAccountTokenPath)
+		if err != nil {
+			return "", nil, errors.WithMessage(err, "could not read service account token")
+		}
+		return loginPath(c.Kubernetes.MountPath), map[string]interface{}{
+			"role": c.Kubernetes.Role,
+			"jwt":  strings.TrimSpace(string(jwt)),
+		}, nil
+	}
+	return "", nil, fmt.Errorf("auth method %s does not log in", c.AuthMethod)
+}
+
+func loginPath(mountPath string) string {
+	return "auth/" + strings.Trim(mountPath, "/") + "/login"
+}
+
+// Logs in with the configured auth method and makes the client use the new
+// token
+func (v *vaultConfigSource) login() (*api.Secret, error) {
+	path, data, err := v.conf.loginRequest()
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := v.client.Logical().Write(path, data)
+	if err != nil {
+		return nil, errors.WithMessage(err, fmt.Sprintf("could not log in to Vault with the %s auth method", v.conf.AuthMethod))
+	}
+	if secret == nil || secret.Auth == nil || secret.Auth.ClientToken == "" {
+		return nil, fmt.Errorf("login to Vault with the %s auth method returned no token", v.conf.AuthMethod)
+	}
+
+	v.client.SetToken(secret.Auth.ClientToken)
+	logger.Infof("Logged in to Vault with the %s auth method", v.conf.AuthMethod)
+
+	return secret, nil
+}
+
+// Keeps trying to log in until it works or the source is stopped, in which
+// case it returns nil
+func (v *vaultConfigSource) loginWithRetry() *api.Secret {
+	interval := minLoginRetryInterval
+	for {
+		secret, err := v.login()
+		if err == nil {
+			return secret
+		}
+		logger.WithError(err).Errorf("Could not log in to Vault, retrying in %s", interval)
+
+		select {
+		case <-v.stop:
+			return nil
+		case <-time.After(interval):
+		}
+
+		interval *= 2
+		if interval > maxLoginRetryInterval {
+			interval = maxLoginRetryInterval
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/creasty/defaults"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeVault is a Vault server that supports just enough of the API to log in
+// and read a secret with a lease
+type fakeVault struct {
+	sync.Mutex
+	t *testing.T
+	// The login requests that were made, keyed by path
+	logins map[string][]map[string]interface{}
+	// The lease duration of login tokens, which aren't renewable
+	tokenTTL int
+}
+
+func (f *fakeVault) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	switch r.URL.Path {
+	case "/v1/auth/approle/login", "/v1/auth/k8s/login":
+		var data map[string]interface{}
+		assert.Nil(f.t, json.NewDecoder(r.Body).Decode(&data))
+		f.logins[r.URL.Path] = append(f.logins[r.URL.Path], data)
+
+		fmt.Fprintf(rw, `{"auth": {"client_token": "token-%d", "lease_duration": %d, "renewable": false}}`,
+			len(f.logins[r.URL.Path]), f.tokenTTL)
+	case "/v1/database/creds/agent":
+		fmt.Fprintf(rw, `{"lease_id": "database/creds/agent/%s", "lease_duration": 3600, "renewable": false, "data": {"password": "s3cr3t"}}`,
+			r.Header.Get("X-Vault-Token"))
+	default:
+		rw.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeVault) loginsTo(path string) []map[string]interface{} {
+	f.Lock()
+	defer f.Unlock()
+	return f.logins[path]
+}
+
+func newFakeVault(t *testing.T, tokenTTL int) (*fakeVault, *httptest.Server) {
+	f := &fakeVault{
+		t:        t,
+		logins:   make(map[string][]map[string]interface{}),
+		tokenTTL: tokenTTL,
+	}
+	return f, httptest.NewServer(f)
+}
+
+func newTestSource(t *testing.T, conf *Config) *vaultConfigSource {
+	assert.Nil(t, defaults.Set(conf))
+	assert.Nil(t, conf.Validate())
+
+	source, err := New(conf)
+	assert.Nil(t, err)
+	return source.(*vaultConfigSource)
+}
+
+func TestAppRoleAuth(t *testing.T) {
+	f, server := newFakeVault(t, 3600)
+	defer server.Close()
+
+	source := newTestSource(t, &Config{
+		VaultAddr:  server.URL,
+		AuthMethod: "approle",
+		AppRole: AppRoleConfig{
+			RoleID:   "agent",
+			SecretID: "abcd",
+		},
+	})
+	defer source.Stop()
+
+	assert.Equal(t, []map[string]interface{}{{"role_id": "agent", "secret_id": "abcd"}},
+		f.loginsTo("/v1/auth/approle/login"))
+	assert.Equal(t, "token-1", source.client.Token())
+}
+
+func TestKubernetesAuth(t *testing.T) {
+	f, server := newFakeVault(t, 3600)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "vault-k8s")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	tokenPath := filepath.Join(dir, "token")
+	assert.Nil(t, ioutil.WriteFile(tokenPath, []byte("jwt-content\n"), 0600))
+
+	source := newTestSource(t, &Config{
+		VaultAddr:  server.URL,
+		AuthMethod: "kubernetes",
+		Kubernetes: KubernetesAuthConfig{
+			Role:                    "signalfx-agent",
+			ServiceAccountTokenPath: tokenPath,
+			MountPath:               "/k8s/",
+		},
+	})
+	defer source.Stop()
+
+	assert.Equal(t, []map[string]interface{}{{"role": "signalfx-agent", "jwt": "jwt-content"}},
+		f.loginsTo("/v1/auth/k8s/login"))
+}
+
+func TestReauthRefetchesLeasedSecrets(t *testing.T) {
+	// Tokens expire after a second, so the source has to keep logging in
+	f, server := newFakeVault(t, 1)
+	defer server.Close()
+
+	source := newTestSource(t, &Config{
+		VaultAddr:  server.URL,
+		AuthMethod: "approle",
+		AppRole: AppRoleConfig{
+			RoleID: "agent",
+		},
+	})
+	defer source.Stop()
+
+	content, _, err := source.Get("database/creds/agent[password]")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]byte{"database/creds/agent[password]": []byte(`"s3cr3t"`)}, content)
+
+	changed := make(chan error)
+	go func() {
+		changed <- source.WaitForChange("database/creds/agent[password]", 0, make(chan struct{}))
+	}()
+
+	select {
+	case err := <-changed:
+		assert.Nil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Leased secret was not refetched after logging in again")
+	}
+
+	assert.True(t, len(f.loginsTo("/v1/auth/approle/login")) >= 2)
+
+	source.Lock()
+	_, cached := source.secretsByVaultPath["database/creds/agent"]
+	source.Unlock()
+	assert.False(t, cached, "the secret should be dropped from the cache")
+}
+
+func TestAuthMethodValidation(t *testing.T) {
+	for _, tc := range []struct {
+		conf  Config
+		valid bool
+	}{
+		{Config{AuthMethod: "token", VaultToken: "abcd"}, true},
+		{Config{AuthMethod: "approle", AppRole: AppRoleConfig{RoleID: "agent"}}, true},
+		{Config{AuthMethod: "approle"}, false},
+		{Config{AuthMethod: "kubernetes", Kubernetes: KubernetesAuthConfig{Role: "agent"}}, true},
+		{Config{AuthMethod: "kubernetes"}, false},
+		{Config{AuthMethod: "ldap"}, false},
+	} {
+		err := tc.conf.Validate()
+		assert.Equal(t, tc.valid, err == nil, "%s: %v", tc.conf.AuthMethod, err)
+	}
+}
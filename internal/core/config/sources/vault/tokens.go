@@ -2,12 +2,34 @@ package vault
 
 import (
 	"encoding/json"
+	"time"
 
 	"github.com/hashicorp/vault/api"
-	log "github.com/sirupsen/logrus"
 )
 
-func (v *vaultConfigSource) initTokenRenewalIfNeeded() error {
+// Gets the initial token, either by looking up the configured token or by
+// logging in with the auth method, and starts keeping it renewed.
+func (v *vaultConfigSource) initToken() error {
+	var authSec *api.Secret
+	var err error
+	if v.conf.AuthMethod == authMethodToken {
+		authSec, err = v.lookupToken()
+	} else {
+		authSec, err = v.login()
+	}
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for authSec != nil {
+			authSec = v.keepRenewed(authSec)
+		}
+	}()
+	return nil
+}
+
+func (v *vaultConfigSource) lookupToken() (*api.Secret, error) {
 	if v.client.Token() == "" {
 		// Blank tokens should never be allowed
 		panic("Vault token must be set")
@@ -16,7 +38,7 @@ func (v *vaultConfigSource) initTokenRenewalIfNeeded() error {
 	tokenAuth := v.client.Auth().Token()
 	authSec, err := tokenAuth.LookupSelf()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if authSec.Auth == nil {
@@ -29,37 +51,99 @@ func (v *vaultConfigSource) initTokenRenewalIfNeeded() error {
 	authSec.Auth.Renewable, _ = authSec.Data["renewable"].(bool)
 
 	if ttl, ok := authSec.Data["ttl"].(json.Number); ok {
-		if ttlInt, err := ttl.Int64(); err != nil {
+		if ttlInt, err := ttl.Int64(); err == nil {
 			authSec.Auth.LeaseDuration = int(ttlInt)
 		}
 	}
 
 	authSec.Auth.ClientToken = v.client.Token()
+	return authSec, nil
+}
+
+// Returns when the token expires, or the zero time if it doesn't
+func tokenExpiry(leaseDuration int) time.Time {
+	if leaseDuration <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(time.Duration(leaseDuration) * time.Second)
+}
+
+// Renews the token until it can't be renewed anymore.  Tokens from an auth
+// method are then replaced by logging in again before they expire, and the new
+// token is returned so that it can be renewed in turn.  Returns nil if there
+// is no new token.
+func (v *vaultConfigSource) keepRenewed(authSec *api.Secret) *api.Secret {
+	expiry := tokenExpiry(authSec.Auth.LeaseDuration)
 
 	renewer, err := v.client.NewRenewer(&api.RenewerInput{
 		Secret: authSec,
 	})
 	if err != nil {
-		return err
+		logger.WithError(err).Error("Could not set up renewal of Vault token")
+		return nil
 	}
 
+	v.Lock()
 	v.tokenRenewer = renewer
+	v.Unlock()
+
 	go renewer.Renew()
 
-	go func() {
-		for {
-			select {
-			case err := <-renewer.DoneCh():
+	for {
+		select {
+		case <-v.stop:
+			return nil
+		case out := <-renewer.RenewCh():
+			logger.Info("Vault token renewed")
+			if out.Secret != nil && out.Secret.Auth != nil {
+				expiry = tokenExpiry(out.Secret.Auth.LeaseDuration)
+			}
+		case err := <-renewer.DoneCh():
+			if v.conf.AuthMethod == authMethodToken {
 				if err == api.ErrRenewerNotRenewable {
-					log.Info("Vault token is not renewable, assuming valid indefinitely")
-				} else {
-					log.WithError(err).Error("Could not renew Vault token")
+					logger.Info("Vault token is not renewable, assuming valid indefinitely")
+				} else if err != nil {
+					logger.WithError(err).Error("Could not renew Vault token")
 				}
-				return
-			case <-renewer.RenewCh():
-				log.Info("Vault token renewed")
+				return nil
+			}
+
+			var wait time.Duration
+			switch {
+			case err != nil && err != api.ErrRenewerNotRenewable:
+				// The token might not be valid anymore so log in again right
+				// away
+				logger.WithError(err).Error("Could not renew Vault token, logging in again")
+			case expiry.IsZero():
+				logger.Info("Vault token is not renewable but does not expire")
+				return nil
+			default:
+				// Leave some time before the token expires to log in again
+				wait = time.Until(expiry) * 2 / 3
+				logger.Infof("Vault token can no longer be renewed, logging in again in %s", wait)
+			}
+
+			select {
+			case <-v.stop:
+				return nil
+			case <-time.After(wait):
 			}
+
+			newSec := v.loginWithRetry()
+			if newSec != nil {
+				v.notifyTokenChanged()
+			}
+			return newSec
 		}
-	}()
-	return nil
+	}
+}
+
+// Secret leases are revoked along with the token that they were read with, so
+// secrets with leases have to be refetched with the new token.
+func (v *vaultConfigSource) notifyTokenChanged() {
+	v.Lock()
+	defer v.Unlock()
+
+	close(v.tokenChanged)
+	v.tokenChanged = make(chan struct{})
 }
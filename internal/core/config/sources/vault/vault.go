@@ -28,10 +28,22 @@ type vaultConfigSource struct {
 	renewersByVaultPath               map[string]*api.Renewer
 	customWatchersByVaultPath         map[string]customWatcher
 	nonRenewableVaultPathRefetchTimes map[string]time.Time
+	leasesByVaultPath                 map[string]*lease
 	// Used for unit testing
 	nowProvider  func() time.Time
 	conf         *Config
 	tokenRenewer *api.Renewer
+	// Closed and replaced when the token is replaced by logging in again
+	tokenChanged chan struct{}
+	stop         chan struct{}
+}
+
+// lease is a secret lease, which is tied to the token that the secret was read
+// with and revoked along with it
+type lease struct {
+	id string
+	// The tokenChanged channel of the source when the secret was read
+	tokenChanged <-chan struct{}
 }
 
 var _ types.Stoppable = &vaultConfigSource{}
@@ -41,9 +53,18 @@ type Config struct {
 	// The Vault Address.  Can also be provided by the standard Vault envvar
 	// `VAULT_ADDR`.  This option takes priority over the envvar if provided.
 	VaultAddr string `yaml:"vaultAddr"`
+	// How to authenticate to Vault, one of `token`, `approle` or
+	// `kubernetes`.  With `approle` and `kubernetes`, the agent logs in when it
+	// starts and logs in again whenever its token can no longer be renewed.
+	AuthMethod string `yaml:"authMethod" default:"token"`
 	// The Vault token, can also be provided by it the standard Vault envvar
 	// `VAULT_TOKEN`.  This option takes priority over the envvar if provided.
+	// Only used with the `token` auth method.
 	VaultToken string `yaml:"vaultToken" neverLog:"true"`
+	// Config for the `approle` auth method
+	AppRole AppRoleConfig `yaml:"appRole" default:"{}"`
+	// Config for the `kubernetes` auth method
+	Kubernetes KubernetesAuthConfig `yaml:"kubernetes" default:"{}"`
 	// The polling interval for checking KV V2 secrets for a new version.  This
 	// can be any string value that can be parsed by
 	// https://golang.org/pkg/time/#ParseDuration.
@@ -56,12 +77,25 @@ func (c *Config) Validate() error {
 	if c.KVV2PollInterval == time.Duration(0) {
 		c.KVV2PollInterval = 60 * time.Second
 	}
-	if c.VaultToken == "" {
-		if os.Getenv("VAULT_TOKEN") == "" {
-			return errors.New("vault token is required, either in the agent config or the envvar VAULT_TOKEN")
-		}
+	switch c.AuthMethod {
+	case authMethodToken:
+		if c.VaultToken == "" {
+			if os.Getenv("VAULT_TOKEN") == "" {
+				return errors.New("vault token is required, either in the agent config or the envvar VAULT_TOKEN")
+			}
 
-		c.VaultToken = os.Getenv("VAULT_TOKEN")
+			c.VaultToken = os.Getenv("VAULT_TOKEN")
+		}
+	case authMethodAppRole:
+		if c.AppRole.RoleID == "" {
+			return errors.New("appRole.roleID is required for the approle auth method")
+		}
+	case authMethodKubernetes:
+		if c.Kubernetes.Role == "" {
+			return errors.New("kubernetes.role is required for the kubernetes auth method")
+		}
+	default:
+		return fmt.Errorf("unknown Vault authMethod %q, must be one of token, approle or kubernetes", c.AuthMethod)
 	}
 	return nil
 }
@@ -84,20 +118,29 @@ func New(conf *Config) (types.ConfigSource, error) {
 		return nil, err
 	}
 
-	c.SetToken(conf.VaultToken)
-
 	vcs := &vaultConfigSource{
 		client:                            c,
 		secretsByVaultPath:                make(map[string]*api.Secret),
 		renewersByVaultPath:               make(map[string]*api.Renewer),
 		customWatchersByVaultPath:         make(map[string]customWatcher),
 		nonRenewableVaultPathRefetchTimes: make(map[string]time.Time),
+		leasesByVaultPath:                 make(map[string]*lease),
 		nowProvider:                       time.Now,
 		conf:                              conf,
+		tokenChanged:                      make(chan struct{}),
+		stop:                              make(chan struct{}),
 	}
 
-	// This will change if we ever support auth methods for getting the token
-	vcs.initTokenRenewalIfNeeded()
+	if conf.AuthMethod == authMethodToken {
+		c.SetToken(conf.VaultToken)
+		if err := vcs.initToken(); err != nil {
+			// The token might still work for reading secrets even if it
+			// can't be looked up
+			logger.WithError(err).Warn("Could not look up Vault token, it will not be renewed")
+		}
+	} else if err := vcs.initToken(); err != nil {
+		return nil, err
+	}
 
 	return vcs, nil
 }
@@ -128,6 +171,13 @@ func (v *vaultConfigSource) Get(path string) (map[string][]byte, uint64, error)
 			return nil, 0, fmt.Errorf("no secret found at path %s", vaultPath)
 		}
 
+		if secret.LeaseID != "" {
+			v.leasesByVaultPath[vaultPath] = &lease{
+				id:           secret.LeaseID,
+				tokenChanged: v.tokenChanged,
+			}
+		}
+
 		if secret.Renewable {
 			renewer, err := v.client.NewRenewer(&api.RenewerInput{
 				Secret: secret,
@@ -188,14 +238,21 @@ func (v *vaultConfigSource) WaitForChange(path string, version uint64, stop <-ch
 	if err != nil {
 		return err
 	}
+	v.Lock()
 	renewer := v.renewersByVaultPath[vaultPath]
-
-	var watchErr error
+	refetchTime := v.nonRenewableVaultPathRefetchTimes[vaultPath]
+	customWatcher := v.customWatchersByVaultPath[vaultPath]
+	// This stays nil, and so never receives, if the secret has no lease
+	var tokenChanged <-chan struct{}
+	var leaseID string
+	if l := v.leasesByVaultPath[vaultPath]; l != nil {
+		tokenChanged = l.tokenChanged
+		leaseID = l.id
+	}
+	v.Unlock()
 
 	if renewer == nil {
-		refetchTime := v.nonRenewableVaultPathRefetchTimes[vaultPath]
 		if refetchTime.IsZero() {
-			customWatcher := v.customWatchersByVaultPath[vaultPath]
 			if customWatcher == nil {
 				// There is nothing to do except wait for the whole thing to
 				// stop
@@ -231,13 +288,23 @@ func (v *vaultConfigSource) WaitForChange(path string, version uint64, stop <-ch
 				break
 			case <-timer.C:
 				break
+			case <-tokenChanged:
+				logger.Infof("Refetching Vault secret at path %s since its lease %s belonged to a replaced token", vaultPath, leaseID)
 			}
 		}
 	} else {
 		select {
-		// This will receive if there are an errors renewing a secret lease
-		case watchErr = <-renewer.DoneCh():
-			break
+		// This will receive when the lease can't be renewed any further or
+		// there is an error renewing it.  Either way, the secret needs to be
+		// refetched to get a new lease, since returning the error would just
+		// make this get called again without the secret ever being refetched.
+		case err := <-renewer.DoneCh():
+			if err != nil {
+				logger.WithError(err).Errorf("Could not renew lease of Vault secret at path %s", vaultPath)
+			}
+		case <-tokenChanged:
+			logger.Infof("Refetching Vault secret at path %s since its lease %s belonged to a replaced token", vaultPath, leaseID)
+			renewer.Stop()
 		case <-stop:
 			renewer.Stop()
 		}
@@ -249,17 +316,19 @@ func (v *vaultConfigSource) WaitForChange(path string, version uint64, stop <-ch
 	delete(v.secretsByVaultPath, vaultPath)
 	delete(v.nonRenewableVaultPathRefetchTimes, vaultPath)
 	delete(v.customWatchersByVaultPath, vaultPath)
+	delete(v.leasesByVaultPath, vaultPath)
 	v.Unlock()
 
-	logger.Debugf("Path changed or failed to renew: %s", vaultPath)
+	logger.Debugf("Path changed, lease expired or token replaced: %s", vaultPath)
 
-	return watchErr
+	return nil
 }
 
 func (v *vaultConfigSource) Stop() error {
 	v.Lock()
 	defer v.Unlock()
 
+	close(v.stop)
 	if v.tokenRenewer != nil {
 		v.tokenRenewer.Stop()
 	}
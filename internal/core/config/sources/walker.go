@@ -2,6 +2,7 @@ package sources
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/davecgh/go-spew/spew"
@@ -20,23 +21,57 @@ func isDynamicValue(v interface{}) bool {
 	return m["#from"] != nil
 }
 
-func renderDynamicValues(config []byte, resolve resolveFunc) ([]byte, error) {
+// ValueSources maps the paths of values in the rendered config to the
+// dynamic value that they came from (e.g. `vault:secret/db[password]`).  A
+// path is made up of the map keys and slice indexes leading to the value,
+// each prefixed with `/`, e.g. `/monitors/2/password`.
+type ValueSources map[string]string
+
+// ValuePath returns the path of the value at the given map key or slice index
+// of the value at the parent path.
+func ValuePath(parent string, elem interface{}) string {
+	return fmt.Sprintf("%s/%v", parent, elem)
+}
+
+// Adds the sources of a child value, whose paths are relative to it, at the
+// given path
+func (vs ValueSources) addChild(path string, child ValueSources) {
+	for p, s := range child {
+		vs[path+p] = s
+	}
+}
+
+// Returns the sources of the value at the given map key or slice index,
+// relative to that value
+func (vs ValueSources) under(elem interface{}) ValueSources {
+	prefix := ValuePath("", elem)
+	out := ValueSources{}
+	for p, s := range vs {
+		if p == prefix || strings.HasPrefix(p, prefix+"/") {
+			out[strings.TrimPrefix(p, prefix)] = s
+		}
+	}
+	return out
+}
+
+func renderDynamicValues(config []byte, resolve resolveFunc) ([]byte, ValueSources, error) {
 	var content map[interface{}]interface{}
 	err := yaml.Unmarshal(config, &content)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	w := walker{
 		resolve:   resolve,
 		pathsSeen: make(map[string]bool),
 	}
-	resolvedContent, err := w.injectDynamicValues(content)
+	resolvedContent, sources, err := w.injectDynamicValues(content)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return yaml.Marshal(resolvedContent)
+	out, err := yaml.Marshal(resolvedContent)
+	return out, sources, err
 }
 
 // Use a struct to maintain context about the tree walking so that we don't
@@ -46,62 +81,75 @@ type walker struct {
 	resolve   resolveFunc
 }
 
-func (w *walker) doResolution(rawSpec RawDynamicValueSpec) ([]interface{}, *dynamicValueSpec, error) {
+// Returns the resolved values along with the sources of each of them, which
+// are relative to the value.
+func (w *walker) doResolution(rawSpec RawDynamicValueSpec) ([]interface{}, []ValueSources, *dynamicValueSpec, error) {
 	log.Debugf("Resolving %s", rawSpec)
-	values, path, spec, err := w.resolve(rawSpec)
+	values, paths, spec, err := w.resolve(rawSpec)
 	if err != nil {
-		return nil, spec, err
+		return nil, nil, spec, err
 	}
 
-	if w.pathsSeen[path] {
-		var paths []string
-		for k := range w.pathsSeen {
-			paths = append(paths, k)
+	for _, path := range paths {
+		if w.pathsSeen[path] {
+			var seen []string
+			for k := range w.pathsSeen {
+				seen = append(seen, k)
+			}
+			return nil, nil, spec, fmt.Errorf("Dynamic value paths %s have a circular dependency", strings.Join(seen, "; "))
 		}
-		return nil, spec, fmt.Errorf("Dynamic value paths %s have a circular dependency", strings.Join(paths, "; "))
 	}
 
-	// Set the current path in our path set before we go recursing into the
-	// resolved value and pop it out once we're done resolving this line of
+	// Set the current paths in our path set before we go recursing into the
+	// resolved value and pop them out once we're done resolving this line of
 	// dynamic values.
-	w.pathsSeen[path] = true
+	for _, path := range paths {
+		w.pathsSeen[path] = true
+	}
 
 	log.Debugf("Resolved %s to %s", rawSpec, spew.Sdump(values))
 	var out []interface{}
+	var outSources []ValueSources
 	for i := range values {
-		val, err := w.injectDynamicValues(values[i])
+		val, sources, err := w.injectDynamicValues(values[i])
 		if err != nil {
-			return nil, spec, err
+			return nil, nil, spec, err
 		}
+		sources[""] = spec.From.String()
+
 		out = append(out, val)
+		outSources = append(outSources, sources)
+	}
+	for _, path := range paths {
+		delete(w.pathsSeen, path)
 	}
-	delete(w.pathsSeen, path)
 
 	log.Debugf("Final resolution of %s is %s", rawSpec, spew.Sdump(out))
-	return out, spec, nil
+	return out, outSources, spec, nil
 }
 
-func (w *walker) injectDynamicValues(v interface{}) (interface{}, error) {
+func (w *walker) injectDynamicValues(v interface{}) (interface{}, ValueSources, error) {
 	if s, ok := v.([]interface{}); ok {
 		return w.injectDynamicValuesInSlice(s)
 	}
 	if m, ok := v.(map[interface{}]interface{}); ok {
 		return w.injectDynamicValuesInMap(m)
 	}
-	return v, nil
+	return v, ValueSources{}, nil
 }
 
-func (w *walker) injectDynamicValuesInMap(m map[interface{}]interface{}) (map[interface{}]interface{}, error) {
+func (w *walker) injectDynamicValuesInMap(m map[interface{}]interface{}) (map[interface{}]interface{}, ValueSources, error) {
 	out := make(map[interface{}]interface{})
+	sources := ValueSources{}
 	for k, v := range m {
 		if isDynamicValue(v) {
-			values, spec, err := w.doResolution(RawDynamicValueSpec(v))
+			values, valueSources, spec, err := w.doResolution(RawDynamicValueSpec(v))
 			if err != nil {
-				return nil, errors.WithMessage(err, fmt.Sprintf("could not process key '%s'", k))
+				return nil, nil, errors.WithMessage(err, fmt.Sprintf("could not process key '%s'", k))
 			}
 			if spec.Flatten {
 				if !strings.HasPrefix(k.(string), "_") {
-					return nil, fmt.Errorf(
+					return nil, nil, fmt.Errorf(
 						"When flattening a map into another map, the key should "+
 							"start with '_' to make the intention clear, you used '%s'", k)
 				}
@@ -109,69 +157,134 @@ func (w *walker) injectDynamicValuesInMap(m map[interface{}]interface{}) (map[in
 					if m, ok := values[i].(map[interface{}]interface{}); ok {
 						for k2, v2 := range m {
 							out[k2] = v2
+
+							child := valueSources[i].under(k2)
+							if _, ok := child[""]; !ok {
+								child[""] = spec.From.String()
+							}
+							sources.addChild(ValuePath("", k2), child)
 						}
 					} else {
-						return nil, fmt.Errorf("Cannot flatten non-map at key '%s' in map context", k)
+						return nil, nil, fmt.Errorf("Cannot flatten non-map at key '%s' in map context", k)
 					}
 				}
 			} else {
 				merged, err := mergeValues(values)
 				if err != nil {
-					return nil, err
+					return nil, nil, err
 				}
 
-				val, err := w.injectDynamicValues(merged)
+				val, child, err := w.injectDynamicValues(merged)
 				if err != nil {
-					return nil, err
+					return nil, nil, err
 				}
 				out[k] = val
+
+				child.addChild("", mergeValueSources(values, valueSources))
+				child[""] = spec.From.String()
+				sources.addChild(ValuePath("", k), child)
 			}
 		} else {
-			val, err := w.injectDynamicValues(v)
+			val, child, err := w.injectDynamicValues(v)
 			if err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 
 			out[k] = val
+			sources.addChild(ValuePath("", k), child)
 		}
 	}
 
-	return out, nil
+	return out, sources, nil
 }
 
-func (w *walker) injectDynamicValuesInSlice(v []interface{}) ([]interface{}, error) {
+func (w *walker) injectDynamicValuesInSlice(v []interface{}) ([]interface{}, ValueSources, error) {
 	out := make([]interface{}, 0, len(v))
+	// The sources of each element of out, relative to the element
+	var outSources []ValueSources
 
 	for i := range v {
 		if isDynamicValue(v[i]) {
-			values, spec, err := w.doResolution(RawDynamicValueSpec(v[i]))
+			values, valueSources, spec, err := w.doResolution(RawDynamicValueSpec(v[i]))
 			if err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 			if spec.Flatten {
 				for j := range values {
 					slice, ok := values[j].([]interface{})
-					if !ok {
+					var elemSources []ValueSources
+					if ok {
+						for k := range slice {
+							child := valueSources[j].under(k)
+							if _, ok := child[""]; !ok {
+								child[""] = spec.From.String()
+							}
+							elemSources = append(elemSources, child)
+						}
+					} else {
 						slice = []interface{}{values[j]}
+						elemSources = []ValueSources{valueSources[j]}
 					}
 					remainder := slice
+					remainderSources := elemSources
 					if i < len(out) {
 						remainder = append(slice, out[i:]...)
+						remainderSources = append(elemSources, outSources[i:]...)
 					}
 					out = append(out[:i], remainder...)
+					outSources = append(outSources[:i], remainderSources...)
 				}
 			} else {
 				out = append(out, values...)
+				outSources = append(outSources, valueSources...)
 			}
 		} else {
-			val, err := w.injectDynamicValues(v[i])
+			val, child, err := w.injectDynamicValues(v[i])
 			if err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 			out = append(out, val)
+			outSources = append(outSources, child)
 		}
 	}
-	return out, nil
+
+	sources := ValueSources{}
+	for i := range outSources {
+		sources.addChild(ValuePath("", i), outSources[i])
+	}
+	return out, sources, nil
+}
+
+// Combines the sources of values in the same way that mergeValues combines
+// the values, leaving off the sources of the values themselves.
+func mergeValueSources(values []interface{}, valueSources []ValueSources) ValueSources {
+	out := ValueSources{}
+	offset := 0
+	for i := range values {
+		s, isSlice := values[i].([]interface{})
+		for p, src := range valueSources[i] {
+			if p == "" {
+				continue
+			}
+			if isSlice {
+				p = shiftIndex(p, offset)
+			}
+			out[p] = src
+		}
+		offset += len(s)
+	}
+	return out
+}
+
+// Adds offset to the slice index at the start of the path
+func shiftIndex(path string, offset int) string {
+	parts := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)
+	idx, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return path
+	}
+	parts[0] = strconv.Itoa(idx + offset)
+	return "/" + strings.Join(parts, "/")
 }
 
 func mergeValues(v []interface{}) (interface{}, error) {
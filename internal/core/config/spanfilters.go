@@ -0,0 +1,49 @@
+package config
+
+import "github.com/signalfx/signalfx-agent/internal/core/spanfilters"
+
+// SpanFilter describes a set of subtractive filters applied to trace spans
+type SpanFilter struct {
+	// A list of service names to match against the span's local endpoint,
+	// OR'd together
+	ServiceNames []string `yaml:"serviceNames"`
+	// A single service name to match against
+	ServiceName string `yaml:"serviceName"`
+	// A list of operation (span) names to match against, OR'd together
+	OperationNames []string `yaml:"operationNames"`
+	// A single operation name to match against
+	OperationName string `yaml:"operationName"`
+	// A map of span tag key/values to match against.  All key/values must
+	// match a span for it to be matched.
+	Tags map[string]string `yaml:"tags" default:"{}"`
+	// Negates the result of the match so that it matches all spans that do
+	// NOT match the service name, operation name and tags given.
+	Negated bool `yaml:"negated"`
+}
+
+// MakeFilter returns an actual filter instance from the config
+func (sf *SpanFilter) MakeFilter() (spanfilters.SpanFilter, error) {
+	serviceNames := sf.ServiceNames
+	if sf.ServiceName != "" {
+		serviceNames = append(append([]string(nil), serviceNames...), sf.ServiceName)
+	}
+	operationNames := sf.OperationNames
+	if sf.OperationName != "" {
+		operationNames = append(append([]string(nil), operationNames...), sf.OperationName)
+	}
+	return spanfilters.New(serviceNames, operationNames, sf.Tags, sf.Negated)
+}
+
+func makeSpanFilterSet(excludes []SpanFilter) (*spanfilters.FilterSet, error) {
+	fs := &spanfilters.FilterSet{}
+
+	for i := range excludes {
+		f, err := excludes[i].MakeFilter()
+		if err != nil {
+			return nil, err
+		}
+		fs.ExcludeFilters = append(fs.ExcludeFilters, f)
+	}
+
+	return fs, nil
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SpanMetricsConfig describes the request rate, error and latency metrics
+// that the writer derives from the trace spans that pass through it.  They
+// include the spans that are dropped by `traceSampling`, but not those that
+// are excluded by `spansToExclude`.  The `operation` dimension is the span
+// name exactly as it was received.  `spanRedaction` only applies to span
+// tags, so operation names are not redacted and should not contain anything
+// sensitive or unbounded like IDs in URL paths.
+type SpanMetricsConfig struct {
+	// If true, the writer will send the metrics `spans.count`,
+	// `spans.errors` and `spans.duration.sum` (in microseconds) as
+	// cumulative counters for each combination of service, operation and
+	// the span tags in `dimensions`.  A span is considered an error if it has
+	// an `error` tag that isn't `false`.
+	Enabled bool `yaml:"enabled"`
+	// Span tag keys whose values should be added as dimensions on the
+	// metrics.  Spans without a tag are grouped together with the dimension
+	// left off.
+	Dimensions []string `yaml:"dimensions" default:"[]"`
+	// Percentiles (0-100) of span duration to send as gauges named
+	// `spans.duration.p<percentile>` (in microseconds), e.g. `[50, 90, 99]`.
+	// They are calculated from the spans seen in each `interval`.
+	Percentiles []float64 `yaml:"percentiles" default:"[]"`
+	// Upper bounds, in milliseconds, of span duration histogram buckets.  If
+	// set, a cumulative counter named `spans.duration.bucket` is sent for
+	// each bucket with an `upper_bound` dimension, along with one whose
+	// `upper_bound` is `+Inf`.
+	LatencyBucketsMs []float64 `yaml:"latencyBucketsMs" default:"[]"`
+	// How often to send the metrics.  This should be a duration string that
+	// is accepted by https://golang.org/pkg/time/#ParseDuration.
+	Interval time.Duration `yaml:"interval" default:"10s"`
+	// How long to keep sending metrics for a combination of dimensions after
+	// a span is last seen for it.  This should be a duration string that is
+	// accepted by https://golang.org/pkg/time/#ParseDuration.
+	StaleTimeout time.Duration `yaml:"staleTimeout" default:"5m"`
+	// The most combinations of dimensions to send metrics for.  Once this
+	// many are being tracked, spans for new combinations are counted in a
+	// single series whose `service` and `operation` dimensions are both
+	// `other`, and the `sfxagent.span_metrics_overflow_spans` internal metric
+	// counts how many spans that happened to.  Set to 0 to not limit the
+	// number of series.
+	MaxSeries int `yaml:"maxSeries" default:"1000"`
+}
+
+func (smc *SpanMetricsConfig) validate() error {
+	if !smc.Enabled {
+		return nil
+	}
+	if smc.Interval <= 0 {
+		return errors.New("span metrics interval must be greater than 0")
+	}
+	if smc.MaxSeries < 0 {
+		return errors.New("span metrics maxSeries cannot be negative")
+	}
+	for _, p := range smc.Percentiles {
+		if p <= 0 || p > 100 {
+			return fmt.Errorf("span metrics percentile %v must be greater than 0 and at most 100", p)
+		}
+	}
+	for i, b := range smc.LatencyBucketsMs {
+		if b <= 0 {
+			return fmt.Errorf("span metrics latency bucket %v must be greater than 0", b)
+		}
+		if i > 0 && b <= smc.LatencyBucketsMs[i-1] {
+			return errors.New("span metrics latencyBucketsMs must be in increasing order")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/creasty/defaults"
+	"github.com/pkg/errors"
+	"github.com/signalfx/golib/pointer"
+	"github.com/signalfx/signalfx-agent/internal/utils/filter"
+)
+
+// SpanRedactionConfig describes how to scrub sensitive information out of
+// trace span tags before the spans leave the host.  The changes are made in
+// the following order: deleting tags, masking tag values, obfuscating SQL,
+// and stripping URL query strings.
+type SpanRedactionConfig struct {
+	// A list of tag keys to remove from spans.  The keys can be globbed or
+	// regexes (e.g. `/^user\./`).
+	TagsToDelete []string `yaml:"tagsToDelete" default:"[]"`
+	// Regex replacements to make on tag values, e.g. to mask email addresses
+	MaskValues []SpanTagMask `yaml:"maskValues" default:"[]"`
+	// A list of tag keys whose values are SQL statements that should have
+	// their string and numeric literals replaced with `?`, e.g.
+	// `db.statement`.  The keys can be globbed or regexes.  Double-quoted
+	// values are treated as string literals too, as MySQL does, unless
+	// `sqlDoubleQuotesAreIdentifiers` is set.
+	ObfuscateSQLTags []string `yaml:"obfuscateSqlTags" default:"[]"`
+	// Set to true to leave double-quoted values in `obfuscateSqlTags` alone
+	// because they are identifiers, as they are in standard SQL and
+	// PostgreSQL.  Only set this if none of the statements are from a
+	// database that treats double quotes as strings, such as MySQL, or their
+	// values will be sent as they are.
+	SQLDoubleQuotesAreIdentifiers bool `yaml:"sqlDoubleQuotesAreIdentifiers"`
+	// A list of tag keys whose values are URLs that should have their query
+	// string and fragment removed, e.g. `http.url`.  The keys can be globbed
+	// or regexes.
+	StripURLQueryTags []string `yaml:"stripUrlQueryTags" default:"[]"`
+}
+
+// SpanTagMask describes a regex replacement on span tag values
+type SpanTagMask struct {
+	// The tag keys whose values should be masked.  The keys can be globbed or
+	// regexes.  If empty, the values of all tags are masked.
+	Tags []string `yaml:"tags"`
+	// A regex (without the surrounding `/`) that is matched against the tag
+	// value
+	Pattern string `yaml:"pattern"`
+	// What to replace each match of `pattern` with.  This can refer to
+	// capture groups in `pattern` with `$1`, `${1}` or `${name}`.  Set to
+	// an empty string to remove the matches.  Defaults to `****` if not set.
+	Replacement *string `yaml:"replacement"`
+}
+
+// Enabled returns whether any span tags could be changed
+func (src *SpanRedactionConfig) Enabled() bool {
+	return len(src.TagsToDelete) > 0 || len(src.MaskValues) > 0 ||
+		len(src.ObfuscateSQLTags) > 0 || len(src.StripURLQueryTags) > 0
+}
+
+func (src *SpanRedactionConfig) initialize() {
+	for i := range src.MaskValues {
+		// Defaults aren't set on structs within slices
+		if err := defaults.Set(&src.MaskValues[i]); err != nil {
+			panic(fmt.Sprintf("Span tag mask defaults are wrong types: %s", err))
+		}
+		// This is a pointer without a default tag since the default would
+		// otherwise replace an explicit empty string
+		if src.MaskValues[i].Replacement == nil {
+			src.MaskValues[i].Replacement = pointer.String("****")
+		}
+	}
+}
+
+func (src *SpanRedactionConfig) validate() error {
+	for _, keys := range [][]string{src.TagsToDelete, src.ObfuscateSQLTags, src.StripURLQueryTags} {
+		if _, err := filter.NewBasicStringFilter(keys); err != nil {
+			return errors.WithMessage(err, "span redaction tag key is invalid")
+		}
+	}
+	for _, m := range src.MaskValues {
+		if _, err := filter.NewBasicStringFilter(m.Tags); err != nil {
+			return errors.WithMessage(err, "span tag mask tag key is invalid")
+		}
+		if m.Pattern == "" {
+			return errors.New("span tag masks must have a pattern")
+		}
+		if _, err := regexp.Compile(m.Pattern); err != nil {
+			return errors.Wrapf(err, "span tag mask pattern %s is invalid", m.Pattern)
+		}
+	}
+	return nil
+}
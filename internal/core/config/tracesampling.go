@@ -0,0 +1,74 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/creasty/defaults"
+	"github.com/signalfx/golib/pointer"
+)
+
+// TraceSamplingConfig describes which trace spans the writer should send.
+// Sampling decisions are made per trace ID, so either all or none of the
+// spans of a trace that go through the agent are kept, except where a rule
+// matches on span tags.
+type TraceSamplingConfig struct {
+	// The percentage (0-100) of traces to keep if no rule matches.  Which
+	// traces are kept is derived from the trace ID, so agents on different
+	// hosts make the same decision for the same trace.  Defaults to 100 if not
+	// set.
+	SamplingPercentage *float64 `yaml:"samplingPercentage"`
+	// Rules that override `samplingPercentage` for certain spans.  The first
+	// rule that matches a span decides whether it is kept.
+	Rules []TraceSamplingRule `yaml:"rules" default:"[]"`
+}
+
+// TraceSamplingRule selects spans by service name and tags and sets how many
+// of them to keep
+type TraceSamplingRule struct {
+	// The service name of the span's local endpoint to match.  Can be
+	// globbed or a regex.  If blank, all services match.
+	ServiceName string `yaml:"serviceName"`
+	// A map of span tag keys to values that must all match, e.g.
+	// `error: "true"`.  The values can be globbed or regexes.
+	Tags map[string]string `yaml:"tags" default:"{}"`
+	// The percentage (0-100) of traces to keep that match this rule.  Defaults
+	// to 100 if not set.
+	SamplingPercentage *float64 `yaml:"samplingPercentage"`
+	// If greater than 0, at most this many traces per second will be kept for
+	// each service that matches this rule.
+	MaxTracesPerSecond float64 `yaml:"maxTracesPerSecond"`
+}
+
+// Enabled returns whether any spans could be sampled out
+func (tsc *TraceSamplingConfig) Enabled() bool {
+	return *tsc.SamplingPercentage < 100 || len(tsc.Rules) > 0
+}
+
+// The percentages are pointers without default tags since the defaults would
+// otherwise replace an explicit 0
+func (tsc *TraceSamplingConfig) initialize() {
+	if tsc.SamplingPercentage == nil {
+		tsc.SamplingPercentage = pointer.Float64(100)
+	}
+	for i := range tsc.Rules {
+		// Defaults aren't set on structs within slices
+		if err := defaults.Set(&tsc.Rules[i]); err != nil {
+			panic(fmt.Sprintf("Trace sampling rule defaults are wrong types: %s", err))
+		}
+		if tsc.Rules[i].SamplingPercentage == nil {
+			tsc.Rules[i].SamplingPercentage = pointer.Float64(100)
+		}
+	}
+}
+
+func (tsc *TraceSamplingConfig) validate() error {
+	if *tsc.SamplingPercentage < 0 || *tsc.SamplingPercentage > 100 {
+		return fmt.Errorf("trace samplingPercentage must be between 0 and 100")
+	}
+	for _, r := range tsc.Rules {
+		if *r.SamplingPercentage < 0 || *r.SamplingPercentage > 100 {
+			return fmt.Errorf("trace sampling rule samplingPercentage must be between 0 and 100")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package config
+
+import (
+	"regexp"
+
+	"github.com/pkg/errors"
+	"github.com/signalfx/signalfx-agent/internal/core/dptransforms"
+)
+
+// MetricTransform describes changes to make to the metric name and dimensions
+// of datapoints right before they are sent.  The datapoints that a transform
+// applies to are selected in the same way as with metric filters.  All of the
+// changes that are specified are made, in the following order: copying
+// dimensions, renaming dimension keys, replacing dimension values, dropping
+// dimensions, and renaming the metric.
+type MetricTransform struct {
+	// Limits the transform to datapoints from a specific monitor type
+	MonitorType string `yaml:"monitorType"`
+	// A list of metric names to match against, OR'd together
+	MetricNames []string `yaml:"metricNames"`
+	// A single metric name to match against
+	MetricName string `yaml:"metricName"`
+	// A map of dimension key/values to match against.  All key/values must
+	// match a datapoint for it to be transformed.
+	Dimensions map[string]string `yaml:"dimensions" default:"{}"`
+	// The new name of the metric.  If any of the matched metric names are
+	// regexes (e.g. `/^jvm\.(.*)$/`), this can refer to their capture groups
+	// with `$1`, `${1}` or `${name}` for named groups.
+	RenameMetric string `yaml:"renameMetric"`
+	// A map of existing dimension keys to the keys they should be renamed to.
+	// All of the renames are based on the dimensions from before any of them
+	// are done, so `{a: b, b: c}` swaps in `a`'s value for `b`'s.  Two keys
+	// cannot be renamed to the same key.
+	RenameDimensionKeys map[string]string `yaml:"renameDimensionKeys"`
+	// Regex replacements to make on dimension values
+	ReplaceDimensionValues []DimensionValueReplacement `yaml:"replaceDimensionValues"`
+	// A list of dimension keys to remove
+	DropDimensions []string `yaml:"dropDimensions"`
+	// A map of new dimension keys to the existing dimension keys that they
+	// should get their value from.  The existing dimension is left in place.
+	// Values are always copied from the original dimensions, never from
+	// another copy.
+	CopyDimensions map[string]string `yaml:"copyDimensions"`
+}
+
+// DimensionValueReplacement describes a regex replacement on a dimension value
+type DimensionValueReplacement struct {
+	// The key of the dimension whose value should be changed
+	Dimension string `yaml:"dimension"`
+	// A regex (without the surrounding `/`) that is matched against the
+	// dimension value
+	Pattern string `yaml:"pattern"`
+	// What to replace each match of `pattern` with.  This can refer to
+	// capture groups in `pattern` with `$1`, `${1}` or `${name}`.
+	Replacement string `yaml:"replacement"`
+}
+
+// MakeTransform returns an actual transform instance from the config
+func (mt *MetricTransform) MakeTransform() (*dptransforms.Transform, error) {
+	metricNames := mt.MetricNames
+	if mt.MetricName != "" {
+		metricNames = append(append([]string(nil), metricNames...), mt.MetricName)
+	}
+
+	var replacements []dptransforms.DimensionValueReplacement
+	for _, r := range mt.ReplaceDimensionValues {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "dimension value pattern for %s is invalid", r.Dimension)
+		}
+		replacements = append(replacements, dptransforms.DimensionValueReplacement{
+			Dimension:   r.Dimension,
+			Pattern:     re,
+			Replacement: r.Replacement,
+		})
+	}
+
+	return dptransforms.New(mt.MonitorType, metricNames, mt.Dimensions, mt.RenameMetric,
+		mt.RenameDimensionKeys, replacements, mt.DropDimensions, mt.CopyDimensions)
+}
+
+func makeTransforms(confs []MetricTransform) ([]*dptransforms.Transform, error) {
+	var out []*dptransforms.Transform
+	for i := range confs {
+		t, err := confs[i].MakeTransform()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, nil
+}
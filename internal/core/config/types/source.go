@@ -9,6 +9,14 @@ type ConfigSourceConfig interface {
 	New() (ConfigSource, error)
 }
 
+// MultiConfigSourceConfig is a config type for a backend that provides more
+// than one ConfigSource, each with its own name, e.g. for different kinds of
+// objects in the same store.
+type MultiConfigSourceConfig interface {
+	validation.Validatable
+	NewSources() ([]ConfigSource, error)
+}
+
 // ConfigSource represents a data store for which we can get and watch paths.
 type ConfigSource interface {
 	// Name should return the name used as the scheme of the URL that is
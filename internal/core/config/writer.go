@@ -1,12 +1,20 @@
 package config
 
 import (
+	"fmt"
 	"net/url"
+	"regexp"
 	"time"
 
+	"github.com/creasty/defaults"
 	"github.com/mitchellh/hashstructure"
+	"github.com/pkg/errors"
+	"github.com/signalfx/golib/pointer"
 	"github.com/signalfx/signalfx-agent/internal/core/dpfilters"
+	"github.com/signalfx/signalfx-agent/internal/core/dptransforms"
+	"github.com/signalfx/signalfx-agent/internal/core/eventfilters"
 	"github.com/signalfx/signalfx-agent/internal/core/propfilters"
+	"github.com/signalfx/signalfx-agent/internal/core/spanfilters"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -32,8 +40,22 @@ type WriterConfig struct {
 	EventSendIntervalSeconds int `yaml:"eventSendIntervalSeconds" default:"1"`
 	// The analogue of `maxRequests` for dimension property requests.
 	PropertiesMaxRequests uint `yaml:"propertiesMaxRequests" default:"10"`
+	// The maximum number of dimension property requests to make per second.
+	// Updates beyond this are queued, and multiple queued updates to the same
+	// dimension are combined into a single request.  If 0, the rate is not
+	// limited.  Defaults to 20 if not set.
+	PropertiesMaxRequestsPerSecond *float64 `yaml:"propertiesMaxRequestsPerSecond"`
+	// The maximum number of times to attempt syncing properties to a
+	// dimension before giving up.  Failed attempts are retried with the same
+	// backoff as datapoints (see `retryInitialBackoff`, `retryMaxBackoff` and
+	// `retryStatusCodes`).
+	PropertiesMaxAttempts int `yaml:"propertiesMaxAttempts" default:"5"`
 	// Properties that are synced to SignalFx are cached to prevent duplicate
-	// requests from being sent, causing unnecessary load on our backend.
+	// requests from being sent, causing unnecessary load on our backend.  The
+	// cache is also used to find the properties and tags that a monitor has
+	// stopped sending for a dimension so that they can be removed from it.
+	// Those that were synced before the dimension fell out of the cache are
+	// not removed.
 	PropertiesHistorySize uint `yaml:"propertiesHistorySize" default:"1000"`
 	// If the log level is set to `debug` and this is true, all datapoints
 	// generated by the agent will be logged.
@@ -67,16 +89,87 @@ type WriterConfig struct {
 	// requests..." or "Dropping new trace spans..." it means that the
 	// downstream target for traces is not able to accept them fast enough.
 	// Usually if the downstream is offline you will get connection refused
-	// errors and most likely spans will not build up in the agent (there is no
-	// retry mechanism). In the case of slow downstreams, you might be able to
-	// increase `maxRequests` to increase the concurrent stream of spans
-	// downstream (if the target can make efficient use of additional
-	// connections) or, less likely, increase `traceSpanMaxBatchSize` if your
-	// batches are maxing out (turn on debug logging to see the batch sizes
-	// being sent) and being split up too much. If neither of those options
-	// helps, your downstream is likely too slow to handle the volume of trace
-	// spans and should be upgraded to more powerful hardware/networking.
+	// errors and most likely spans will not build up in the agent (unless
+	// retries are enabled with `retryMaxAttempts`). In the case of slow
+	// downstreams, you might be able to increase `maxRequests` to increase
+	// the concurrent stream of spans downstream (if the target can make
+	// efficient use of additional connections) or, less likely, increase
+	// `traceSpanMaxBatchSize` if your batches are maxing out (turn on debug
+	// logging to see the batch sizes being sent) and being split up too
+	// much. If neither of those options helps, your downstream is likely
+	// too slow to handle the volume of trace spans and should be upgraded to
+	// more powerful hardware/networking.  Each destination has its own limit
+	// and its own `maxRequests` request slots, so a slow destination doesn't
+	// cause spans to be dropped for the others.
 	MaxTraceSpansInFlight uint `yaml:"maxTraceSpansInFlight" default:"100000"`
+	// The analogue of `maxTraceSpansInFlight` for datapoints.  Batches of
+	// datapoints that are waiting for a request slot or for a retry count
+	// towards this, so if ingest is unavailable or too slow, pending batches
+	// are dropped to make room for new datapoints instead of building up in
+	// memory.  Like the trace span limit, this applies to each destination
+	// separately.
+	MaxDatapointsInFlight uint `yaml:"maxDatapointsInFlight" default:"100000"`
+	// The maximum number of times to attempt sending a batch of datapoints,
+	// events or trace spans before giving up on it.  The default of 1
+	// disables retries.  Datapoint and trace span batches that are waiting to
+	// be retried still count towards `maxDatapointsInFlight` and
+	// `maxTraceSpansInFlight` and can be dropped to make room for newer ones.
+	RetryMaxAttempts int `yaml:"retryMaxAttempts" default:"1"`
+	// How long to wait before the first retry of a failed batch.  The wait is
+	// doubled for each subsequent retry, up to `retryMaxBackoff`.  This should
+	// be a duration string that is accepted by
+	// https://golang.org/pkg/time/#ParseDuration.
+	RetryInitialBackoff time.Duration `yaml:"retryInitialBackoff" default:"1s"`
+	// The longest to wait between retries of a failed batch.  If the server
+	// responds with a `Retry-After` header longer than this, the batch will
+	// not be retried.
+	RetryMaxBackoff time.Duration `yaml:"retryMaxBackoff" default:"30s"`
+	// The HTTP status codes that indicate that a failed request can be
+	// retried.  Requests that fail without getting a response (e.g.
+	// connection refused) are always considered retryable.
+	RetryStatusCodes []int `yaml:"retryStatusCodes" default:"[429, 500, 502, 503, 504]"`
+	// If set, batches of datapoints and events that fail to send to ingest
+	// will be written to files in this directory and resent, in the order
+	// they failed, once ingest is reachable again.  Batches in this directory
+	// are also resent if the agent restarts.  Batches that ingest rejects
+	// with a status code that is not in `retryStatusCodes` are never buffered
+	// since they would fail again.  If blank (the default), failed batches
+	// are dropped.
+	DiskBufferPath string `yaml:"diskBufferPath"`
+	// The maximum total size, in megabytes, of the batches held in
+	// `diskBufferPath`.  If this is exceeded, the oldest batches are discarded
+	// to make room for new ones.
+	DiskBufferMaxSizeMB uint `yaml:"diskBufferMaxSizeMB" default:"100"`
+	// How long to hold batches in `diskBufferPath` before giving up on them
+	// and discarding them.  This should be a duration string that is accepted
+	// by https://golang.org/pkg/time/#ParseDuration.
+	DiskBufferMaxAge time.Duration `yaml:"diskBufferMaxAge" default:"1h"`
+	// Which trace spans to send.  By default all spans are sent.
+	TraceSampling TraceSamplingConfig `yaml:"traceSampling" default:"{}"`
+	// Changes to make to trace span tags to remove sensitive information
+	// before spans are sent.  By default spans are sent unchanged.
+	SpanRedaction SpanRedactionConfig `yaml:"spanRedaction" default:"{}"`
+	// Request rate, error and latency metrics to derive from trace spans
+	SpanMetrics SpanMetricsConfig `yaml:"spanMetrics" default:"{}"`
+	// If set, every datapoint, event, trace span and dimension property
+	// update that the writer sends out will also be written to this file as
+	// one JSON object per line.  Datapoint and event records include the
+	// type of the monitor that generated them.  Set to `-` to write to stdout
+	// instead.  This is meant for debugging and comparing agent output
+	// between versions, not for shipping data.
+	JSONLinesPath string `yaml:"jsonLinesPath"`
+	// The size, in megabytes, at which the `jsonLinesPath` file is rotated.
+	// If 0, the file is never rotated.  Defaults to 100 if not set.
+	JSONLinesMaxSizeMB *uint `yaml:"jsonLinesMaxSizeMB"`
+	// How many rotated `jsonLinesPath` files to keep around, named with a
+	// `.1`, `.2`, etc. suffix, with `.1` being the newest.  If 0, the file is
+	// truncated when it is rotated.  Defaults to 3 if not set.
+	JSONLinesMaxBackups *int `yaml:"jsonLinesMaxBackups"`
+	// Additional places to send datapoints, events, trace spans and dimension
+	// properties to, on top of the top-level `ingestUrl`, `traceEndpointUrl`
+	// and `apiUrl`.  Each destination receives everything that passes the
+	// top-level filters, narrowed by its own filters.
+	Destinations []DestinationConfig `yaml:"destinations" default:"[]"`
 	// The following are propagated from elsewhere
 	HostIDDims          map[string]string      `yaml:"-"`
 	IngestURL           string                 `yaml:"-"`
@@ -86,15 +179,225 @@ type WriterConfig struct {
 	GlobalDimensions    map[string]string      `yaml:"-"`
 	MetricsToInclude    []MetricFilter         `yaml:"-"`
 	MetricsToExclude    []MetricFilter         `yaml:"-"`
+	MetricTransforms    []MetricTransform      `yaml:"-"`
 	PropertiesToExclude []PropertyFilterConfig `yaml:"-"`
+	EventsToInclude     []EventFilter          `yaml:"-"`
+	EventsToExclude     []EventFilter          `yaml:"-"`
+	SpansToExclude      []SpanFilter           `yaml:"-"`
 }
 
+// DestinationConfig describes an additional destination for the writer to
+// send data to.
+type DestinationConfig struct {
+	// A unique name for the destination that is used in logs, diagnostics and
+	// internal metrics, as well as for the directory under `diskBufferPath`
+	// that holds its failed batches.  Can only contain letters, digits, `_`
+	// and `-`.
+	Name string `yaml:"name"`
+	// The kind of backend that this destination sends to.  Can be `signalfx`
+	// or `prometheusRemoteWrite`.  Prometheus remote-write destinations only
+	// receive datapoints, which are converted to Prometheus time series with
+	// sanitized metric names and dimensions as labels.  Cumulative counters
+	// are sent as counters and everything else as gauges.  Datapoints with
+	// string values are not sent.
+	Type string `yaml:"type" default:"signalfx"`
+	// The full URL (including path) of the Prometheus remote-write endpoint.
+	// Required if `type` is `prometheusRemoteWrite`.
+	RemoteWriteURL string `yaml:"remoteWriteUrl"`
+	// Extra HTTP headers to send on each Prometheus remote-write request, for
+	// example `X-Scope-OrgID` for multi-tenant Cortex.
+	RemoteWriteHeaders map[string]string `yaml:"remoteWriteHeaders" neverLog:"true"`
+	// How long to wait for a Prometheus remote-write request to complete
+	// before giving up on it.  This should be a duration string that is
+	// accepted by https://golang.org/pkg/time/#ParseDuration.
+	RemoteWriteTimeout time.Duration `yaml:"remoteWriteTimeout" default:"5s"`
+	// The base URL of the ingest server for this destination.  If not set,
+	// the top-level `ingestUrl` will be used.
+	IngestURL string `yaml:"ingestUrl"`
+	// The full URL (including path) to the trace ingest server for this
+	// destination.  If not set, trace spans will be sent to this
+	// destination's `ingestUrl`.
+	TraceEndpointURL string `yaml:"traceEndpointUrl"`
+	// The SignalFx API base URL that dimension properties are synced to.  If
+	// not set, the top-level `apiUrl` will be used.
+	APIURL string `yaml:"apiUrl"`
+	// The access token for the org that should receive data sent to this
+	// destination.  If not set, the top-level `signalFxAccessToken` will be
+	// used.
+	SignalFxAccessToken string `yaml:"signalFxAccessToken" neverLog:"true"`
+	// A list of metric filters that will whitelist/include metrics for this
+	// destination.  These filters take priority over the filters specified
+	// in `metricsToExclude`.
+	MetricsToInclude []MetricFilter `yaml:"metricsToInclude" default:"[]"`
+	// A list of metric filters that exclude datapoints from being sent to
+	// this destination.
+	MetricsToExclude []MetricFilter `yaml:"metricsToExclude" default:"[]"`
+	// Whether to send datapoints to this destination
+	SendDatapoints *bool `yaml:"sendDatapoints" default:"true"`
+	// Whether to send events to this destination
+	SendEvents *bool `yaml:"sendEvents" default:"true"`
+	// Whether to send trace spans to this destination
+	SendTraceSpans *bool `yaml:"sendTraceSpans" default:"true"`
+	// Whether to sync dimension properties to this destination
+	SendDimensionProperties *bool `yaml:"sendDimensionProperties" default:"true"`
+}
+
+// ParsedIngestURL parses and returns the ingest URL
+func (dc *DestinationConfig) ParsedIngestURL() *url.URL {
+	ingestURL, err := url.Parse(dc.IngestURL)
+	if err != nil {
+		panic("IngestURL was supposed to be validated already")
+	}
+	return ingestURL
+}
+
+// ParsedAPIURL parses and returns the API server URL
+func (dc *DestinationConfig) ParsedAPIURL() *url.URL {
+	apiURL, err := url.Parse(dc.APIURL)
+	if err != nil {
+		panic("apiUrl was supposed to be validated already")
+	}
+	return apiURL
+}
+
+// ParsedTraceEndpointURL parses and returns the trace endpoint server URL
+func (dc *DestinationConfig) ParsedTraceEndpointURL() *url.URL {
+	if dc.TraceEndpointURL != "" {
+		traceEndpointURL, err := url.Parse(dc.TraceEndpointURL)
+		if err != nil {
+			panic("traceEndpointUrl was supposed to be validated already")
+		}
+		return traceEndpointURL
+	}
+	return nil
+}
+
+// DatapointFilters creates the filter set for datapoints sent to this
+// destination
+func (dc *DestinationConfig) DatapointFilters() (*dpfilters.FilterSet, error) {
+	return makeFilterSet(dc.MetricsToExclude, dc.MetricsToInclude)
+}
+
+// Destination types
+const (
+	DestinationTypeSignalFx              = "signalfx"
+	DestinationTypePrometheusRemoteWrite = "prometheusRemoteWrite"
+)
+
 func (wc *WriterConfig) initialize() {
 	if wc.DatapointMaxRequests != 0 {
 		wc.MaxRequests = wc.DatapointMaxRequests
 	} else {
 		wc.DatapointMaxRequests = wc.MaxRequests
 	}
+
+	// These are pointers without default tags since the defaults would
+	// otherwise replace an explicit 0
+	if wc.PropertiesMaxRequestsPerSecond == nil {
+		wc.PropertiesMaxRequestsPerSecond = pointer.Float64(20)
+	}
+	if wc.JSONLinesMaxSizeMB == nil {
+		wc.JSONLinesMaxSizeMB = pointer.Uint(100)
+	}
+	if wc.JSONLinesMaxBackups == nil {
+		wc.JSONLinesMaxBackups = pointer.Int(3)
+	}
+
+	for i := range wc.Destinations {
+		// Defaults aren't set on structs within slices
+		if err := defaults.Set(&wc.Destinations[i]); err != nil {
+			panic(fmt.Sprintf("Destination config defaults are wrong types: %s", err))
+		}
+	}
+
+	wc.TraceSampling.initialize()
+	wc.SpanRedaction.initialize()
+}
+
+// Destination names are used as directory names so they must not contain path
+// separators or dots
+var destinationNameRE = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func (wc *WriterConfig) validate() error {
+	if err := wc.TraceSampling.validate(); err != nil {
+		return err
+	}
+	if err := wc.SpanRedaction.validate(); err != nil {
+		return err
+	}
+	if err := wc.SpanMetrics.validate(); err != nil {
+		return err
+	}
+
+	names := map[string]bool{}
+	for _, dest := range wc.Destinations {
+		if dest.Name == "" {
+			return errors.New("writer destinations must have a name")
+		}
+		if !destinationNameRE.MatchString(dest.Name) {
+			return fmt.Errorf("writer destination name %s can only contain letters, digits, _ and -", dest.Name)
+		}
+		if names[dest.Name] {
+			return fmt.Errorf("writer destination name %s is used more than once", dest.Name)
+		}
+		names[dest.Name] = true
+
+		switch dest.Type {
+		case DestinationTypeSignalFx:
+		case DestinationTypePrometheusRemoteWrite:
+			if dest.RemoteWriteURL == "" {
+				return fmt.Errorf("writer destination %s must have remoteWriteUrl set", dest.Name)
+			}
+		default:
+			return fmt.Errorf("writer destination %s has unknown type %s", dest.Name, dest.Type)
+		}
+
+		for _, u := range []string{dest.IngestURL, dest.TraceEndpointURL, dest.APIURL, dest.RemoteWriteURL} {
+			if _, err := url.Parse(u); err != nil {
+				return errors.WithMessage(err, fmt.Sprintf("%s is not a valid URL in writer destination %s", u, dest.Name))
+			}
+		}
+	}
+	return nil
+}
+
+// Fills in any unset values on the destinations from the top-level config
+func (wc *WriterConfig) propagateToDestinations() {
+	for i := range wc.Destinations {
+		dest := &wc.Destinations[i]
+		if dest.IngestURL == "" {
+			dest.IngestURL = wc.IngestURL
+			// Only inherit the trace endpoint if the ingest URL is also
+			// inherited since otherwise spans should go to the destination's
+			// own ingest server.
+			if dest.TraceEndpointURL == "" {
+				dest.TraceEndpointURL = wc.TraceEndpointURL
+			}
+		}
+		if dest.APIURL == "" {
+			dest.APIURL = wc.APIURL
+		}
+		if dest.SignalFxAccessToken == "" {
+			dest.SignalFxAccessToken = wc.SignalFxAccessToken
+		}
+	}
+}
+
+// PrimaryDestination returns a destination config that represents the
+// top-level ingest URL, trace endpoint URL, API URL and access token.  The
+// top-level filters are not included since they apply to all destinations.
+func (wc *WriterConfig) PrimaryDestination() *DestinationConfig {
+	return &DestinationConfig{
+		Type:                    DestinationTypeSignalFx,
+		IngestURL:               wc.IngestURL,
+		TraceEndpointURL:        wc.TraceEndpointURL,
+		APIURL:                  wc.APIURL,
+		SignalFxAccessToken:     wc.SignalFxAccessToken,
+		SendDatapoints:          pointer.Bool(true),
+		SendEvents:              pointer.Bool(true),
+		SendTraceSpans:          pointer.Bool(true),
+		SendDimensionProperties: pointer.Bool(true),
+	}
 }
 
 // ParsedIngestURL parses and returns the ingest URL
@@ -132,6 +435,21 @@ func (wc *WriterConfig) DatapointFilters() (*dpfilters.FilterSet, error) {
 	return makeFilterSet(wc.MetricsToExclude, wc.MetricsToInclude)
 }
 
+// DatapointTransforms creates the transforms for datapoints
+func (wc *WriterConfig) DatapointTransforms() ([]*dptransforms.Transform, error) {
+	return makeTransforms(wc.MetricTransforms)
+}
+
+// EventFilters creates the filter set for events
+func (wc *WriterConfig) EventFilters() (*eventfilters.FilterSet, error) {
+	return makeEventFilterSet(wc.EventsToExclude, wc.EventsToInclude)
+}
+
+// SpanFilters creates the filter set for trace spans
+func (wc *WriterConfig) SpanFilters() (*spanfilters.FilterSet, error) {
+	return makeSpanFilterSet(wc.SpansToExclude)
+}
+
 // PropertyFilters creates the filter set for dimension properties
 func (wc *WriterConfig) PropertyFilters() (*propfilters.FilterSet, error) {
 	return makePropertyFilterSet(wc.PropertiesToExclude)
@@ -0,0 +1,159 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/creasty/defaults"
+	"github.com/signalfx/golib/datapoint"
+	"github.com/stretchr/testify/assert"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func newTestWriterConfig(dests ...DestinationConfig) *WriterConfig {
+	wc := &WriterConfig{}
+	if err := defaults.Set(wc); err != nil {
+		panic(err)
+	}
+	wc.Destinations = dests
+	wc.initialize()
+	return wc
+}
+
+// Mirrors the order that the loader sets things up in
+func loadTestWriterConfig(t *testing.T, content string) *WriterConfig {
+	wc := &WriterConfig{}
+	assert.Nil(t, yaml.UnmarshalStrict([]byte(content), wc))
+	assert.Nil(t, defaults.Set(wc))
+	wc.initialize()
+	return wc
+}
+
+func TestJSONLinesRotationDefaults(t *testing.T) {
+	wc := loadTestWriterConfig(t, `jsonLinesPath: /tmp/out.jsonl`)
+	assert.Equal(t, uint(100), *wc.JSONLinesMaxSizeMB)
+	assert.Equal(t, 3, *wc.JSONLinesMaxBackups)
+
+	wc = loadTestWriterConfig(t, `
+jsonLinesPath: /tmp/out.jsonl
+jsonLinesMaxSizeMB: 0
+jsonLinesMaxBackups: 0
+`)
+	assert.Equal(t, uint(0), *wc.JSONLinesMaxSizeMB)
+	assert.Equal(t, 0, *wc.JSONLinesMaxBackups)
+}
+
+func TestTraceSamplingPercentages(t *testing.T) {
+	wc := loadTestWriterConfig(t, `
+traceSampling:
+  rules:
+    - serviceName: api
+`)
+	assert.Equal(t, float64(100), *wc.TraceSampling.SamplingPercentage)
+	assert.Equal(t, float64(100), *wc.TraceSampling.Rules[0].SamplingPercentage)
+
+	wc = loadTestWriterConfig(t, `
+traceSampling:
+  samplingPercentage: 0
+  rules:
+    - serviceName: api
+      samplingPercentage: 0
+`)
+	assert.Equal(t, float64(0), *wc.TraceSampling.SamplingPercentage)
+	assert.Equal(t, float64(0), *wc.TraceSampling.Rules[0].SamplingPercentage)
+	assert.True(t, wc.TraceSampling.Enabled())
+}
+
+func TestSpanTagMaskReplacement(t *testing.T) {
+	wc := loadTestWriterConfig(t, `
+spanRedaction:
+  maskValues:
+    - pattern: secret
+    - pattern: token
+      replacement: ""
+`)
+	assert.Equal(t, "****", *wc.SpanRedaction.MaskValues[0].Replacement)
+	assert.Equal(t, "", *wc.SpanRedaction.MaskValues[1].Replacement)
+}
+
+func TestDestinationValidation(t *testing.T) {
+	for _, tc := range []struct {
+		desc  string
+		dests []DestinationConfig
+		valid bool
+	}{
+		{"no destinations", nil, true},
+		{"plain name", []DestinationConfig{{Name: "backup_org-2"}}, true},
+		{"missing name", []DestinationConfig{{}}, false},
+		{"duplicate names", []DestinationConfig{{Name: "a"}, {Name: "a"}}, false},
+		{"path traversal in name", []DestinationConfig{{Name: "../../x"}}, false},
+		{"path separator in name", []DestinationConfig{{Name: "a/b"}}, false},
+		{"dot in name", []DestinationConfig{{Name: "a.b"}}, false},
+		{"unknown type", []DestinationConfig{{Name: "a", Type: "graphite"}}, false},
+		{"remote write without URL", []DestinationConfig{{Name: "a", Type: DestinationTypePrometheusRemoteWrite}}, false},
+		{"remote write with URL", []DestinationConfig{{
+			Name:           "a",
+			Type:           DestinationTypePrometheusRemoteWrite,
+			RemoteWriteURL: "http://cortex/api/prom/push",
+		}}, true},
+		{"invalid URL", []DestinationConfig{{Name: "a", IngestURL: "http://[::1"}}, false},
+	} {
+		err := newTestWriterConfig(tc.dests...).validate()
+		assert.Equal(t, tc.valid, err == nil, "%s: %v", tc.desc, err)
+	}
+}
+
+func TestPropagateToDestinations(t *testing.T) {
+	wc := newTestWriterConfig(
+		DestinationConfig{Name: "inherits"},
+		DestinationConfig{
+			Name:                "own-ingest",
+			IngestURL:           "https://ingest.other",
+			APIURL:              "https://api.other",
+			SignalFxAccessToken: "other-token",
+		},
+	)
+	wc.IngestURL = "https://ingest.main"
+	wc.TraceEndpointURL = "https://trace.main/v1/trace"
+	wc.APIURL = "https://api.main"
+	wc.SignalFxAccessToken = "main-token"
+
+	wc.propagateToDestinations()
+
+	inherits := wc.Destinations[0]
+	assert.Equal(t, "https://ingest.main", inherits.IngestURL)
+	assert.Equal(t, "https://trace.main/v1/trace", inherits.TraceEndpointURL)
+	assert.Equal(t, "https://api.main", inherits.APIURL)
+	assert.Equal(t, "main-token", inherits.SignalFxAccessToken)
+
+	own := wc.Destinations[1]
+	assert.Equal(t, "https://ingest.other", own.IngestURL)
+	// Spans go to the destination's own ingest server
+	assert.Equal(t, "", own.TraceEndpointURL)
+	assert.Equal(t, "https://api.other", own.APIURL)
+	assert.Equal(t, "other-token", own.SignalFxAccessToken)
+}
+
+func TestDestinationDefaults(t *testing.T) {
+	wc := newTestWriterConfig(DestinationConfig{Name: "a"})
+
+	dest := wc.Destinations[0]
+	assert.Equal(t, DestinationTypeSignalFx, dest.Type)
+	assert.True(t, *dest.SendDatapoints)
+	assert.True(t, *dest.SendEvents)
+	assert.True(t, *dest.SendTraceSpans)
+	assert.True(t, *dest.SendDimensionProperties)
+}
+
+func TestDestinationDatapointFilters(t *testing.T) {
+	dest := DestinationConfig{
+		Name:             "a",
+		MetricsToExclude: []MetricFilter{{MetricNames: []string{"cpu.*"}}},
+		MetricsToInclude: []MetricFilter{{MetricNames: []string{"cpu.utilization"}}},
+	}
+
+	f, err := dest.DatapointFilters()
+	assert.Nil(t, err)
+	assert.True(t, f.Matches(&datapoint.Datapoint{Metric: "cpu.idle"}))
+	assert.False(t, f.Matches(&datapoint.Datapoint{Metric: "cpu.utilization"}))
+	assert.False(t, f.Matches(&datapoint.Datapoint{Metric: "memory.used"}))
+}
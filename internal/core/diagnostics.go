@@ -22,15 +22,33 @@ import (
 // information that can be reported in diagnostics.
 var VersionLine string
 
+// The config that the diagnostic server was started with
+type diagnosticServerSettings struct {
+	host          string
+	port          uint16
+	managementAPI config.ManagementAPIConfig
+}
+
 // Serves the diagnostic status on the specified path
-func (a *Agent) serveDiagnosticInfo(host string, port uint16) error {
+func (a *Agent) serveDiagnosticInfo(host string, port uint16, managementAPI config.ManagementAPIConfig) error {
+	settings := diagnosticServerSettings{host: host, port: port, managementAPI: managementAPI}
 	if a.diagnosticServer != nil {
+		// Don't restart the server needlessly, since the config that caused
+		// this might have been reloaded by a request to the server itself
+		if settings == a.diagnosticServerSettings {
+			return nil
+		}
 		a.diagnosticServer.Close()
 	}
+	a.diagnosticServerSettings = settings
 
 	mux := http.NewServeMux()
 	mux.Handle("/", http.HandlerFunc(a.diagnosticTextHandler))
 	mux.Handle("/metrics", http.HandlerFunc(a.internalMetricsHandler))
+	mux.Handle("/filters", http.HandlerFunc(a.filterTraceHandler))
+	if managementAPI.Enabled {
+		a.registerManagementAPI(mux, managementAPI)
+	}
 
 	a.diagnosticServer = &http.Server{
 		Addr:         fmt.Sprintf("%s:%d", host, port),
@@ -54,8 +72,8 @@ func (a *Agent) serveDiagnosticInfo(host string, port uint16) error {
 	return nil
 }
 
-func readStatusInfo(host string, port uint16) ([]byte, error) {
-	resp, err := http.Get(fmt.Sprintf("http://%s:%d/", host, port))
+func readStatusInfo(host string, port uint16, path string) ([]byte, error) {
+	resp, err := http.Get(fmt.Sprintf("http://%s:%d%s", host, port, path))
 	if err != nil {
 		return nil, err
 	}
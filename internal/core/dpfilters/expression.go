@@ -0,0 +1,154 @@
+package dpfilters
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Knetic/govaluate"
+	"github.com/pkg/errors"
+	"github.com/signalfx/golib/datapoint"
+	"github.com/signalfx/signalfx-agent/internal/core/common/dpmeta"
+	log "github.com/sirupsen/logrus"
+)
+
+// The prefix of expression variables that refer to a dimension value
+const dimensionVarPrefix = "dimensions."
+
+// govaluate doesn't allow dots in plain variable names, so dimension accesses
+// like `dimensions.env` get rewritten to the escaped form `[dimensions.env]`.
+var dimensionAccessRegexp = regexp.MustCompile(`\bdimensions\.(\w+)`)
+
+var expressionVars = map[string]bool{
+	"metric":      true,
+	"value":       true,
+	"type":        true,
+	"monitorType": true,
+}
+
+// compileExpression parses a datapoint filter expression and makes sure that
+// it only refers to variables that are available on datapoints.
+func compileExpression(text string) (*govaluate.EvaluableExpression, error) {
+	expr, err := govaluate.NewEvaluableExpression(escapeDimensionAccess(text))
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not parse filter expression %q", text)
+	}
+
+	for _, v := range expr.Vars() {
+		if !expressionVars[v] && !strings.HasPrefix(v, dimensionVarPrefix) {
+			return nil, fmt.Errorf("filter expression %q refers to unknown variable %s", text, v)
+		}
+	}
+	return expr, nil
+}
+
+// Rewrites dimension accesses outside of string literals and already escaped
+// variables
+func escapeDimensionAccess(text string) string {
+	var sb strings.Builder
+	// The start of the current section that should be rewritten
+	start := 0
+	// The character that ends the current section that shouldn't be
+	// rewritten, or 0 if not in one
+	var closer rune
+
+	for i, c := range text {
+		if closer != 0 {
+			if c == closer {
+				sb.WriteString(text[start : i+1])
+				start = i + 1
+				closer = 0
+			}
+			continue
+		}
+
+		switch c {
+		case '"', '\'':
+			closer = c
+		case '[':
+			closer = ']'
+		default:
+			continue
+		}
+		sb.WriteString(dimensionAccessRegexp.ReplaceAllString(text[start:i], "[dimensions.$1]"))
+		start = i
+	}
+
+	if closer != 0 {
+		// Unterminated, let the parser complain about it
+		sb.WriteString(text[start:])
+	} else {
+		sb.WriteString(dimensionAccessRegexp.ReplaceAllString(text[start:], "[dimensions.$1]"))
+	}
+	return sb.String()
+}
+
+// Exposes a datapoint to expressions
+type datapointParameters struct {
+	dp *datapoint.Datapoint
+}
+
+var _ govaluate.Parameters = datapointParameters{}
+
+func (p datapointParameters) Get(name string) (interface{}, error) {
+	switch name {
+	case "metric":
+		return p.dp.Metric, nil
+	case "value":
+		return datapointValue(p.dp.Value), nil
+	case "type":
+		return metricTypeName(p.dp.MetricType), nil
+	case "monitorType":
+		monitorType, _ := p.dp.Meta[dpmeta.MonitorTypeMeta].(string)
+		return monitorType, nil
+	}
+
+	if strings.HasPrefix(name, dimensionVarPrefix) {
+		// Missing dimensions are treated as blank so that they can still be
+		// compared and matched against
+		return p.dp.Dimensions[strings.TrimPrefix(name, dimensionVarPrefix)], nil
+	}
+	return nil, fmt.Errorf("no variable %s on datapoints", name)
+}
+
+// govaluate does all of its arithmetic and comparisons with float64
+func datapointValue(v datapoint.Value) interface{} {
+	switch val := v.(type) {
+	case datapoint.IntValue:
+		return float64(val.Int())
+	case datapoint.FloatValue:
+		return val.Float()
+	case nil:
+		return nil
+	default:
+		return v.String()
+	}
+}
+
+// Uses the same names as the SignalFx API
+func metricTypeName(t datapoint.MetricType) string {
+	switch t {
+	case datapoint.Gauge:
+		return "gauge"
+	case datapoint.Count:
+		return "counter"
+	case datapoint.Counter:
+		return "cumulative_counter"
+	default:
+		return ""
+	}
+}
+
+func evaluateExpression(expr *govaluate.EvaluableExpression, dp *datapoint.Datapoint) bool {
+	res, err := expr.Eval(datapointParameters{dp: dp})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":      err,
+			"expression": expr.String(),
+			"metric":     dp.Metric,
+		}).Debug("Could not evaluate filter expression, treating as not matched")
+		return false
+	}
+	matched, _ := res.(bool)
+	return matched
+}
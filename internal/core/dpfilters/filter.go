@@ -4,6 +4,7 @@
 package dpfilters
 
 import (
+	"github.com/Knetic/govaluate"
 	"github.com/signalfx/golib/datapoint"
 	"github.com/signalfx/signalfx-agent/internal/core/common/dpmeta"
 	"github.com/signalfx/signalfx-agent/internal/utils/filter"
@@ -21,16 +22,27 @@ type DatapointFilter interface {
 // supports both static, globbed, and regex patterns for filter values. If
 // dimensions are specifed, they must all match for the datapoint to match. If
 // multiple metric names are given, only one must match for the datapoint to
-// match the filter since datapoints can only have one metric name.
+// match the filter since datapoints can only have one metric name.  If an
+// expression is given, it must also evaluate to true for the datapoint to
+// match.
 type basicDatapointFilter struct {
 	monitorType  string
 	dimFilter    filter.StringMapFilter
 	metricFilter filter.StringFilter
+	expression   *govaluate.EvaluableExpression
 	negated      bool
 }
 
 // New returns a new filter with the given configuration
 func New(monitorType string, metricNames []string, dimensions map[string]string, negated bool) (DatapointFilter, error) {
+	return NewWithExpression(monitorType, metricNames, dimensions, "", negated)
+}
+
+// NewWithExpression returns a new filter with the given configuration that
+// also requires the given boolean expression to be true for a datapoint to
+// match.  The expression can refer to the variables `metric`, `value`,
+// `type`, `monitorType` and `dimensions.<key>`.
+func NewWithExpression(monitorType string, metricNames []string, dimensions map[string]string, expression string, negated bool) (DatapointFilter, error) {
 	var dimFilter filter.StringMapFilter
 	if len(dimensions) > 0 {
 		var err error
@@ -49,10 +61,20 @@ func New(monitorType string, metricNames []string, dimensions map[string]string,
 		}
 	}
 
+	var expr *govaluate.EvaluableExpression
+	if expression != "" {
+		var err error
+		expr, err = compileExpression(expression)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &basicDatapointFilter{
 		monitorType:  monitorType,
 		metricFilter: metricFilter,
 		dimFilter:    dimFilter,
+		expression:   expr,
 		negated:      negated,
 	}, nil
 }
@@ -68,7 +90,8 @@ func (f *basicDatapointFilter) Matches(dp *datapoint.Datapoint) bool {
 	}
 
 	matched := (f.metricFilter == nil || f.metricFilter.Matches(dp.Metric)) &&
-		(f.dimFilter == nil || f.dimFilter.Matches(dp.Dimensions))
+		(f.dimFilter == nil || f.dimFilter.Matches(dp.Dimensions)) &&
+		(f.expression == nil || evaluateExpression(f.expression, dp))
 
 	if f.negated {
 		return !matched
@@ -2,9 +2,11 @@ package dpfilters
 
 import (
 	"testing"
+	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/signalfx/golib/datapoint"
+	"github.com/signalfx/signalfx-agent/internal/core/common/dpmeta"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -196,3 +198,95 @@ func TestFilters(t *testing.T) {
 		assert.True(t, f.Matches(&datapoint.Datapoint{Metric: "cpu.utilization"}))
 	})
 }
+
+func TestExpressionFilters(t *testing.T) {
+	dp := func(metric string, val datapoint.Value, dims map[string]string) *datapoint.Datapoint {
+		d := datapoint.New(metric, dims, val, datapoint.Gauge, time.Now())
+		d.Meta = map[interface{}]interface{}{dpmeta.MonitorTypeMeta: "collectd/genericjmx"}
+		return d
+	}
+
+	t.Run("Matches on metric, value and dimensions", func(t *testing.T) {
+		f, err := NewWithExpression("", nil, nil, `metric =~ "^jvm\\." && dimensions.env != "prod" && value == 0`, false)
+		assert.Nil(t, err)
+
+		assert.True(t, f.Matches(dp("jvm.threads", datapoint.NewIntValue(0), map[string]string{"env": "dev"})))
+		assert.True(t, f.Matches(dp("jvm.threads", datapoint.NewFloatValue(0), nil)))
+		assert.False(t, f.Matches(dp("jvm.threads", datapoint.NewIntValue(0), map[string]string{"env": "prod"})))
+		assert.False(t, f.Matches(dp("jvm.threads", datapoint.NewIntValue(5), nil)))
+		assert.False(t, f.Matches(dp("jvmx", datapoint.NewIntValue(0), nil)))
+	})
+
+	t.Run("Matches on type and monitor type", func(t *testing.T) {
+		f, err := NewWithExpression("", nil, nil, `type == "gauge" && monitorType == "collectd/genericjmx"`, false)
+		assert.Nil(t, err)
+		assert.True(t, f.Matches(dp("a", datapoint.NewIntValue(1), nil)))
+	})
+
+	t.Run("Supports escaped dimension keys and strings that look like dimensions", func(t *testing.T) {
+		f, err := NewWithExpression("", nil, nil, `[dimensions.host-name] == "dimensions.a" || dimensions.a == "b"`, false)
+		assert.Nil(t, err)
+		assert.True(t, f.Matches(dp("a", datapoint.NewIntValue(1), map[string]string{"host-name": "dimensions.a"})))
+		assert.False(t, f.Matches(dp("a", datapoint.NewIntValue(1), map[string]string{"host-name": "b"})))
+	})
+
+	t.Run("Combines with other criteria and negation", func(t *testing.T) {
+		f, err := NewWithExpression("", []string{"cpu.*"}, nil, `value > 90`, true)
+		assert.Nil(t, err)
+		assert.False(t, f.Matches(dp("cpu.utilization", datapoint.NewFloatValue(95), nil)))
+		assert.True(t, f.Matches(dp("cpu.utilization", datapoint.NewFloatValue(50), nil)))
+		assert.True(t, f.Matches(dp("memory.utilization", datapoint.NewFloatValue(95), nil)))
+	})
+
+	t.Run("Rejects invalid expressions", func(t *testing.T) {
+		_, err := NewWithExpression("", nil, nil, `metric ==`, false)
+		assert.NotNil(t, err)
+
+		_, err = NewWithExpression("", nil, nil, `metrc == "a"`, false)
+		assert.NotNil(t, err)
+	})
+}
+
+func TestFilterTracing(t *testing.T) {
+	exclude, _ := New("", []string{"cpu.*"}, nil, false)
+	include, _ := New("", []string{"cpu.idle"}, nil, false)
+	fs := &FilterSet{
+		ExcludeFilters: []DatapointFilter{Tracked(exclude, "metricNames: [cpu.*]")},
+		IncludeFilters: []DatapointFilter{Tracked(include, "metricNames: [cpu.idle]")},
+	}
+
+	dp := func(metric string) *datapoint.Datapoint {
+		return datapoint.New(metric, nil, datapoint.NewIntValue(1), datapoint.Gauge, time.Now())
+	}
+
+	t.Run("Explains decisions without counting matches", func(t *testing.T) {
+		d := fs.Explain(dp("cpu.user"))
+		assert.True(t, d.Excluded)
+		assert.Equal(t, "excluded by exclude filter #1 (metricNames: [cpu.*])", d.String())
+
+		d = fs.Explain(dp("cpu.idle"))
+		assert.False(t, d.Excluded)
+		assert.Equal(t, "allowed by include filter #1 (metricNames: [cpu.idle]), overriding exclude filter #1 (metricNames: [cpu.*])", d.String())
+
+		d = fs.Explain(dp("memory.used"))
+		assert.False(t, d.Excluded)
+		assert.Equal(t, "not matched by any filter", d.String())
+
+		for _, s := range fs.Stats() {
+			assert.Equal(t, int64(0), s.Matches)
+		}
+	})
+
+	t.Run("Counts matches", func(t *testing.T) {
+		assert.True(t, fs.Matches(dp("cpu.user")))
+		assert.True(t, fs.Matches(dp("cpu.system")))
+		assert.False(t, fs.Matches(dp("cpu.idle")))
+		assert.False(t, fs.Matches(dp("memory.used")))
+
+		stats := fs.Stats()
+		assert.Equal(t, []FilterStat{
+			{Description: "exclude filter #1 (metricNames: [cpu.*])", Matches: 3},
+			{Description: "include filter #1 (metricNames: [cpu.idle])", Matches: 1},
+		}, stats)
+	})
+}
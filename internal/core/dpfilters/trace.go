@@ -0,0 +1,116 @@
+package dpfilters
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/signalfx/golib/datapoint"
+)
+
+// trackedFilter wraps a filter with a human readable description of it and
+// keeps count of how many datapoints it has matched, so that filters that
+// never match anything can be spotted.
+type trackedFilter struct {
+	DatapointFilter
+	description string
+	matches     int64
+}
+
+// Tracked wraps the given filter so that it is described by the given text in
+// filter traces and stats and counts its matches.
+func Tracked(f DatapointFilter, description string) DatapointFilter {
+	return &trackedFilter{
+		DatapointFilter: f,
+		description:     description,
+	}
+}
+
+func (f *trackedFilter) Matches(dp *datapoint.Datapoint) bool {
+	if f.DatapointFilter.Matches(dp) {
+		atomic.AddInt64(&f.matches, 1)
+		return true
+	}
+	return false
+}
+
+// Matches without counting it, for hypothetical datapoints
+func matchesUntracked(f DatapointFilter, dp *datapoint.Datapoint) bool {
+	if tf, ok := f.(*trackedFilter); ok {
+		return tf.DatapointFilter.Matches(dp)
+	}
+	return f.Matches(dp)
+}
+
+func describe(f DatapointFilter, kind string, index int) string {
+	if tf, ok := f.(*trackedFilter); ok {
+		return fmt.Sprintf("%s filter #%d (%s)", kind, index+1, tf.description)
+	}
+	return fmt.Sprintf("%s filter #%d", kind, index+1)
+}
+
+// Decision describes how a filter set treats a datapoint
+type Decision struct {
+	// Whether the datapoint is filtered out
+	Excluded bool
+	// The exclude filter that matched the datapoint, if any
+	ExcludedBy string
+	// The include filter that overrode the exclusion, if any
+	IncludedBy string
+}
+
+func (d Decision) String() string {
+	switch {
+	case d.Excluded:
+		return "excluded by " + d.ExcludedBy
+	case d.IncludedBy != "":
+		return fmt.Sprintf("allowed by %s, overriding %s", d.IncludedBy, d.ExcludedBy)
+	default:
+		return "not matched by any filter"
+	}
+}
+
+// Explain returns how the filter set would treat the given datapoint and
+// which filters were responsible.  It follows the same logic as Matches but
+// doesn't count towards the filters' match counts.
+func (fs *FilterSet) Explain(dp *datapoint.Datapoint) Decision {
+	for i, ex := range fs.ExcludeFilters {
+		if matchesUntracked(ex, dp) {
+			d := Decision{ExcludedBy: describe(ex, "exclude", i)}
+			for j, incl := range fs.IncludeFilters {
+				if matchesUntracked(incl, dp) {
+					d.IncludedBy = describe(incl, "include", j)
+					return d
+				}
+			}
+			d.Excluded = true
+			return d
+		}
+	}
+	return Decision{}
+}
+
+// FilterStat is the number of datapoints matched by a single filter
+type FilterStat struct {
+	Description string
+	Matches     int64
+}
+
+// Stats returns the match counts of the tracked filters in the set.  Include
+// filters are only counted when they override an exclusion.
+func (fs *FilterSet) Stats() []FilterStat {
+	var out []FilterStat
+	for _, group := range []struct {
+		kind    string
+		filters []DatapointFilter
+	}{{"exclude", fs.ExcludeFilters}, {"include", fs.IncludeFilters}} {
+		for i, f := range group.filters {
+			if tf, ok := f.(*trackedFilter); ok {
+				out = append(out, FilterStat{
+					Description: describe(f, group.kind, i),
+					Matches:     atomic.LoadInt64(&tf.matches),
+				})
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,151 @@
+// Package dptransforms has logic for rewriting the metric names and
+// dimensions of datapoints before they are sent.  Transforms are configured
+// from the agent configuration file and are applied by the writer.
+package dptransforms
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/signalfx/golib/datapoint"
+	"github.com/signalfx/signalfx-agent/internal/core/dpfilters"
+	"github.com/signalfx/signalfx-agent/internal/utils"
+)
+
+// DimensionValueReplacement replaces the parts of a dimension's value that
+// match a regex
+type DimensionValueReplacement struct {
+	Dimension   string
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// Transform rewrites the metric name and dimensions of the datapoints that
+// match its filter.  The changes are made in the following order: dimensions
+// are copied, dimension keys are renamed, dimension values are replaced,
+// dimensions are dropped, and finally the metric is renamed.
+type Transform struct {
+	filter dpfilters.DatapointFilter
+	// Regexes from the metric names in the filter, used to expand capture
+	// group references in renameMetric
+	metricRegexps []*regexp.Regexp
+
+	renameMetric        string
+	renameDimensionKeys map[string]string
+	replaceValues       []DimensionValueReplacement
+	dropDimensions      []string
+	copyDimensions      map[string]string
+}
+
+// New returns a new transform.  The monitorType, metricNames and dimensions
+// have the same meaning as they do for datapoint filters.  If renameMetric
+// is not blank, the metric will be renamed to it.  It can refer to capture
+// groups (e.g. `$1` or `${name}`) in any regex metric names.
+// renameDimensionKeys maps the existing dimension keys to their new keys and
+// copyDimensions maps new dimension keys to the existing dimension keys whose
+// values they should be given.  Two dimension keys cannot be renamed to the
+// same key since which one would win would be arbitrary.
+func New(monitorType string, metricNames []string, dimensions map[string]string,
+	renameMetric string, renameDimensionKeys map[string]string, replaceValues []DimensionValueReplacement,
+	dropDimensions []string, copyDimensions map[string]string) (*Transform, error) {
+
+	filter, err := dpfilters.New(monitorType, metricNames, dimensions, false)
+	if err != nil {
+		return nil, err
+	}
+
+	renamedFrom := map[string]string{}
+	for from, to := range renameDimensionKeys {
+		if other, ok := renamedFrom[to]; ok {
+			return nil, fmt.Errorf("dimension keys %q and %q cannot both be renamed to %q", other, from, to)
+		}
+		renamedFrom[to] = from
+	}
+
+	var metricRegexps []*regexp.Regexp
+	for _, name := range metricNames {
+		if len(name) > 2 && strings.HasPrefix(name, "/") && strings.HasSuffix(name, "/") {
+			re, err := regexp.Compile(name[1 : len(name)-1])
+			if err != nil {
+				return nil, err
+			}
+			metricRegexps = append(metricRegexps, re)
+		}
+	}
+
+	return &Transform{
+		filter:              filter,
+		metricRegexps:       metricRegexps,
+		renameMetric:        renameMetric,
+		renameDimensionKeys: renameDimensionKeys,
+		replaceValues:       replaceValues,
+		dropDimensions:      dropDimensions,
+		copyDimensions:      copyDimensions,
+	}, nil
+}
+
+// Apply mutates the datapoint if it matches the transform's filter.  The
+// dimension map is copied before it is changed since monitors sometimes share
+// a single map between datapoints.
+func (t *Transform) Apply(dp *datapoint.Datapoint) {
+	if !t.filter.Matches(dp) {
+		return
+	}
+
+	if t.changesDimensions() {
+		dims := utils.CloneStringMap(dp.Dimensions)
+
+		// Copies and renames take their values from the dimensions as they
+		// were before any of them were done, so that chained ones (e.g.
+		// renaming a to b and b to c) don't depend on map iteration order.
+		for to, from := range t.copyDimensions {
+			if v, ok := dp.Dimensions[from]; ok {
+				dims[to] = v
+			}
+		}
+
+		renamed := make(map[string]string, len(t.renameDimensionKeys))
+		for from, to := range t.renameDimensionKeys {
+			if v, ok := dims[from]; ok {
+				renamed[to] = v
+			}
+		}
+		for from := range t.renameDimensionKeys {
+			delete(dims, from)
+		}
+		for to, v := range renamed {
+			dims[to] = v
+		}
+
+		for _, r := range t.replaceValues {
+			if v, ok := dims[r.Dimension]; ok {
+				dims[r.Dimension] = r.Pattern.ReplaceAllString(v, r.Replacement)
+			}
+		}
+
+		for _, key := range t.dropDimensions {
+			delete(dims, key)
+		}
+
+		dp.Dimensions = dims
+	}
+
+	if t.renameMetric != "" {
+		dp.Metric = t.newMetricName(dp.Metric)
+	}
+}
+
+func (t *Transform) changesDimensions() bool {
+	return len(t.copyDimensions) > 0 || len(t.renameDimensionKeys) > 0 ||
+		len(t.replaceValues) > 0 || len(t.dropDimensions) > 0
+}
+
+func (t *Transform) newMetricName(metric string) string {
+	for _, re := range t.metricRegexps {
+		if match := re.FindStringSubmatchIndex(metric); match != nil {
+			return string(re.ExpandString(nil, t.renameMetric, metric, match))
+		}
+	}
+	return t.renameMetric
+}
@@ -0,0 +1,108 @@
+package dptransforms
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/signalfx/golib/datapoint"
+	"github.com/signalfx/signalfx-agent/internal/core/common/dpmeta"
+	"github.com/stretchr/testify/assert"
+)
+
+func newDP(metric string, dims map[string]string, monitorType string) *datapoint.Datapoint {
+	return &datapoint.Datapoint{
+		Metric:     metric,
+		Dimensions: dims,
+		Meta:       map[interface{}]interface{}{dpmeta.MonitorTypeMeta: monitorType},
+	}
+}
+
+func TestTransforms(t *testing.T) {
+	t.Run("Renames metric with capture groups", func(t *testing.T) {
+		tr, err := New("", []string{`/^jvm\.(?P<rest>.*)$/`}, nil, "java.${rest}", nil, nil, nil, nil)
+		assert.Nil(t, err)
+
+		dp := newDP("jvm.heap.used", nil, "collectd/genericjmx")
+		tr.Apply(dp)
+		assert.Equal(t, "java.heap.used", dp.Metric)
+
+		dp = newDP("cpu.utilization", nil, "cpu")
+		tr.Apply(dp)
+		assert.Equal(t, "cpu.utilization", dp.Metric)
+	})
+
+	t.Run("Renames metric literally", func(t *testing.T) {
+		tr, err := New("", []string{"cpu.*"}, nil, "cpu", nil, nil, nil, nil)
+		assert.Nil(t, err)
+
+		dp := newDP("cpu.utilization", nil, "cpu")
+		tr.Apply(dp)
+		assert.Equal(t, "cpu", dp.Metric)
+	})
+
+	t.Run("Respects monitor type and dimension scope", func(t *testing.T) {
+		tr, err := New("telegraf/procstat", nil, map[string]string{"env": "prod"}, "", nil, nil, []string{"pid"}, nil)
+		assert.Nil(t, err)
+
+		dp := newDP("procstat.cpu", map[string]string{"env": "prod", "pid": "1"}, "telegraf/procstat")
+		tr.Apply(dp)
+		assert.Equal(t, map[string]string{"env": "prod"}, dp.Dimensions)
+
+		dp = newDP("procstat.cpu", map[string]string{"env": "dev", "pid": "1"}, "telegraf/procstat")
+		tr.Apply(dp)
+		assert.Equal(t, map[string]string{"env": "dev", "pid": "1"}, dp.Dimensions)
+
+		dp = newDP("procstat.cpu", map[string]string{"env": "prod", "pid": "1"}, "collectd/processes")
+		tr.Apply(dp)
+		assert.Equal(t, map[string]string{"env": "prod", "pid": "1"}, dp.Dimensions)
+	})
+
+	t.Run("Changes dimensions in order without mutating the original map", func(t *testing.T) {
+		tr, err := New("", nil, nil, "",
+			map[string]string{"plugin_instance": "container"},
+			[]DimensionValueReplacement{{
+				Dimension:   "container",
+				Pattern:     regexp.MustCompile(`^k8s_([^_]+)_.*$`),
+				Replacement: "$1",
+			}},
+			[]string{"plugin"},
+			map[string]string{"container_id": "plugin_instance"})
+		assert.Nil(t, err)
+
+		orig := map[string]string{"plugin": "docker", "plugin_instance": "k8s_nginx_abc123"}
+		dp := newDP("cpu.usage", orig, "collectd/docker")
+		tr.Apply(dp)
+
+		assert.Equal(t, map[string]string{
+			"container":    "nginx",
+			"container_id": "k8s_nginx_abc123",
+		}, dp.Dimensions)
+		assert.Equal(t, map[string]string{"plugin": "docker", "plugin_instance": "k8s_nginx_abc123"}, orig)
+	})
+	t.Run("Copies and renames from the original dimensions", func(t *testing.T) {
+		tr, err := New("", nil, nil, "",
+			map[string]string{"a": "b", "b": "c"},
+			nil, nil,
+			map[string]string{"x": "y", "y": "z"})
+		assert.Nil(t, err)
+
+		// Run it a bunch of times since map iteration order is random
+		for i := 0; i < 50; i++ {
+			dp := newDP("cpu.usage", map[string]string{"a": "1", "b": "2", "y": "3", "z": "4"}, "cpu")
+			tr.Apply(dp)
+
+			assert.Equal(t, map[string]string{
+				"b": "1",
+				"c": "2",
+				"x": "3",
+				"y": "4",
+				"z": "4",
+			}, dp.Dimensions)
+		}
+	})
+
+	t.Run("Rejects renaming two keys to the same key", func(t *testing.T) {
+		_, err := New("", nil, nil, "", map[string]string{"a": "c", "b": "c"}, nil, nil, nil)
+		assert.NotNil(t, err)
+	})
+}
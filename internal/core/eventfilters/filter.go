@@ -0,0 +1,85 @@
+// Package eventfilters has logic describing the filtering of unwanted events.
+// Filters are configured from the agent configuration file, both globally and
+// per monitor.
+package eventfilters
+
+import (
+	"github.com/signalfx/com_signalfx_metrics_protobuf"
+	"github.com/signalfx/golib/event"
+	"github.com/signalfx/signalfx-agent/internal/core/common/dpmeta"
+	"github.com/signalfx/signalfx-agent/internal/utils/filter"
+)
+
+// EventFilter can be used to filter out events
+type EventFilter interface {
+	// Matches takes an event and returns whether it is matched by the filter
+	Matches(*event.Event) bool
+}
+
+// basicEventFilter filters events based on the monitor type, event type,
+// category and dimensions.  It supports static, globbed, and regex patterns
+// for filter values.  If dimensions are specified, they must all match for
+// the event to match.  If multiple event types or categories are given, only
+// one of each must match.
+type basicEventFilter struct {
+	monitorType     string
+	eventTypeFilter filter.StringFilter
+	categoryFilter  filter.StringFilter
+	dimFilter       filter.StringMapFilter
+	negated         bool
+}
+
+// New returns a new filter with the given configuration.  Categories are
+// matched against the names used by the SignalFx API, e.g. `USER_DEFINED` or
+// `AGENT`.
+func New(monitorType string, eventTypes []string, categories []string, dimensions map[string]string, negated bool) (EventFilter, error) {
+	f := &basicEventFilter{
+		monitorType: monitorType,
+		negated:     negated,
+	}
+
+	var err error
+	if len(eventTypes) > 0 {
+		if f.eventTypeFilter, err = filter.NewBasicStringFilter(eventTypes); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(categories) > 0 {
+		if f.categoryFilter, err = filter.NewBasicStringFilter(categories); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(dimensions) > 0 {
+		if f.dimFilter, err = filter.NewStringMapFilter(dimensions); err != nil {
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+// Matches tests an event to see whether it is matched by this filter.  In
+// order to match on monitor type, the event should have the "monitorType"
+// key set in its Properties field.
+func (f *basicEventFilter) Matches(ev *event.Event) bool {
+	if evMonitorType, ok := ev.Properties[dpmeta.MonitorTypeMeta].(string); ok {
+		if f.monitorType != "" && evMonitorType != f.monitorType {
+			return false
+		}
+	}
+
+	matched := (f.eventTypeFilter == nil || f.eventTypeFilter.Matches(ev.EventType)) &&
+		(f.categoryFilter == nil || f.categoryFilter.Matches(categoryName(ev.Category))) &&
+		(f.dimFilter == nil || f.dimFilter.Matches(ev.Dimensions))
+
+	if f.negated {
+		return !matched
+	}
+	return matched
+}
+
+func categoryName(c event.Category) string {
+	return com_signalfx_metrics_protobuf.EventCategory_name[int32(c)]
+}
@@ -0,0 +1,48 @@
+package eventfilters
+
+import (
+	"testing"
+	"time"
+
+	"github.com/signalfx/golib/event"
+	"github.com/signalfx/signalfx-agent/internal/core/common/dpmeta"
+	"github.com/stretchr/testify/assert"
+)
+
+func newEvent(eventType string, category event.Category, dims map[string]string, monitorType string) *event.Event {
+	return event.NewWithProperties(eventType, category, dims, map[string]interface{}{
+		dpmeta.MonitorTypeMeta: monitorType,
+	}, time.Now())
+}
+
+func TestFilters(t *testing.T) {
+	t.Run("Matches on event type, category and dimensions", func(t *testing.T) {
+		f, err := New("", []string{"Pulled", "Back*"}, []string{"USER_DEFINED"}, map[string]string{"kubernetes_namespace": "kube-*"}, false)
+		assert.Nil(t, err)
+
+		assert.True(t, f.Matches(newEvent("Pulled", event.USERDEFINED, map[string]string{"kubernetes_namespace": "kube-system"}, "")))
+		assert.True(t, f.Matches(newEvent("BackOff", event.USERDEFINED, map[string]string{"kubernetes_namespace": "kube-public"}, "")))
+		assert.False(t, f.Matches(newEvent("Killing", event.USERDEFINED, map[string]string{"kubernetes_namespace": "kube-system"}, "")))
+		assert.False(t, f.Matches(newEvent("Pulled", event.AGENT, map[string]string{"kubernetes_namespace": "kube-system"}, "")))
+		assert.False(t, f.Matches(newEvent("Pulled", event.USERDEFINED, map[string]string{"kubernetes_namespace": "default"}, "")))
+	})
+
+	t.Run("Scopes by monitor type and is never negated", func(t *testing.T) {
+		f, err := New("kubernetes-events", []string{"Pulled"}, nil, nil, true)
+		assert.Nil(t, err)
+
+		assert.True(t, f.Matches(newEvent("Killing", event.USERDEFINED, nil, "kubernetes-events")))
+		assert.False(t, f.Matches(newEvent("Pulled", event.USERDEFINED, nil, "kubernetes-events")))
+		assert.False(t, f.Matches(newEvent("Killing", event.USERDEFINED, nil, "collectd/cpu")))
+	})
+
+	t.Run("Includes override excludes", func(t *testing.T) {
+		ex, _ := New("", nil, []string{"COLLECTD"}, nil, false)
+		in, _ := New("", []string{"important"}, nil, nil, false)
+		fs := &FilterSet{ExcludeFilters: []EventFilter{ex}, IncludeFilters: []EventFilter{in}}
+
+		assert.True(t, fs.Matches(newEvent("notification", event.COLLECTD, nil, "")))
+		assert.False(t, fs.Matches(newEvent("important", event.COLLECTD, nil, "")))
+		assert.False(t, fs.Matches(newEvent("notification", event.AGENT, nil, "")))
+	})
+}
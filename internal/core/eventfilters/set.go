@@ -0,0 +1,29 @@
+package eventfilters
+
+import (
+	"github.com/signalfx/golib/event"
+)
+
+// FilterSet is a collection of event filters, any one of which must match for
+// an event to be matched.
+type FilterSet struct {
+	ExcludeFilters []EventFilter
+	IncludeFilters []EventFilter
+}
+
+// Matches sends an event through each of the exclude filters in the set and
+// returns true if at least one of them matches the event and none of the
+// include filters do.
+func (fs *FilterSet) Matches(ev *event.Event) bool {
+	for _, ex := range fs.ExcludeFilters {
+		if ex.Matches(ev) {
+			for _, incl := range fs.IncludeFilters {
+				if incl.Matches(ev) {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
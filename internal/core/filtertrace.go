@@ -0,0 +1,162 @@
+package core
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/signalfx/golib/datapoint"
+	"github.com/signalfx/signalfx-agent/internal/core/common/dpmeta"
+	"github.com/signalfx/signalfx-agent/internal/core/dpfilters"
+	"github.com/signalfx/signalfx-agent/internal/monitors/types"
+)
+
+// Serves an explanation of how the datapoint filters treat the datapoint
+// described by the query parameters `metric`, `value`, `monitorType`,
+// `monitorID` and `dimension` (repeated, each as `key=value`).  If no metric is given, the
+// match counts of all of the filters are shown instead.
+func (a *Agent) filterTraceHandler(rw http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	if query.Get("metric") == "" {
+		rw.Write([]byte(a.filterStatsText()))
+		return
+	}
+
+	dims := map[string]string{}
+	for _, d := range query["dimension"] {
+		parts := strings.SplitN(d, "=", 2)
+		if len(parts) != 2 {
+			rw.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(rw, "Dimension %q must be of the form key=value\n", d)
+			return
+		}
+		dims[parts[0]] = parts[1]
+	}
+
+	var value datapoint.Value
+	if v := query.Get("value"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(rw, "Value %q is not a number\n", v)
+			return
+		}
+		value = datapoint.NewFloatValue(f)
+	}
+
+	rw.Write([]byte(a.filterTraceText(query.Get("metric"), value, dims, query.Get("monitorType"), types.MonitorID(query.Get("monitorID")))))
+}
+
+func (a *Agent) filterTraceText(metric string, value datapoint.Value, dims map[string]string, monitorType string, monitorID types.MonitorID) string {
+	// Monitor filters are applied before the monitor type is attached to the
+	// datapoint, so they never match on it
+	dp := datapoint.New(metric, dims, value, datapoint.Gauge, time.Now())
+
+	out := fmt.Sprintf("Datapoint: %s %v\n", metric, dims)
+	if monitorType != "" {
+		out += fmt.Sprintf("Monitor Type: %s\n", monitorType)
+	}
+
+	monitorDecisions := a.monitors.ExplainDatapointFilters(dp, monitorType, monitorID)
+	if monitorType != "" {
+		out += "\nMonitor Filters:\n"
+		if len(monitorDecisions) == 0 {
+			out += "  No matching active monitors\n"
+		}
+		for _, md := range monitorDecisions {
+			out += fmt.Sprintf("  Monitor %s: %s\n", md.MonitorID, md.Decision)
+		}
+	}
+
+	dp.Meta = map[interface{}]interface{}{dpmeta.MonitorTypeMeta: monitorType}
+	global, destinations := a.writer.ExplainDatapointFilters(dp)
+	destNames := sortedDecisionKeys(destinations)
+
+	out += "\nWriter Filters:\n"
+	out += fmt.Sprintf("  Global: %s\n", global)
+	for _, name := range destNames {
+		out += fmt.Sprintf("  Destination %s: %s\n", name, destinations[name])
+	}
+
+	writerDecision := "SENT"
+	if global.Excluded {
+		writerDecision = "DROPPED by the global filters"
+	} else {
+		for _, name := range destNames {
+			if destinations[name].Excluded {
+				writerDecision += fmt.Sprintf(", except to destination %s", name)
+			}
+		}
+	}
+
+	out += "\nFinal Decision:\n"
+	if len(monitorDecisions) == 0 {
+		out += fmt.Sprintf("  %s\n", writerDecision)
+	}
+	for _, md := range monitorDecisions {
+		if md.Excluded {
+			out += fmt.Sprintf("  Monitor %s: DROPPED by the monitor's filters\n", md.MonitorID)
+		} else {
+			out += fmt.Sprintf("  Monitor %s: %s\n", md.MonitorID, writerDecision)
+		}
+	}
+	return out
+}
+
+func (a *Agent) filterStatsText() string {
+	out := "Datapoint Filter Match Counts\n=============================\n"
+
+	global, destinations := a.writer.DatapointFilterStats()
+	out += "\nGlobal:\n" + filterStatsLines(global)
+	for _, name := range sortedStatsKeys(destinations) {
+		out += fmt.Sprintf("\nDestination %s:\n", name) + filterStatsLines(destinations[name])
+	}
+
+	for _, ms := range a.monitors.DatapointFilterStats() {
+		var ids []string
+		for _, id := range ms.MonitorIDs {
+			ids = append(ids, string(id))
+		}
+		out += fmt.Sprintf("\nMonitor %s (%s):\n", strings.Join(ids, ", "), ms.MonitorType) + filterStatsLines(ms.Stats)
+	}
+	return out
+}
+
+func filterStatsLines(stats []dpfilters.FilterStat) string {
+	if len(stats) == 0 {
+		return "  No filters\n"
+	}
+	var out string
+	for _, s := range stats {
+		out += fmt.Sprintf("  %d matches: %s\n", s.Matches, s.Description)
+	}
+	return out
+}
+
+func sortedDecisionKeys(m map[string]dpfilters.Decision) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedStatsKeys(m map[string][]dpfilters.FilterStat) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// FilterTrace reads the filter trace of the datapoint described by the given
+// query from the diagnostic server of a running agent
+func FilterTrace(configPath string, query url.Values) ([]byte, error) {
+	return readStatusPath(configPath, "/filters?"+query.Encode())
+}
@@ -0,0 +1,388 @@
+package core
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/signalfx/signalfx-agent/internal/core/config"
+	"github.com/signalfx/signalfx-agent/internal/monitors"
+	"github.com/signalfx/signalfx-agent/internal/utils"
+	log "github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const managementAPIPrefix = "/api/v1/"
+
+// The most that will be read of a request body
+const maxManagementRequestSize = 1 << 20
+
+// adhocMonitors holds the monitor configs that were added through the
+// management API instead of the config file.  They are kept across config
+// reloads until they are removed or the agent restarts.
+type adhocMonitors struct {
+	sync.Mutex
+	nextID int
+	ids    []string
+	confs  map[string]config.MonitorConfig
+}
+
+func (am *adhocMonitors) add(conf config.MonitorConfig) string {
+	am.Lock()
+	defer am.Unlock()
+
+	am.nextID++
+	id := fmt.Sprintf("adhoc-%d", am.nextID)
+	am.ids = append(am.ids, id)
+	am.confs[id] = conf
+	return id
+}
+
+func (am *adhocMonitors) remove(id string) bool {
+	am.Lock()
+	defer am.Unlock()
+
+	if _, ok := am.confs[id]; !ok {
+		return false
+	}
+	delete(am.confs, id)
+	for i := range am.ids {
+		if am.ids[i] == id {
+			am.ids = append(am.ids[:i], am.ids[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// Returns the ids and copies of the configs in the order that they were added
+func (am *adhocMonitors) list() ([]string, []config.MonitorConfig) {
+	am.Lock()
+	defer am.Unlock()
+
+	ids := append([]string(nil), am.ids...)
+	confs := make([]config.MonitorConfig, len(ids))
+	for i := range ids {
+		confs[i] = am.confs[ids[i]]
+	}
+	return ids, confs
+}
+
+// Returns the monitor configs from the given agent config along with the
+// ad-hoc ones, with the default interval filled in so that their hashes
+// match those of the monitors created from them.  configLock must be held.
+func (a *Agent) allMonitorConfigs(conf *config.Config) ([]config.MonitorConfig, []string) {
+	adhocIDs, adhocConfs := a.adhocMonitors.list()
+
+	confs := make([]config.MonitorConfig, 0, len(conf.Monitors)+len(adhocConfs))
+	for i := range conf.Monitors {
+		conf.Monitors[i].IntervalSeconds = utils.FirstNonZero(conf.Monitors[i].IntervalSeconds, conf.IntervalSeconds)
+		confs = append(confs, conf.Monitors[i])
+	}
+	for i := range adhocConfs {
+		adhocConfs[i].IntervalSeconds = utils.FirstNonZero(adhocConfs[i].IntervalSeconds, conf.IntervalSeconds)
+		confs = append(confs, adhocConfs[i])
+	}
+	return confs, adhocIDs
+}
+
+// Updates the monitors after the ad-hoc monitors have changed
+func (a *Agent) reconfigureMonitors() {
+	a.configLock.Lock()
+	defer a.configLock.Unlock()
+
+	a.configureMonitors()
+}
+
+// Same as reconfigureMonitors but configLock must already be held
+func (a *Agent) configureMonitors() {
+	confs, _ := a.allMonitorConfigs(a.lastConfig)
+	a.monitors.Configure(confs, &a.lastConfig.Collectd, a.lastConfig.IntervalSeconds)
+}
+
+// Returns the current agent config along with all of the monitor configs and
+// the ids of the ad-hoc ones, which are at the end
+func (a *Agent) currentMonitorConfigs() (*config.Config, []config.MonitorConfig, []string) {
+	a.configLock.Lock()
+	defer a.configLock.Unlock()
+
+	confs, adhocIDs := a.allMonitorConfigs(a.lastConfig)
+	return a.lastConfig, confs, adhocIDs
+}
+
+func (a *Agent) registerManagementAPI(mux *http.ServeMux, conf config.ManagementAPIConfig) {
+	handle := func(path string, handler http.HandlerFunc) {
+		mux.Handle(managementAPIPrefix+path, requireToken(conf.Token, handler))
+	}
+
+	handle("monitors", a.monitorsHandler)
+	handle("adhoc-monitors", a.adhocMonitorsHandler)
+	handle("adhoc-monitors/", a.adhocMonitorHandler)
+	handle("reload", a.reloadHandler)
+}
+
+// Only passes requests through to the handler that have the token as a bearer
+// token
+func requireToken(token string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		auth := req.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) != 1 {
+			rw.Header().Set("WWW-Authenticate", `Bearer realm="signalfx-agent"`)
+			writeAPIError(rw, http.StatusUnauthorized, "a valid bearer token is required")
+			return
+		}
+		handler.ServeHTTP(rw, req)
+	})
+}
+
+func writeAPIResponse(rw http.ResponseWriter, status int, body interface{}) {
+	jsonOut, err := json.Marshal(body)
+	if err != nil {
+		log.WithError(err).Error("Could not serialize management API response to JSON")
+		rw.WriteHeader(500)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	rw.Write(jsonOut)
+}
+
+func writeAPIError(rw http.ResponseWriter, status int, msg string) {
+	writeAPIResponse(rw, status, map[string]string{"error": msg})
+}
+
+// Returns false and responds with an error if the request method isn't one of
+// the allowed ones
+func allowMethods(rw http.ResponseWriter, req *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if req.Method == m {
+			return true
+		}
+	}
+	rw.Header().Set("Allow", strings.Join(methods, ", "))
+	writeAPIError(rw, http.StatusMethodNotAllowed, "method not allowed")
+	return false
+}
+
+// activeMonitorResponse is the management API representation of a running
+// monitor instance
+type activeMonitorResponse struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	// The id of the ad-hoc monitor config that the monitor was created from,
+	// if it wasn't created from the config file
+	AdhocID    string      `json:"adhocID,omitempty"`
+	EndpointID string      `json:"endpointID,omitempty"`
+	Config     interface{} `json:"config"`
+}
+
+func (a *Agent) monitorsHandler(rw http.ResponseWriter, req *http.Request) {
+	if !allowMethods(rw, req, http.MethodGet) {
+		return
+	}
+
+	adhocIDsByHash := make(map[uint64]string)
+	_, confs, adhocIDs := a.currentMonitorConfigs()
+	for i, id := range adhocIDs {
+		adhocIDsByHash[confs[len(confs)-len(adhocIDs)+i].Hash()] = id
+	}
+
+	out := []activeMonitorResponse{}
+	for _, am := range a.monitors.ActiveMonitors() {
+		rendered, err := renderConfigForAPI(am.Config)
+		if err != nil {
+			log.WithError(err).Errorf("Could not render config of monitor %s", am.ID)
+		}
+
+		out = append(out, activeMonitorResponse{
+			ID:         string(am.ID),
+			Type:       am.Config.MonitorConfigCore().Type,
+			AdhocID:    adhocIDsByHash[am.ConfigHash],
+			EndpointID: string(am.EndpointID),
+			Config:     rendered,
+		})
+	}
+
+	writeAPIResponse(rw, http.StatusOK, out)
+}
+
+// adhocMonitorResponse is the management API representation of an ad-hoc
+// monitor config
+type adhocMonitorResponse struct {
+	ID     string      `json:"id"`
+	Config interface{} `json:"config"`
+}
+
+func (a *Agent) adhocMonitorsHandler(rw http.ResponseWriter, req *http.Request) {
+	if !allowMethods(rw, req, http.MethodGet, http.MethodPost) {
+		return
+	}
+
+	if req.Method == http.MethodPost {
+		a.addAdhocMonitor(rw, req)
+		return
+	}
+
+	ids, confs := a.adhocMonitors.list()
+	out := []adhocMonitorResponse{}
+	for i := range ids {
+		// The generic config doesn't know about the type-specific fields, so
+		// render the decoded config instead
+		var conf interface{} = &confs[i]
+		if mc, err := monitors.DecodeConfig(&confs[i]); err == nil {
+			conf = mc
+		}
+
+		rendered, err := renderConfigForAPI(conf)
+		if err != nil {
+			log.WithError(err).Errorf("Could not render config of ad-hoc monitor %s", ids[i])
+		}
+		out = append(out, adhocMonitorResponse{ID: ids[i], Config: rendered})
+	}
+
+	writeAPIResponse(rw, http.StatusOK, out)
+}
+
+func (a *Agent) addAdhocMonitor(rw http.ResponseWriter, req *http.Request) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(rw, req.Body, maxManagementRequestSize))
+	if err != nil {
+		writeAPIError(rw, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	id, monitorType, status, err := a.addAdhocMonitorConfig(body)
+	if err != nil {
+		writeAPIError(rw, status, err.Error())
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"monitorType": monitorType,
+		"adhocID":     id,
+	}).Info("Added ad-hoc monitor through management API")
+
+	writeAPIResponse(rw, http.StatusCreated, map[string]string{"id": id})
+}
+
+// Validates the monitor config in the body and adds it as an ad-hoc monitor.
+// configLock is held the whole time so that the config is validated against
+// the exact set of monitors that it is added to, otherwise two identical
+// requests at the same time could both be accepted.  If the config is
+// rejected, the returned status says why.
+func (a *Agent) addAdhocMonitorConfig(body []byte) (id string, monitorType string, status int, err error) {
+	a.configLock.Lock()
+	defer a.configLock.Unlock()
+
+	agentConf := a.lastConfig
+	confs, _ := a.allMonitorConfigs(agentConf)
+	monConf, err := agentConf.DecodeMonitorConfig(body)
+	if err != nil {
+		return "", "", http.StatusBadRequest, err
+	}
+
+	// Validate the new config alongside the existing ones so that single
+	// instance monitors are caught
+	confs = append(confs, *monConf)
+	if err := monitors.ValidateConfigs(confs, agentConf.IntervalSeconds)[len(confs)-1]; err != nil {
+		return "", "", http.StatusBadRequest, err
+	}
+
+	hash := confs[len(confs)-1].Hash()
+	for i := range confs[:len(confs)-1] {
+		if confs[i].Hash() == hash {
+			return "", "", http.StatusConflict, errors.New("an identical monitor is already configured")
+		}
+	}
+
+	id = a.adhocMonitors.add(*monConf)
+	a.configureMonitors()
+	return id, monConf.Type, http.StatusCreated, nil
+}
+
+func (a *Agent) adhocMonitorHandler(rw http.ResponseWriter, req *http.Request) {
+	if !allowMethods(rw, req, http.MethodDelete) {
+		return
+	}
+
+	id := strings.TrimPrefix(req.URL.Path, managementAPIPrefix+"adhoc-monitors/")
+	if !a.adhocMonitors.remove(id) {
+		writeAPIError(rw, http.StatusNotFound, fmt.Sprintf("no ad-hoc monitor with id %s", id))
+		return
+	}
+	a.reconfigureMonitors()
+
+	log.WithField("adhocID", id).Info("Removed ad-hoc monitor through management API")
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// Reloads the config file and its dynamic values in the background, since
+// getting remote values can take longer than the diagnostic server's write
+// timeout.  The agent is reconfigured once the config has been loaded
+// successfully.
+func (a *Agent) reloadHandler(rw http.ResponseWriter, req *http.Request) {
+	if !allowMethods(rw, req, http.MethodPost) {
+		return
+	}
+
+	log.Info("Config reload requested through management API")
+	go a.reloadConfig()
+
+	writeAPIResponse(rw, http.StatusAccepted, map[string]string{})
+}
+
+func (a *Agent) reloadConfig() {
+	conf, _, err := config.LoadConfigOnce(a.configPath, false)
+	if err != nil {
+		log.WithError(err).Error("Could not reload config requested through management API, keeping the current config")
+		return
+	}
+
+	a.reloadLock.Lock()
+	defer a.reloadLock.Unlock()
+
+	// Only the latest reload matters if several are requested before the
+	// agent gets to them
+	select {
+	case <-a.reloads:
+	default:
+	}
+	a.reloads <- conf
+}
+
+// Renders the config with sensitive values redacted and converts it to a form
+// that can be serialized as JSON
+func renderConfigForAPI(conf interface{}) (interface{}, error) {
+	var out interface{}
+	if err := yaml.Unmarshal([]byte((&config.YAMLRenderer{}).Render(conf)), &out); err != nil {
+		return nil, err
+	}
+	return jsonCompatible(out), nil
+}
+
+// Converts the maps with interface keys that the YAML decoder produces to maps
+// with string keys
+func jsonCompatible(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(val))
+		for k, child := range val {
+			out[fmt.Sprint(k)] = jsonCompatible(child)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i := range val {
+			out[i] = jsonCompatible(val[i])
+		}
+		return out
+	}
+	return v
+}
@@ -0,0 +1,266 @@
+package core
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/signalfx/signalfx-agent/internal/core/config"
+	"github.com/signalfx/signalfx-agent/internal/monitors"
+	"github.com/stretchr/testify/assert"
+)
+
+type testMonitorConfig struct {
+	config.MonitorConfig
+	Host     string `yaml:"host" validate:"required"`
+	Password string `yaml:"password" neverLog:"true"`
+}
+
+type testMonitor struct{}
+
+func (m *testMonitor) Configure(conf *testMonitorConfig) error {
+	return nil
+}
+
+func (m *testMonitor) Shutdown() {}
+
+func init() {
+	monitors.Register("management-test", func() interface{} { return &testMonitor{} }, &testMonitorConfig{})
+}
+
+func newTestManagementServer(t *testing.T) (*Agent, *httptest.Server) {
+	agent := NewAgent()
+	agent.lastConfig = &config.Config{
+		IntervalSeconds: 10,
+		Collectd:        config.CollectdConfig{DisableCollectd: true},
+		Monitors: []config.MonitorConfig{
+			{Type: "management-test", OtherConfig: map[string]interface{}{"host": "from-file"}},
+		},
+	}
+	agent.reconfigureMonitors()
+
+	mux := http.NewServeMux()
+	agent.registerManagementAPI(mux, config.ManagementAPIConfig{Enabled: true, Token: "s3cr3t"})
+	return agent, httptest.NewServer(mux)
+}
+
+func doRequest(t *testing.T, method, url, token, body string) (int, string) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	assert.Nil(t, err)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+
+	out, err := ioutil.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	return resp.StatusCode, string(out)
+}
+
+func listMonitors(t *testing.T, url string) []activeMonitorResponse {
+	status, body := doRequest(t, "GET", url+"/api/v1/monitors", "s3cr3t", "")
+	assert.Equal(t, http.StatusOK, status)
+
+	var out []activeMonitorResponse
+	assert.Nil(t, json.Unmarshal([]byte(body), &out))
+	return out
+}
+
+func TestManagementAPIRequiresToken(t *testing.T) {
+	agent, server := newTestManagementServer(t)
+	defer server.Close()
+	defer agent.monitors.Shutdown()
+
+	for _, token := range []string{"", "wrong"} {
+		status, _ := doRequest(t, "GET", server.URL+"/api/v1/monitors", token, "")
+		assert.Equal(t, http.StatusUnauthorized, status)
+	}
+
+	// The token has to be given as a bearer token
+	for _, auth := range []string{"s3cr3t", "Basic s3cr3t", "Bearer", "Bearers3cr3t"} {
+		req, err := http.NewRequest("GET", server.URL+"/api/v1/monitors", nil)
+		assert.Nil(t, err)
+		req.Header.Set("Authorization", auth)
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, auth)
+	}
+}
+
+func TestManagementAPIAdhocMonitors(t *testing.T) {
+	agent, server := newTestManagementServer(t)
+	defer server.Close()
+	defer agent.monitors.Shutdown()
+
+	mons := listMonitors(t, server.URL)
+	assert.Len(t, mons, 1)
+	assert.Equal(t, "", mons[0].AdhocID)
+
+	status, body := doRequest(t, "POST", server.URL+"/api/v1/adhoc-monitors", "s3cr3t",
+		`{"type": "management-test", "host": "adhoc", "password": "hunter2"}`)
+	assert.Equal(t, http.StatusCreated, status)
+	assert.JSONEq(t, `{"id": "adhoc-1"}`, body)
+
+	mons = listMonitors(t, server.URL)
+	assert.Len(t, mons, 2)
+	for _, m := range mons {
+		if m.AdhocID == "adhoc-1" {
+			conf := m.Config.(map[string]interface{})
+			assert.Equal(t, "adhoc", conf["host"])
+			assert.NotContains(t, conf["password"], "hunter2")
+		}
+	}
+
+	t.Run("Rejects invalid configs", func(t *testing.T) {
+		status, _ := doRequest(t, "POST", server.URL+"/api/v1/adhoc-monitors", "s3cr3t", `{"type": "management-test"}`)
+		assert.Equal(t, http.StatusBadRequest, status)
+
+		status, _ = doRequest(t, "POST", server.URL+"/api/v1/adhoc-monitors", "s3cr3t", `{"type": "not-a-monitor"}`)
+		assert.Equal(t, http.StatusBadRequest, status)
+	})
+
+	t.Run("Rejects duplicate configs", func(t *testing.T) {
+		status, _ := doRequest(t, "POST", server.URL+"/api/v1/adhoc-monitors", "s3cr3t",
+			`{"type": "management-test", "host": "from-file"}`)
+		assert.Equal(t, http.StatusConflict, status)
+	})
+
+	t.Run("Only accepts one of several identical configs sent at once", func(t *testing.T) {
+		statuses := make(chan int, 5)
+		var wg sync.WaitGroup
+		for i := 0; i < cap(statuses); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				status, _ := doRequest(t, "POST", server.URL+"/api/v1/adhoc-monitors", "s3cr3t",
+					`{"type": "management-test", "host": "concurrent"}`)
+				statuses <- status
+			}()
+		}
+		wg.Wait()
+		close(statuses)
+
+		counts := map[int]int{}
+		for status := range statuses {
+			counts[status]++
+		}
+		assert.Equal(t, map[int]int{http.StatusCreated: 1, http.StatusConflict: 4}, counts)
+
+		status, _ := doRequest(t, "DELETE", server.URL+"/api/v1/adhoc-monitors/adhoc-2", "s3cr3t", "")
+		assert.Equal(t, http.StatusNoContent, status)
+	})
+
+	status, _ = doRequest(t, "DELETE", server.URL+"/api/v1/adhoc-monitors/adhoc-1", "s3cr3t", "")
+	assert.Equal(t, http.StatusNoContent, status)
+	assert.Len(t, listMonitors(t, server.URL), 1)
+
+	status, _ = doRequest(t, "DELETE", server.URL+"/api/v1/adhoc-monitors/adhoc-1", "s3cr3t", "")
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+// Serves a single ConfigMap like the K8s API server does and counts the
+// watches of it that are started and still open
+func newFakeK8sAPIServer() (*httptest.Server, *int64, *int64, func()) {
+	var watchesStarted, openWatches int64
+	done := make(chan struct{})
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		if req.URL.Query().Get("watch") == "true" || strings.Contains(req.URL.Path, "/watch/") {
+			atomic.AddInt64(&watchesStarted, 1)
+			atomic.AddInt64(&openWatches, 1)
+			defer atomic.AddInt64(&openWatches, -1)
+
+			rw.WriteHeader(http.StatusOK)
+			rw.(http.Flusher).Flush()
+			select {
+			case <-req.Context().Done():
+			case <-done:
+			}
+			return
+		}
+		rw.Write([]byte(`{"kind": "ConfigMapList", "apiVersion": "v1", "metadata": {"resourceVersion": "1"}, "items": [
+			{"metadata": {"name": "agent", "namespace": "monitoring", "resourceVersion": "1"}, "data": {"host": "from-k8s"}}]}`))
+	}))
+
+	return server, &watchesStarted, &openWatches, func() {
+		close(done)
+		server.Close()
+	}
+}
+
+func TestManagementAPIReloadStopsConfigSources(t *testing.T) {
+	k8sServer, watchesStarted, openWatches, closeK8s := newFakeK8sAPIServer()
+	defer closeK8s()
+
+	k8sURL, err := url.Parse(k8sServer.URL)
+	assert.Nil(t, err)
+	host, port, err := net.SplitHostPort(k8sURL.Host)
+	assert.Nil(t, err)
+	os.Setenv("KUBERNETES_SERVICE_HOST", host)
+	os.Setenv("KUBERNETES_SERVICE_PORT", port)
+	defer os.Unsetenv("KUBERNETES_SERVICE_HOST")
+	defer os.Unsetenv("KUBERNETES_SERVICE_PORT")
+
+	dir, err := ioutil.TempDir("", "management")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	configPath := filepath.Join(dir, "agent.yaml")
+	assert.Nil(t, ioutil.WriteFile(configPath, []byte(`
+configSources:
+  kubernetes:
+    kubernetesAPI:
+      authType: none
+      skipVerify: true
+monitors:
+- type: management-test
+  host: {"#from": "k8s:monitoring/agent/host"}
+`), 0600))
+
+	agent, server := newTestManagementServer(t)
+	defer server.Close()
+	defer agent.monitors.Shutdown()
+	agent.configPath = configPath
+
+	status, body := doRequest(t, "POST", server.URL+"/api/v1/reload", "s3cr3t", "")
+	assert.Equal(t, http.StatusAccepted, status, body)
+
+	// The config is loaded after responding
+	select {
+	case conf := <-agent.reloads:
+		assert.Equal(t, "from-k8s", conf.Monitors[0].OtherConfig["host"])
+	case <-time.After(10 * time.Second):
+		t.Fatal("Reloaded config was not queued")
+	}
+
+	// The source starts watching the ConfigMap right after reading it, so
+	// give it a chance to, unless it was stopped before it got to it
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt64(watchesStarted) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	deadline = time.Now().Add(5 * time.Second)
+	for atomic.LoadInt64(openWatches) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("The K8s config source was not stopped after the reload")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
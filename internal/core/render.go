@@ -0,0 +1,60 @@
+package core
+
+import (
+	"github.com/signalfx/signalfx-agent/internal/core/config"
+	"github.com/signalfx/signalfx-agent/internal/core/config/sources"
+	"github.com/signalfx/signalfx-agent/internal/monitors"
+	"github.com/signalfx/signalfx-agent/internal/observers"
+	"github.com/signalfx/signalfx-agent/internal/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// RenderConfig loads the config at the given path, with all dynamic values
+// resolved and defaults filled in, and returns it as YAML with any sensitive
+// values redacted.  If annotate is true, values that came from dynamic values
+// are annotated with their source.  If noRemote is true, remote config
+// sources are not contacted and values from them are treated as empty unless
+// they have a default.
+func RenderConfig(configPath string, noRemote bool, annotate bool) (string, error) {
+	conf, valueSources, err := config.LoadConfigOnce(configPath, noRemote)
+	if err != nil {
+		return "", err
+	}
+
+	// The generic observer and monitor configs don't know about the
+	// type-specific fields, so render the decoded configs instead
+	var observerConfigs []interface{}
+	for i := range conf.Observers {
+		oc, err := observers.DecodeConfig(&conf.Observers[i])
+		if err != nil {
+			log.WithError(err).Warnf("Could not decode observer config #%d, only showing common fields", i)
+			oc = &conf.Observers[i]
+		}
+		observerConfigs = append(observerConfigs, oc)
+	}
+
+	var monitorConfigs []interface{}
+	for i := range conf.Monitors {
+		conf.Monitors[i].IntervalSeconds = utils.FirstNonZero(conf.Monitors[i].IntervalSeconds, conf.IntervalSeconds)
+
+		mc, err := monitors.DecodeConfig(&conf.Monitors[i])
+		if err != nil {
+			log.WithError(err).Warnf("Could not decode monitor config #%d, only showing common fields", i)
+			monitorConfigs = append(monitorConfigs, &conf.Monitors[i])
+			continue
+		}
+		monitorConfigs = append(monitorConfigs, mc)
+	}
+
+	renderer := &config.YAMLRenderer{
+		Replacements: map[string]interface{}{
+			sources.ValuePath("", "observers"): observerConfigs,
+			sources.ValuePath("", "monitors"):  monitorConfigs,
+		},
+	}
+	if annotate {
+		renderer.ValueSources = valueSources
+	}
+
+	return renderer.Render(conf), nil
+}
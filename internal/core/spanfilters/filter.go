@@ -0,0 +1,75 @@
+// Package spanfilters has logic describing the filtering of unwanted trace
+// spans.  Filters are configured from the agent configuration file, both
+// globally and per monitor.
+package spanfilters
+
+import (
+	"github.com/signalfx/golib/trace"
+	"github.com/signalfx/signalfx-agent/internal/utils/filter"
+)
+
+// SpanFilter can be used to filter out trace spans
+type SpanFilter interface {
+	// Matches takes a span and returns whether it is matched by the filter
+	Matches(*trace.Span) bool
+}
+
+// basicSpanFilter filters spans based on the service name of their local
+// endpoint, their operation name and their tags.  It supports static,
+// globbed, and regex patterns for filter values.  If tags are specified, they
+// must all match for the span to match.  If multiple service or operation
+// names are given, only one of each must match.
+type basicSpanFilter struct {
+	serviceFilter   filter.StringFilter
+	operationFilter filter.StringFilter
+	tagFilter       filter.StringMapFilter
+	negated         bool
+}
+
+// New returns a new filter with the given configuration
+func New(serviceNames []string, operationNames []string, tags map[string]string, negated bool) (SpanFilter, error) {
+	f := &basicSpanFilter{
+		negated: negated,
+	}
+
+	var err error
+	if len(serviceNames) > 0 {
+		if f.serviceFilter, err = filter.NewBasicStringFilter(serviceNames); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(operationNames) > 0 {
+		if f.operationFilter, err = filter.NewBasicStringFilter(operationNames); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(tags) > 0 {
+		if f.tagFilter, err = filter.NewStringMapFilter(tags); err != nil {
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+// Matches tests a span to see whether it is matched by this filter
+func (f *basicSpanFilter) Matches(span *trace.Span) bool {
+	var serviceName, operationName string
+	if span.LocalEndpoint != nil && span.LocalEndpoint.ServiceName != nil {
+		serviceName = *span.LocalEndpoint.ServiceName
+	}
+	if span.Name != nil {
+		operationName = *span.Name
+	}
+
+	matched := (f.serviceFilter == nil || f.serviceFilter.Matches(serviceName)) &&
+		(f.operationFilter == nil || f.operationFilter.Matches(operationName)) &&
+		(f.tagFilter == nil || f.tagFilter.Matches(span.Tags))
+
+	if f.negated {
+		return !matched
+	}
+	return matched
+}
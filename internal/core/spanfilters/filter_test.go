@@ -0,0 +1,38 @@
+package spanfilters
+
+import (
+	"testing"
+
+	"github.com/signalfx/golib/pointer"
+	"github.com/signalfx/golib/trace"
+	"github.com/stretchr/testify/assert"
+)
+
+func newSpan(service, operation string, tags map[string]string) *trace.Span {
+	return &trace.Span{
+		Name:          pointer.String(operation),
+		LocalEndpoint: &trace.Endpoint{ServiceName: pointer.String(service)},
+		Tags:          tags,
+	}
+}
+
+func TestFilters(t *testing.T) {
+	t.Run("Matches on service, operation and tags", func(t *testing.T) {
+		f, err := New([]string{"api"}, []string{"/health*"}, map[string]string{"http.method": "GET"}, false)
+		assert.Nil(t, err)
+		fs := &FilterSet{ExcludeFilters: []SpanFilter{f}}
+
+		assert.True(t, fs.Matches(newSpan("api", "/healthz", map[string]string{"http.method": "GET"})))
+		assert.False(t, fs.Matches(newSpan("api", "/healthz", map[string]string{"http.method": "POST"})))
+		assert.False(t, fs.Matches(newSpan("api", "/users", map[string]string{"http.method": "GET"})))
+		assert.False(t, fs.Matches(newSpan("web", "/healthz", map[string]string{"http.method": "GET"})))
+	})
+
+	t.Run("Handles spans without names", func(t *testing.T) {
+		f, err := New([]string{"api"}, nil, nil, true)
+		assert.Nil(t, err)
+
+		assert.True(t, f.Matches(&trace.Span{}))
+		assert.False(t, f.Matches(newSpan("api", "", nil)))
+	})
+}
@@ -0,0 +1,22 @@
+package spanfilters
+
+import (
+	"github.com/signalfx/golib/trace"
+)
+
+// FilterSet is a collection of span filters, any one of which must match for
+// a span to be matched.
+type FilterSet struct {
+	ExcludeFilters []SpanFilter
+}
+
+// Matches sends a span through each of the filters in the set and returns
+// true if at least one of them matches the span.
+func (fs *FilterSet) Matches(span *trace.Span) bool {
+	for _, ex := range fs.ExcludeFilters {
+		if ex.Matches(span) {
+			return true
+		}
+	}
+	return false
+}
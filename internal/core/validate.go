@@ -0,0 +1,36 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/signalfx/signalfx-agent/internal/core/config"
+	"github.com/signalfx/signalfx-agent/internal/monitors"
+)
+
+// ValidateConfig loads the config at the given path and checks it the same
+// way that the agent would when starting up, without starting anything.  All
+// of the problems that are found are returned, so an empty result means the
+// config is valid.  If noRemote is true, remote config sources are not
+// contacted and values from them are treated as empty unless they have a
+// default.
+func ValidateConfig(configPath string, noRemote bool) []error {
+	conf, _, err := config.LoadConfigOnce(configPath, noRemote)
+	if err != nil {
+		return []error{err}
+	}
+
+	monitorErrs := monitors.ValidateConfigs(conf.Monitors, conf.IntervalSeconds)
+
+	indexes := make([]int, 0, len(monitorErrs))
+	for i := range monitorErrs {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	var errs []error
+	for _, i := range indexes {
+		errs = append(errs, fmt.Errorf("monitor #%d (%s): %v", i, conf.Monitors[i].Type, monitorErrs[i]))
+	}
+	return errs
+}
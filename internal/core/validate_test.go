@@ -0,0 +1,78 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/signalfx/signalfx-agent/internal/core/config"
+	"github.com/signalfx/signalfx-agent/internal/monitors"
+	"github.com/stretchr/testify/assert"
+)
+
+type validateTestMonitorConfig struct {
+	config.MonitorConfig
+	Host string `yaml:"host" validate:"required"`
+}
+
+type validateTestMonitor struct{}
+
+func (m *validateTestMonitor) Configure(conf *validateTestMonitorConfig) error {
+	return nil
+}
+
+func (m *validateTestMonitor) Shutdown() {}
+
+func init() {
+	monitors.Register("validate-test", func() interface{} { return &validateTestMonitor{} }, &validateTestMonitorConfig{})
+}
+
+func writeTestConfig(t *testing.T, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "validate")
+	assert.Nil(t, err)
+
+	path := filepath.Join(dir, "agent.yaml")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(content), 0600))
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestValidateConfig(t *testing.T) {
+	t.Run("Valid config has no errors", func(t *testing.T) {
+		path, cleanup := writeTestConfig(t, `
+signalFxAccessToken: abcd
+monitors:
+  - type: validate-test
+    host: localhost
+`)
+		defer cleanup()
+
+		assert.Len(t, ValidateConfig(path, true), 0)
+	})
+
+	t.Run("Reports every bad monitor with its index and type", func(t *testing.T) {
+		path, cleanup := writeTestConfig(t, `
+signalFxAccessToken: abcd
+monitors:
+  - type: validate-test
+    host: localhost
+  - type: not-a-monitor
+  - type: validate-test
+  - type: validate-test
+    discoveryRule: container_image =~
+`)
+		defer cleanup()
+
+		errs := ValidateConfig(path, true)
+		if assert.Len(t, errs, 3) {
+			assert.Equal(t, "monitor #1 (not-a-monitor): Unknown monitor type not-a-monitor", errs[0].Error())
+			assert.Equal(t, "monitor #2 (validate-test): Validation error in field 'host': required", errs[1].Error())
+			assert.Equal(t, "monitor #3 (validate-test): monitor validate-test does not support discovery but has a discovery rule", errs[2].Error())
+		}
+	})
+
+	t.Run("Reports config files that can't be loaded", func(t *testing.T) {
+		errs := ValidateConfig("/does/not/exist.yaml", true)
+		assert.Len(t, errs, 1)
+	})
+}
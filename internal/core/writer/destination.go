@@ -0,0 +1,282 @@
+package writer
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/signalfx/golib/datapoint"
+	"github.com/signalfx/golib/event"
+	"github.com/signalfx/golib/sfxclient"
+	"github.com/signalfx/signalfx-agent/internal/core/config"
+	"github.com/signalfx/signalfx-agent/internal/core/dpfilters"
+	"github.com/signalfx/signalfx-agent/internal/core/writer/diskqueue"
+	"github.com/signalfx/signalfx-agent/internal/monitors/types"
+	log "github.com/sirupsen/logrus"
+)
+
+type datapointSink interface {
+	AddDatapoints(context.Context, []*datapoint.Datapoint) error
+}
+
+// destination is a single place that the writer sends data to.  The top-level
+// ingest/API URLs and access token make up the primary destination, which has
+// a blank name, and each of the configured `writer.destinations` is another.
+type destination struct {
+	name string
+	// The SignalFx client, nil for Prometheus remote-write destinations
+	client        *sfxclient.HTTPSink
+	dimPropClient *dimensionPropertyClient
+	// What datapoints are sent with, which is the SignalFx client unless this
+	// is a Prometheus remote-write destination.
+	dpSink datapointSink
+	// Filters specific to this destination.  Nil for the primary destination
+	// since the top-level filters are applied before data reaches any
+	// destination.
+	datapointFilters *dpfilters.FilterSet
+
+	sendDatapoints bool
+	sendEvents     bool
+	sendTraceSpans bool
+	sendDimProps   bool
+
+	// Holds datapoint and event batches that failed to send so that they can
+	// be resent later.  Nil if the disk buffer is not enabled.
+	diskBuffer    *diskqueue.Queue
+	replayTrigger chan struct{}
+
+	// Datapoints and trace spans are sent to each destination separately so
+	// that a slow or failing one doesn't hold up the others.  spanPipeline is
+	// nil for Prometheus remote-write destinations.
+	dpPipeline   *sendPipeline
+	spanPipeline *sendPipeline
+
+	dpsSent                int64
+	eventsSent             int64
+	traceSpansSent         int64
+	traceSpansFailedToSend int64
+}
+
+func newDestination(name string, conf *config.DestinationConfig, writerConf *config.WriterConfig,
+	transport http.RoundTripper) (*destination, error) {
+
+	if conf.Type == config.DestinationTypePrometheusRemoteWrite {
+		return newRemoteWriteDestination(name, conf, writerConf, transport)
+	}
+
+	logger := log.WithField("destination", name)
+
+	dimPropClient, err := newDimensionPropertyClient(writerConf, conf.ParsedAPIURL(), conf.SignalFxAccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	dest := &destination{
+		name:           name,
+		client:         sfxclient.NewHTTPSink(),
+		dimPropClient:  dimPropClient,
+		sendDatapoints: *conf.SendDatapoints,
+		sendEvents:     *conf.SendEvents,
+		sendTraceSpans: *conf.SendTraceSpans,
+		sendDimProps:   *conf.SendDimensionProperties,
+	}
+	dest.dpPipeline = newSendPipeline("datapoints", dest, writerConf.DatapointMaxRequests)
+	dest.spanPipeline = newSendPipeline("trace spans", dest, writerConf.MaxRequests)
+
+	dest.dpSink = dest.client
+	dest.client.AuthToken = conf.SignalFxAccessToken
+	dest.client.Client.Transport = transport
+
+	dpEndpointURL, err := conf.ParsedIngestURL().Parse("v2/datapoint")
+	if err != nil {
+		logger.WithFields(log.Fields{
+			"error":     err,
+			"ingestURL": conf.ParsedIngestURL().String(),
+		}).Error("Could not construct datapoint ingest URL")
+		return nil, err
+	}
+	dest.client.DatapointEndpoint = dpEndpointURL.String()
+
+	eventEndpointURL, err := conf.ParsedIngestURL().Parse("v2/event")
+	if err != nil {
+		logger.WithFields(log.Fields{
+			"error":     err,
+			"ingestURL": conf.ParsedIngestURL().String(),
+		}).Error("Could not construct event ingest URL")
+		return nil, err
+	}
+	dest.client.EventEndpoint = eventEndpointURL.String()
+
+	traceEndpointURL := conf.ParsedTraceEndpointURL()
+	if traceEndpointURL == nil {
+		var err error
+		traceEndpointURL, err = conf.ParsedIngestURL().Parse("v1/trace")
+		if err != nil {
+			logger.WithFields(log.Fields{
+				"error":     err,
+				"ingestURL": conf.ParsedIngestURL().String(),
+			}).Error("Could not construct trace ingest URL")
+			return nil, err
+		}
+	}
+	dest.client.TraceEndpoint = traceEndpointURL.String()
+
+	if err := dest.configureFiltersAndBuffer(conf, writerConf); err != nil {
+		return nil, err
+	}
+
+	return dest, nil
+}
+
+// Prometheus remote-write destinations only receive datapoints
+func newRemoteWriteDestination(name string, conf *config.DestinationConfig, writerConf *config.WriterConfig,
+	transport http.RoundTripper) (*destination, error) {
+
+	dest := &destination{
+		name:           name,
+		dpSink:         newRemoteWriteSink(conf.RemoteWriteURL, conf.RemoteWriteHeaders, conf.RemoteWriteTimeout, transport),
+		sendDatapoints: *conf.SendDatapoints,
+	}
+	dest.dpPipeline = newSendPipeline("datapoints", dest, writerConf.DatapointMaxRequests)
+
+	if err := dest.configureFiltersAndBuffer(conf, writerConf); err != nil {
+		return nil, err
+	}
+
+	return dest, nil
+}
+
+func (d *destination) configureFiltersAndBuffer(conf *config.DestinationConfig, writerConf *config.WriterConfig) error {
+	var err error
+	if d.name != "" {
+		d.datapointFilters, err = conf.DatapointFilters()
+		if err != nil {
+			return err
+		}
+	}
+
+	if writerConf.DiskBufferPath != "" {
+		path := writerConf.DiskBufferPath
+		if d.name != "" {
+			path = filepath.Join(path, "destinations", d.name)
+		}
+		d.diskBuffer, err = diskqueue.New(path, int64(writerConf.DiskBufferMaxSizeMB)*1024*1024, writerConf.DiskBufferMaxAge)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A human readable name of the destination for logs and diagnostics
+func (d *destination) String() string {
+	if d.name == "" {
+		return "SignalFx"
+	}
+	return "destination " + d.name
+}
+
+// Dimensions to put on internal metrics about the destination
+func (d *destination) metricDims() map[string]string {
+	if d.name == "" {
+		return nil
+	}
+	return map[string]string{"destination": d.name}
+}
+
+// Returns the subset of dps that should be sent to this destination.  The
+// given slice is not modified.
+func (d *destination) filterDatapoints(dps []*datapoint.Datapoint) []*datapoint.Datapoint {
+	if d.datapointFilters == nil {
+		return dps
+	}
+
+	var out []*datapoint.Datapoint
+	for i := range dps {
+		if !d.datapointFilters.Matches(dps[i]) {
+			out = append(out, dps[i])
+		}
+	}
+	return out
+}
+
+// Calls fn in parallel for each destination for which include returns true,
+// and waits for all of them to complete.
+func (sw *SignalFxWriter) forEachDestination(include func(*destination) bool, fn func(*destination)) {
+	var wg sync.WaitGroup
+	for i := range sw.destinations {
+		dest := sw.destinations[i]
+		if !include(dest) {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn(dest)
+		}()
+	}
+	wg.Wait()
+}
+
+// sendDatapointsToDestination sends dps to dest, retrying according to the
+// retry policy, unless the batch gets shed while waiting.  dps must have been
+// reserved in the destination's datapoint pipeline.
+func (sw *SignalFxWriter) sendDatapointsToDestination(dest *destination, dps []*datapoint.Datapoint) {
+	dest.dpPipeline.send(sw.ctx, len(dps), func(attempt int) (time.Duration, bool) {
+		// This sends synchonously
+		ctx, retryAfter := withRetryAfterCapture(context.Background())
+		err := dest.dpSink.AddDatapoints(ctx, dps)
+		if err == nil {
+			atomic.AddInt64(&dest.dpsSent, int64(len(dps)))
+			log.Debugf("Sent %d datapoints to %s", len(dps), dest)
+			sw.notifyIngestReachable(dest)
+			return 0, false
+		}
+
+		delay, ok := sw.retryPolicy.nextBackoff(attempt, err, retryAfter.after)
+		if ok {
+			log.WithFields(log.Fields{
+				"error":   err,
+				"attempt": attempt,
+				"backoff": delay,
+			}).Warnf("Failed to send %d datapoints to %s, retrying", len(dps), dest)
+			return delay, true
+		}
+
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Errorf("Error shipping datapoints to %s", dest)
+		// If there is an error sending datapoints then just forget about them
+		// unless the disk buffer is enabled.
+		sw.bufferFailedDatapoints(dest, dps, err)
+		return 0, false
+	})
+}
+
+func (sw *SignalFxWriter) sendEventsToDestination(dest *destination, events []*event.Event) error {
+	err := sw.sendWithRetries("events", len(events), func(ctx context.Context) error {
+		return dest.client.AddEvents(ctx, events)
+	})
+	if err != nil {
+		log.WithError(err).Errorf("Error shipping events to %s", dest)
+		sw.bufferFailedEvents(dest, events, err)
+		return err
+	}
+	atomic.AddInt64(&dest.eventsSent, int64(len(events)))
+	log.Debugf("Sent %d events to %s", len(events), dest)
+	sw.notifyIngestReachable(dest)
+
+	return nil
+}
+
+// Queues the dim props to be sent to each destination.  This does not block.
+func (sw *SignalFxWriter) sendDimPropsToDestinations(dimProps *types.DimProperties) {
+	for _, dest := range sw.destinations {
+		if dest.sendDimProps {
+			dest.dimPropClient.EnqueueProperties(dimProps)
+		}
+	}
+}
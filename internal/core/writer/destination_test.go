@@ -0,0 +1,127 @@
+package writer
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/signalfx/golib/datapoint"
+
+	"github.com/signalfx/signalfx-agent/internal/core/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func sentMetrics(sink *fakeDatapointSink) []string {
+	var out []string
+	for _, batch := range sink.sent {
+		for _, dp := range batch {
+			out = append(out, dp.Metric)
+		}
+	}
+	return out
+}
+
+func TestDatapointFanOut(t *testing.T) {
+	sw := newRetryTestWriter()
+	defer sw.cancel()
+
+	filters, err := (&config.DestinationConfig{
+		MetricsToExclude: []config.MetricFilter{{MetricNames: []string{"cpu.*"}}},
+	}).DatapointFilters()
+	assert.Nil(t, err)
+
+	primarySink := &fakeDatapointSink{}
+	filteredSink := &fakeDatapointSink{}
+	disabledSink := &fakeDatapointSink{}
+	setTestDestinations(sw,
+		&destination{dpSink: primarySink, sendDatapoints: true},
+		&destination{name: "filtered", dpSink: filteredSink, sendDatapoints: true, datapointFilters: filters},
+		&destination{name: "disabled", dpSink: disabledSink, sendDatapoints: false})
+
+	dps := append(testDatapoints("cpu.utilization"), testDatapoints("memory.used")...)
+	sendTestDatapoints(t, sw, dps)
+
+	assert.Equal(t, []string{"cpu.utilization", "memory.used"}, sentMetrics(primarySink))
+	assert.Equal(t, []string{"memory.used"}, sentMetrics(filteredSink))
+	assert.Len(t, disabledSink.sent, 0)
+
+	assert.Equal(t, int64(2), sw.destinations[0].dpsSent)
+	assert.Equal(t, int64(1), sw.destinations[1].dpsSent)
+
+	// The destination filters don't modify the batch
+	assert.Len(t, dps, 2)
+}
+
+func TestDatapointFanOutFailuresAreIndependent(t *testing.T) {
+	sw := newRetryTestWriter()
+	defer sw.cancel()
+
+	failingSink := &fakeDatapointSink{errs: []error{&remoteWriteError{StatusCode: 400}}}
+	healthySink := &fakeDatapointSink{}
+	setTestDestinations(sw,
+		&destination{name: "failing", dpSink: failingSink, sendDatapoints: true},
+		&destination{name: "healthy", dpSink: healthySink, sendDatapoints: true})
+
+	sendTestDatapoints(t, sw, testDatapoints("cpu.utilization"))
+
+	assert.Len(t, failingSink.sent, 0)
+	assert.Equal(t, []string{"cpu.utilization"}, sentMetrics(healthySink))
+}
+
+func TestAllDatapointsFilteredSendsNothing(t *testing.T) {
+	sw := newRetryTestWriter()
+	defer sw.cancel()
+
+	filters, err := (&config.DestinationConfig{
+		MetricsToExclude: []config.MetricFilter{{MetricNames: []string{"*"}}},
+	}).DatapointFilters()
+	assert.Nil(t, err)
+
+	sink := &fakeDatapointSink{}
+	setTestDestinations(sw, &destination{name: "none", dpSink: sink, sendDatapoints: true, datapointFilters: filters})
+
+	sendTestDatapoints(t, sw, testDatapoints("cpu.utilization"))
+	assert.Equal(t, 0, sink.attemptCount())
+	assert.Equal(t, int64(0), atomic.LoadInt64(&sw.destinations[0].dpPipeline.inFlight))
+}
+
+// Blocks every send until released
+type blockingDatapointSink struct {
+	release chan struct{}
+}
+
+func (s *blockingDatapointSink) AddDatapoints(ctx context.Context, dps []*datapoint.Datapoint) error {
+	<-s.release
+	return nil
+}
+
+func TestSlowDestinationDoesNotHoldUpOthers(t *testing.T) {
+	sw := newRetryTestWriter()
+	defer sw.cancel()
+	sw.conf.MaxDatapointsInFlight = 10
+
+	slowSink := &blockingDatapointSink{release: make(chan struct{})}
+	fastSink := &fakeDatapointSink{}
+	setTestDestinations(sw,
+		&destination{dpSink: fastSink, sendDatapoints: true},
+		&destination{name: "slow", dpSink: slowSink, sendDatapoints: true})
+
+	// Each destination only has a single request slot, which the slow
+	// destination's first batch holds on to
+	sw.sendDatapoints(testDatapoints("a"))
+	sw.sendDatapoints(testDatapoints("b"))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for fastSink.attemptCount() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("The slow destination held up the other one")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, int64(2), atomic.LoadInt64(&sw.destinations[1].dpPipeline.inFlight))
+
+	close(slowSink.release)
+	waitForDestinations(t, sw)
+	assert.Equal(t, int64(2), atomic.LoadInt64(&sw.destinations[1].dpsSent))
+}
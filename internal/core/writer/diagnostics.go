@@ -2,20 +2,42 @@ package writer
 
 import (
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/signalfx/golib/datapoint"
 	"github.com/signalfx/golib/sfxclient"
+	"github.com/signalfx/signalfx-agent/internal/utils"
 )
 
 func (sw *SignalFxWriter) averageDPM() uint {
 	minutesActive := time.Since(sw.startTime).Minutes()
-	return uint(float64(sw.dpsSent) / minutesActive)
+	return uint(float64(sw.destinations[0].dpsSent) / minutesActive)
 }
 
 // DiagnosticText outputs a string that describes the state of the writer to a
 // human.
 func (sw *SignalFxWriter) DiagnosticText() string {
+	primary := sw.destinations[0]
+
+	var destinationsText string
+	for _, dest := range sw.destinations[1:] {
+		destinationsText += fmt.Sprintf("Destination %s:\n%s", dest.name, utils.IndentLines(
+			fmt.Sprintf(
+				"DPs Sent:                   %d\n"+
+					"Events Sent:                %d\n"+
+					"Trace Spans Sent:           %d\n"+
+					"Trace Spans Failed:         %d\n"+
+					"%s"+
+					"%s",
+				dest.dpsSent,
+				dest.eventsSent,
+				dest.traceSpansSent,
+				dest.traceSpansFailedToSend,
+				dest.pipelineDiagnosticText(),
+				dest.diskBufferDiagnosticText()), 2))
+	}
+
 	return fmt.Sprintf(
 		"Writer Status:\n"+
 			"Global Dims:                %s\n"+
@@ -23,45 +45,98 @@ func (sw *SignalFxWriter) DiagnosticText() string {
 			"Average DPM:                %d\n"+
 			"DPs Sent:                   %d\n"+
 			"Events Sent:                %d\n"+
-			"DPs In Flight:              %d\n"+
-			"DP Requests Active:         %d\n"+
-			"Trace spans In Flight:      %d\n"+
-			"Trace Span Requests Active: %d\n"+
+			"Events Filtered:            %d\n"+
+			"%s"+
+			"Trace Spans Filtered:       %d\n"+
+			"Trace Spans Sampled:        %d\n"+
+			"Trace Spans Sampled Out:    %d\n"+
 			"Events Buffered:            %d\n"+
 			"DPs Channel (len/cap) :     %d/%d\n"+
-			"Events Channel (len/cap):   %d/%d\n",
+			"Events Channel (len/cap):   %d/%d\n"+
+			"%s"+
+			"%s",
 		sw.conf.GlobalDimensions,
 		sw.hostIDDims,
 		sw.averageDPM(),
-		sw.dpsSent,
-		sw.eventsSent,
-		sw.dpsInFlight,
-		sw.dpRequestsActive,
-		sw.traceSpansInFlight,
-		sw.traceSpanRequestsActive,
+		primary.dpsSent,
+		primary.eventsSent,
+		atomic.LoadInt64(&sw.eventsFiltered),
+		primary.pipelineDiagnosticText(),
+		atomic.LoadInt64(&sw.traceSpansFiltered),
+		atomic.LoadInt64(&sw.traceSpansSampled),
+		atomic.LoadInt64(&sw.traceSpansSampledOut),
 		len(sw.eventBuffer),
 		len(sw.dpChan),
 		cap(sw.dpChan),
 		len(sw.eventChan),
-		cap(sw.eventChan))
+		cap(sw.eventChan),
+		primary.diskBufferDiagnosticText(),
+		destinationsText)
 }
 
 // InternalMetrics returns a set of metrics showing how the writer is currently
 // doing.
 func (sw *SignalFxWriter) InternalMetrics() []*datapoint.Datapoint {
-	return append([]*datapoint.Datapoint{
-		sfxclient.Cumulative("sfxagent.datapoints_sent", nil, int64(sw.dpsSent)),
-		sfxclient.Cumulative("sfxagent.events_sent", nil, int64(sw.eventsSent)),
-		sfxclient.Cumulative("sfxagent.dim_prop_sets_sent", nil, int64(sw.dimPropClient.TotalPropUpdates)),
+	out := append([]*datapoint.Datapoint{
 		sfxclient.Gauge("sfxagent.datapoints_buffered", nil, int64(len(sw.dpChan))),
-		sfxclient.Gauge("sfxagent.datapoints_in_flight", nil, sw.dpsInFlight),
-		sfxclient.Gauge("sfxagent.datapoint_requests_active", nil, sw.dpRequestsActive),
 		sfxclient.Gauge("sfxagent.events_buffered", nil, int64(len(sw.eventBuffer))),
-		sfxclient.Cumulative("sfxagent.trace_spans_sent", nil, int64(sw.traceSpansSent)),
-		sfxclient.Cumulative("sfxagent.trace_spans_failed", nil, int64(sw.traceSpansFailedToSend)),
-		sfxclient.Cumulative("sfxagent.trace_spans_dropped", nil, int64(sw.traceSpansDropped)),
+		sfxclient.Cumulative("sfxagent.events_filtered", nil, atomic.LoadInt64(&sw.eventsFiltered)),
 		sfxclient.Gauge("sfxagent.trace_spans_buffered", nil, int64(len(sw.spanChan))),
-		sfxclient.Gauge("sfxagent.trace_spans_in_flight", nil, sw.traceSpansInFlight),
-		sfxclient.Gauge("sfxagent.trace_span_requests_active", nil, sw.traceSpanRequestsActive),
+		sfxclient.Cumulative("sfxagent.trace_spans_filtered", nil, atomic.LoadInt64(&sw.traceSpansFiltered)),
+		sfxclient.Cumulative("sfxagent.trace_spans_sampled", nil, atomic.LoadInt64(&sw.traceSpansSampled)),
+		sfxclient.Cumulative("sfxagent.trace_spans_sampled_out", nil, atomic.LoadInt64(&sw.traceSpansSampledOut)),
 	}, sw.serviceTracker.InternalMetrics()...)
+
+	if sw.spanMetrics != nil {
+		out = append(out,
+			sfxclient.Gauge("sfxagent.span_metrics_series", nil, int64(sw.spanMetrics.SeriesCount())),
+			sfxclient.Cumulative("sfxagent.span_metrics_overflow_spans", nil, sw.spanMetrics.OverflowSpans()))
+	}
+
+	for _, dest := range sw.destinations {
+		dims := dest.metricDims()
+		out = append(out, []*datapoint.Datapoint{
+			sfxclient.Cumulative("sfxagent.datapoints_sent", dims, int64(dest.dpsSent)),
+			sfxclient.Cumulative("sfxagent.events_sent", dims, int64(dest.eventsSent)),
+			sfxclient.Cumulative("sfxagent.trace_spans_sent", dims, int64(dest.traceSpansSent)),
+			sfxclient.Cumulative("sfxagent.trace_spans_failed", dims, int64(dest.traceSpansFailedToSend)),
+			sfxclient.Gauge("sfxagent.datapoints_in_flight", dims, atomic.LoadInt64(&dest.dpPipeline.inFlight)),
+			sfxclient.Gauge("sfxagent.datapoint_requests_active", dims, atomic.LoadInt64(&dest.dpPipeline.requestsActive)),
+			sfxclient.Cumulative("sfxagent.datapoints_dropped", dims, atomic.LoadInt64(&dest.dpPipeline.dropped)),
+		}...)
+		if dest.spanPipeline != nil {
+			out = append(out,
+				sfxclient.Gauge("sfxagent.trace_spans_in_flight", dims, atomic.LoadInt64(&dest.spanPipeline.inFlight)),
+				sfxclient.Gauge("sfxagent.trace_span_requests_active", dims, atomic.LoadInt64(&dest.spanPipeline.requestsActive)),
+				sfxclient.Cumulative("sfxagent.trace_spans_dropped", dims, atomic.LoadInt64(&dest.spanPipeline.dropped)))
+		}
+		if dest.dimPropClient != nil {
+			out = append(out,
+				sfxclient.Cumulative("sfxagent.dim_prop_sets_sent", dims, atomic.LoadInt64(&dest.dimPropClient.TotalPropUpdates)),
+				sfxclient.Cumulative("sfxagent.dim_prop_sets_failed", dims, atomic.LoadInt64(&dest.dimPropClient.TotalPropUpdatesFailed)),
+				sfxclient.Gauge("sfxagent.dim_prop_sets_pending", dims, int64(dest.dimPropClient.PendingCount())))
+		}
+		out = append(out, dest.diskBufferInternalMetrics()...)
+	}
+	return out
+}
+
+func (d *destination) pipelineDiagnosticText() string {
+	text := fmt.Sprintf(
+		"DPs In Flight:              %d\n"+
+			"DP Requests Active:         %d\n"+
+			"DPs Dropped:                %d\n",
+		atomic.LoadInt64(&d.dpPipeline.inFlight),
+		atomic.LoadInt64(&d.dpPipeline.requestsActive),
+		atomic.LoadInt64(&d.dpPipeline.dropped))
+	if d.spanPipeline != nil {
+		text += fmt.Sprintf(
+			"Trace spans In Flight:      %d\n"+
+				"Trace Span Requests Active: %d\n"+
+				"Trace Spans Dropped:        %d\n",
+			atomic.LoadInt64(&d.spanPipeline.inFlight),
+			atomic.LoadInt64(&d.spanPipeline.requestsActive),
+			atomic.LoadInt64(&d.spanPipeline.dropped))
+	}
+	return text
 }
@@ -0,0 +1,236 @@
+package writer
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/signalfx/golib/datapoint"
+	"github.com/signalfx/golib/event"
+	"github.com/signalfx/golib/sfxclient"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	diskBufferDatapointKind = "dp"
+	diskBufferEventKind     = "event"
+	// How often to try and resend buffered batches if no send has succeeded
+	// in the meantime.
+	diskBufferReplayInterval = 10 * time.Second
+)
+
+// The golib JSON encoding of datapoints loses the distinction between int and
+// float values that happen to be whole numbers, so use our own.
+type bufferedDatapoint struct {
+	Metric     string               `json:"metric"`
+	Dimensions map[string]string    `json:"dimensions"`
+	MetricType datapoint.MetricType `json:"metricType"`
+	Timestamp  time.Time            `json:"timestamp"`
+	IntValue   *int64               `json:"intValue,omitempty"`
+	FloatValue *float64             `json:"floatValue,omitempty"`
+	StrValue   *string              `json:"strValue,omitempty"`
+}
+
+func encodeDatapoints(dps []*datapoint.Datapoint) ([]byte, error) {
+	out := make([]bufferedDatapoint, len(dps))
+	for i, dp := range dps {
+		out[i] = bufferedDatapoint{
+			Metric:     dp.Metric,
+			Dimensions: dp.Dimensions,
+			MetricType: dp.MetricType,
+			Timestamp:  dp.Timestamp,
+		}
+		switch v := dp.Value.(type) {
+		case datapoint.IntValue:
+			iv := v.Int()
+			out[i].IntValue = &iv
+		case datapoint.FloatValue:
+			fv := v.Float()
+			out[i].FloatValue = &fv
+		default:
+			sv := v.String()
+			out[i].StrValue = &sv
+		}
+	}
+	return json.Marshal(out)
+}
+
+func decodeDatapoints(payload []byte) ([]*datapoint.Datapoint, error) {
+	var in []bufferedDatapoint
+	if err := json.Unmarshal(payload, &in); err != nil {
+		return nil, err
+	}
+
+	dps := make([]*datapoint.Datapoint, len(in))
+	for i, bdp := range in {
+		var val datapoint.Value
+		switch {
+		case bdp.IntValue != nil:
+			val = datapoint.NewIntValue(*bdp.IntValue)
+		case bdp.FloatValue != nil:
+			val = datapoint.NewFloatValue(*bdp.FloatValue)
+		case bdp.StrValue != nil:
+			val = datapoint.NewStringValue(*bdp.StrValue)
+		default:
+			return nil, fmt.Errorf("buffered datapoint %s has no value", bdp.Metric)
+		}
+		dps[i] = datapoint.New(bdp.Metric, bdp.Dimensions, val, bdp.MetricType, bdp.Timestamp)
+	}
+	return dps, nil
+}
+
+func decodeEvents(payload []byte) ([]*event.Event, error) {
+	var events []*event.Event
+	if err := json.Unmarshal(payload, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// Batches that failed with a non-retryable error would only fail again when
+// resent, so they are not buffered.
+func (sw *SignalFxWriter) bufferFailedDatapoints(dest *destination, dps []*datapoint.Datapoint, sendErr error) {
+	if dest.diskBuffer == nil || !sw.retryPolicy.isRetryable(sendErr) {
+		return
+	}
+	payload, err := encodeDatapoints(dps)
+	if err == nil {
+		err = dest.diskBuffer.Put(diskBufferDatapointKind, payload)
+	}
+	if err != nil {
+		log.WithError(err).Errorf("Could not write %d failed datapoints for %s to disk buffer", len(dps), dest)
+		return
+	}
+	log.Debugf("Wrote %d failed datapoints for %s to disk buffer", len(dps), dest)
+}
+
+func (sw *SignalFxWriter) bufferFailedEvents(dest *destination, events []*event.Event, sendErr error) {
+	if dest.diskBuffer == nil || !sw.retryPolicy.isRetryable(sendErr) {
+		return
+	}
+	payload, err := json.Marshal(events)
+	if err == nil {
+		err = dest.diskBuffer.Put(diskBufferEventKind, payload)
+	}
+	if err != nil {
+		log.WithError(err).Errorf("Could not write %d failed events for %s to disk buffer", len(events), dest)
+		return
+	}
+	log.Debugf("Wrote %d failed events for %s to disk buffer", len(events), dest)
+}
+
+// notifyIngestReachable should be called whenever a send to a destination
+// succeeds so that any buffered batches can be resent promptly.
+func (sw *SignalFxWriter) notifyIngestReachable(dest *destination) {
+	if dest.diskBuffer == nil || dest.diskBuffer.Len() == 0 {
+		return
+	}
+	select {
+	case dest.replayTrigger <- struct{}{}:
+	default:
+	}
+}
+
+// replayDiskBuffer resends buffered batches, oldest first, until either the
+// buffer is empty or a send fails with a retryable error, in which case the
+// failed batch stays at the head of the buffer for the next attempt.  Batches
+// that fail with a non-retryable error are discarded so that they don't hold
+// up the rest of the buffer.
+func (sw *SignalFxWriter) replayDiskBuffer(dest *destination) {
+	for sw.ctx.Err() == nil {
+		entry, payload, err := dest.diskBuffer.Peek()
+		if err != nil {
+			log.WithError(err).Error("Could not read batch from disk buffer")
+			continue
+		}
+		if entry == nil {
+			return
+		}
+
+		var count int
+		var sendErr error
+		switch entry.Kind {
+		case diskBufferDatapointKind:
+			var dps []*datapoint.Datapoint
+			if dps, err = decodeDatapoints(payload); err == nil {
+				count = len(dps)
+				if sendErr = dest.dpSink.AddDatapoints(sw.ctx, dps); sendErr == nil {
+					atomic.AddInt64(&dest.dpsSent, int64(count))
+				}
+			}
+		case diskBufferEventKind:
+			var events []*event.Event
+			if events, err = decodeEvents(payload); err == nil {
+				count = len(events)
+				if sendErr = dest.client.AddEvents(sw.ctx, events); sendErr == nil {
+					atomic.AddInt64(&dest.eventsSent, int64(count))
+				}
+			}
+		default:
+			err = fmt.Errorf("unknown kind %s", entry.Kind)
+		}
+
+		switch {
+		case err != nil:
+			log.WithError(err).Error("Discarding malformed batch from disk buffer")
+			dest.diskBuffer.Discard(entry)
+		case sendErr != nil && sw.retryPolicy.isRetryable(sendErr):
+			return
+		case sendErr != nil:
+			log.WithError(sendErr).Errorf("Discarding %d buffered %s items that %s rejected", count, entry.Kind, dest)
+			dest.diskBuffer.Discard(entry)
+		default:
+			log.Debugf("Resent %d buffered %s items to %s", count, entry.Kind, dest)
+			dest.diskBuffer.Remove(entry)
+		}
+	}
+}
+
+func (sw *SignalFxWriter) startReplayingDiskBuffer(dest *destination) {
+	dest.replayTrigger = make(chan struct{}, 1)
+
+	go func() {
+		ticker := time.NewTicker(diskBufferReplayInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-sw.ctx.Done():
+				return
+			case <-ticker.C:
+				sw.replayDiskBuffer(dest)
+			case <-dest.replayTrigger:
+				sw.replayDiskBuffer(dest)
+			}
+		}
+	}()
+}
+
+func (d *destination) diskBufferDiagnosticText() string {
+	if d.diskBuffer == nil {
+		return "Disk Buffer:                disabled\n"
+	}
+	return fmt.Sprintf(
+		"Disk Buffer Batches:        %d\n"+
+			"Disk Buffer Size (bytes):   %d\n"+
+			"Disk Buffer Oldest Age:     %s\n"+
+			"Disk Buffer Batches Lost:   %d\n",
+		d.diskBuffer.Len(),
+		d.diskBuffer.Size(),
+		d.diskBuffer.OldestAge().Round(time.Second),
+		d.diskBuffer.Dropped())
+}
+
+func (d *destination) diskBufferInternalMetrics() []*datapoint.Datapoint {
+	if d.diskBuffer == nil {
+		return nil
+	}
+	dims := d.metricDims()
+	return []*datapoint.Datapoint{
+		sfxclient.Gauge("sfxagent.disk_buffer_batches", dims, int64(d.diskBuffer.Len())),
+		sfxclient.Gauge("sfxagent.disk_buffer_bytes", dims, d.diskBuffer.Size()),
+		sfxclient.Gauge("sfxagent.disk_buffer_oldest_age_seconds", dims, int64(d.diskBuffer.OldestAge().Seconds())),
+		sfxclient.Cumulative("sfxagent.disk_buffer_batches_dropped", dims, d.diskBuffer.Dropped()),
+	}
+}
@@ -0,0 +1,116 @@
+package writer
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/signalfx/golib/datapoint"
+	"github.com/signalfx/golib/sfxclient"
+	"github.com/signalfx/signalfx-agent/internal/core/writer/diskqueue"
+	"github.com/stretchr/testify/assert"
+)
+
+// Returns the errors in order for each batch that is sent, and nil once they
+// run out
+type fakeDatapointSink struct {
+	sync.Mutex
+	errs     []error
+	sent     [][]*datapoint.Datapoint
+	attempts int
+}
+
+func (s *fakeDatapointSink) AddDatapoints(ctx context.Context, dps []*datapoint.Datapoint) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.attempts++
+	if len(s.errs) > 0 {
+		err := s.errs[0]
+		s.errs = s.errs[1:]
+		if err != nil {
+			return err
+		}
+	}
+	s.sent = append(s.sent, dps)
+	return nil
+}
+
+func (s *fakeDatapointSink) attemptCount() int {
+	s.Lock()
+	defer s.Unlock()
+	return s.attempts
+}
+
+func newDiskBufferTestWriter(t *testing.T, sink datapointSink) (*SignalFxWriter, *destination, func()) {
+	dir, err := ioutil.TempDir("", "diskbuffer")
+	assert.Nil(t, err)
+
+	queue, err := diskqueue.New(dir, 0, 0)
+	assert.Nil(t, err)
+
+	sw := &SignalFxWriter{
+		retryPolicy: &retryPolicy{
+			maxAttempts:    1,
+			retryableCodes: map[int]bool{503: true},
+		},
+	}
+	sw.ctx, sw.cancel = context.WithCancel(context.Background())
+
+	dest := &destination{dpSink: sink, diskBuffer: queue}
+	return sw, dest, func() {
+		sw.cancel()
+		os.RemoveAll(dir)
+	}
+}
+
+func testDatapoints(metric string) []*datapoint.Datapoint {
+	return []*datapoint.Datapoint{
+		datapoint.New(metric, map[string]string{"host": "a"}, datapoint.NewIntValue(1), datapoint.Gauge, time.Unix(1000, 0)),
+	}
+}
+
+func TestDiskBufferOnlyKeepsRetryableFailures(t *testing.T) {
+	sw, dest, cleanup := newDiskBufferTestWriter(t, &fakeDatapointSink{})
+	defer cleanup()
+
+	sw.bufferFailedDatapoints(dest, testDatapoints("bad"), sfxclient.SFXAPIError{StatusCode: 400})
+	assert.Equal(t, 0, dest.diskBuffer.Len())
+
+	sw.bufferFailedDatapoints(dest, testDatapoints("unavailable"), sfxclient.SFXAPIError{StatusCode: 503})
+	assert.Equal(t, 1, dest.diskBuffer.Len())
+}
+
+func TestDiskBufferReplay(t *testing.T) {
+	t.Run("Keeps batches that fail with a retryable error", func(t *testing.T) {
+		sink := &fakeDatapointSink{errs: []error{sfxclient.SFXAPIError{StatusCode: 503}}}
+		sw, dest, cleanup := newDiskBufferTestWriter(t, sink)
+		defer cleanup()
+
+		sw.bufferFailedDatapoints(dest, testDatapoints("a"), sfxclient.SFXAPIError{StatusCode: 503})
+		sw.replayDiskBuffer(dest)
+
+		assert.Equal(t, 1, dest.diskBuffer.Len())
+		assert.Equal(t, int64(0), dest.diskBuffer.Dropped())
+		assert.Len(t, sink.sent, 0)
+	})
+
+	t.Run("Discards batches that are rejected and moves on", func(t *testing.T) {
+		sink := &fakeDatapointSink{errs: []error{sfxclient.SFXAPIError{StatusCode: 413}}}
+		sw, dest, cleanup := newDiskBufferTestWriter(t, sink)
+		defer cleanup()
+
+		sw.bufferFailedDatapoints(dest, testDatapoints("a"), sfxclient.SFXAPIError{StatusCode: 503})
+		sw.bufferFailedDatapoints(dest, testDatapoints("b"), sfxclient.SFXAPIError{StatusCode: 503})
+		sw.replayDiskBuffer(dest)
+
+		assert.Equal(t, 0, dest.diskBuffer.Len())
+		assert.Equal(t, int64(1), dest.diskBuffer.Dropped())
+		assert.Len(t, sink.sent, 1)
+		assert.Equal(t, "b", sink.sent[0][0].Metric)
+		assert.Equal(t, int64(1), dest.dpsSent)
+	})
+}
@@ -0,0 +1,306 @@
+// Package diskqueue contains a simple, file-backed FIFO queue that the writer
+// uses to hold onto batches of data that could not be sent to ingest so that
+// they can be resent later.  Each entry in the queue is a single file in the
+// queue directory, whose name encodes the time it was added and the kind of
+// data it holds, so the queue survives agent restarts.
+package diskqueue
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const tmpSuffix = ".tmp"
+
+// Entry is a single item in the queue
+type Entry struct {
+	// The kind of data in the entry, as given to Put
+	Kind    string
+	Created time.Time
+	path    string
+	size    int64
+}
+
+// Queue is a FIFO queue of byte payloads that are stored on disk.  It is safe
+// for concurrent use.
+type Queue struct {
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+
+	lock    sync.Mutex
+	entries []*Entry
+	size    int64
+	seq     uint64
+	// How many entries have been discarded due to age or size limits
+	dropped int64
+
+	timeNow func() time.Time
+}
+
+// New creates a queue that stores its entries in dir, creating the directory
+// if necessary.  Any entries that already exist in dir (e.g. from a previous
+// run of the agent) will be loaded into the queue.  If maxBytes or maxAge are
+// zero, that limit is not enforced.
+func New(dir string, maxBytes int64, maxAge time.Duration) (*Queue, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrapf(err, "could not create disk queue directory %s", dir)
+	}
+
+	q := &Queue{
+		dir:      dir,
+		maxBytes: maxBytes,
+		maxAge:   maxAge,
+		timeNow:  time.Now,
+	}
+
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+func (q *Queue) load() error {
+	files, err := ioutil.ReadDir(q.dir)
+	if err != nil {
+		return errors.Wrapf(err, "could not read disk queue directory %s", q.dir)
+	}
+
+	for _, fi := range files {
+		if fi.IsDir() {
+			continue
+		}
+		path := filepath.Join(q.dir, fi.Name())
+		if strings.HasSuffix(fi.Name(), tmpSuffix) {
+			// Partially written entry from an agent that died mid-write
+			os.Remove(path)
+			continue
+		}
+
+		entry, err := parseEntryName(fi.Name())
+		if err != nil {
+			log.WithFields(log.Fields{
+				"path":  path,
+				"error": err,
+			}).Warn("Ignoring unrecognized file in disk queue directory")
+			continue
+		}
+		entry.path = path
+		entry.size = fi.Size()
+
+		q.entries = append(q.entries, entry)
+		q.size += entry.size
+	}
+
+	// File names sort lexically in insertion order
+	sort.Slice(q.entries, func(i, j int) bool {
+		return q.entries[i].path < q.entries[j].path
+	})
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.expireOld()
+	q.shrinkTo(q.maxBytes)
+	return nil
+}
+
+// File names are of the form <unix nanos>-<seq>.<kind>, with the numbers zero
+// padded so that they sort correctly.
+func entryName(created time.Time, seq uint64, kind string) string {
+	return fmt.Sprintf("%020d-%010d.%s", created.UnixNano(), seq, kind)
+}
+
+func parseEntryName(name string) (*Entry, error) {
+	dot := strings.Index(name, ".")
+	dash := strings.Index(name, "-")
+	if dot < 0 || dash < 0 || dash > dot {
+		return nil, fmt.Errorf("malformed entry name %s", name)
+	}
+
+	nanos, err := strconv.ParseInt(name[:dash], 10, 64)
+	if err != nil {
+		return nil, errors.Wrapf(err, "malformed entry timestamp in %s", name)
+	}
+
+	return &Entry{
+		Kind:    name[dot+1:],
+		Created: time.Unix(0, nanos),
+	}, nil
+}
+
+// Put adds a new payload to the end of the queue.  If the queue would exceed
+// its size limit, the oldest entries are discarded to make room.
+func (q *Queue) Put(kind string, payload []byte) error {
+	size := int64(len(payload))
+	if q.maxBytes > 0 && size > q.maxBytes {
+		return fmt.Errorf("payload of %d bytes is larger than the disk queue limit of %d bytes", size, q.maxBytes)
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.expireOld()
+	if q.maxBytes > 0 {
+		q.shrinkTo(q.maxBytes - size)
+	}
+
+	created := q.timeNow()
+	q.seq++
+	path := filepath.Join(q.dir, entryName(created, q.seq, kind))
+
+	// Write to a temp file first so that a crash never leaves a partial
+	// entry that looks complete.
+	if err := ioutil.WriteFile(path+tmpSuffix, payload, 0600); err != nil {
+		os.Remove(path + tmpSuffix)
+		return errors.Wrap(err, "could not write disk queue entry")
+	}
+	if err := os.Rename(path+tmpSuffix, path); err != nil {
+		os.Remove(path + tmpSuffix)
+		return errors.Wrap(err, "could not finalize disk queue entry")
+	}
+
+	q.entries = append(q.entries, &Entry{
+		Kind:    kind,
+		Created: created,
+		path:    path,
+		size:    size,
+	})
+	q.size += size
+	return nil
+}
+
+// Peek returns the oldest entry in the queue along with its payload without
+// removing it.  Returns a nil entry if the queue is empty.  Entries that have
+// become unreadable are discarded.
+func (q *Queue) Peek() (*Entry, []byte, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.expireOld()
+	if len(q.entries) == 0 {
+		return nil, nil, nil
+	}
+
+	entry := q.entries[0]
+	payload, err := ioutil.ReadFile(entry.path)
+	if err != nil {
+		q.removeFirst()
+		q.dropped++
+		return nil, nil, errors.Wrapf(err, "could not read disk queue entry %s, discarding it", entry.path)
+	}
+	return entry, payload, nil
+}
+
+// Remove deletes the given entry, which should have been obtained from Peek,
+// from the queue.  It is a noop if the entry has already been removed.
+func (q *Queue) Remove(entry *Entry) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.remove(entry)
+}
+
+// Discard deletes the given entry like Remove but counts it as dropped, for
+// entries that could not be used.
+func (q *Queue) Discard(entry *Entry) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.remove(entry) {
+		q.dropped++
+	}
+}
+
+// Must be called while holding the lock
+func (q *Queue) remove(entry *Entry) bool {
+	for i := range q.entries {
+		if q.entries[i] == entry {
+			os.Remove(entry.path)
+			q.size -= entry.size
+			q.entries = append(q.entries[:i], q.entries[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Len returns the number of entries in the queue
+func (q *Queue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.entries)
+}
+
+// Size returns the total number of bytes of all entries in the queue
+func (q *Queue) Size() int64 {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.size
+}
+
+// OldestAge returns how long the oldest entry in the queue has been there, or
+// zero if the queue is empty.
+func (q *Queue) OldestAge() time.Duration {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if len(q.entries) == 0 {
+		return 0
+	}
+	return q.timeNow().Sub(q.entries[0].Created)
+}
+
+// Dropped returns the total number of entries that have been discarded
+// without being removed by the user of the queue.
+func (q *Queue) Dropped() int64 {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.dropped
+}
+
+// Must be called while holding the lock
+func (q *Queue) removeFirst() {
+	os.Remove(q.entries[0].path)
+	q.size -= q.entries[0].size
+	q.entries = q.entries[1:]
+}
+
+// Must be called while holding the lock
+func (q *Queue) expireOld() {
+	if q.maxAge <= 0 {
+		return
+	}
+	now := q.timeNow()
+	for len(q.entries) > 0 && now.Sub(q.entries[0].Created) > q.maxAge {
+		log.WithFields(log.Fields{
+			"path": q.entries[0].path,
+			"age":  now.Sub(q.entries[0].Created),
+		}).Warn("Discarding expired disk queue entry")
+		q.removeFirst()
+		q.dropped++
+	}
+}
+
+// Must be called while holding the lock
+func (q *Queue) shrinkTo(maxBytes int64) {
+	if q.maxBytes <= 0 {
+		return
+	}
+	for len(q.entries) > 0 && q.size > maxBytes {
+		log.WithFields(log.Fields{
+			"path":     q.entries[0].path,
+			"maxBytes": q.maxBytes,
+		}).Warn("Discarding oldest disk queue entry due to size limit")
+		q.removeFirst()
+		q.dropped++
+	}
+}
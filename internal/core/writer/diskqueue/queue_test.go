@@ -0,0 +1,126 @@
+package diskqueue
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/signalfx/signalfx-agent/internal/neotest"
+	"github.com/stretchr/testify/assert"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "diskqueue")
+	assert.Nil(t, err)
+	return dir
+}
+
+func TestFIFOOrder(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	q, err := New(dir, 0, 0)
+	assert.Nil(t, err)
+
+	assert.Nil(t, q.Put("dp", []byte("one")))
+	assert.Nil(t, q.Put("event", []byte("two")))
+	assert.Equal(t, 2, q.Len())
+	assert.Equal(t, int64(6), q.Size())
+
+	entry, payload, err := q.Peek()
+	assert.Nil(t, err)
+	assert.Equal(t, "dp", entry.Kind)
+	assert.Equal(t, "one", string(payload))
+	q.Remove(entry)
+
+	entry, payload, err = q.Peek()
+	assert.Nil(t, err)
+	assert.Equal(t, "event", entry.Kind)
+	assert.Equal(t, "two", string(payload))
+	q.Remove(entry)
+
+	entry, _, err = q.Peek()
+	assert.Nil(t, err)
+	assert.Nil(t, entry)
+	assert.Equal(t, int64(0), q.Size())
+}
+
+func TestReloadsExistingEntries(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	q, err := New(dir, 0, 0)
+	assert.Nil(t, err)
+	assert.Nil(t, q.Put("dp", []byte("one")))
+	assert.Nil(t, q.Put("dp", []byte("two")))
+
+	q2, err := New(dir, 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, q2.Len())
+
+	_, payload, err := q2.Peek()
+	assert.Nil(t, err)
+	assert.Equal(t, "one", string(payload))
+}
+
+func TestSizeLimitDropsOldest(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	q, err := New(dir, 10, 0)
+	assert.Nil(t, err)
+
+	assert.Nil(t, q.Put("dp", []byte("aaaa")))
+	assert.Nil(t, q.Put("dp", []byte("bbbb")))
+	assert.Nil(t, q.Put("dp", []byte("cccc")))
+	assert.Equal(t, 2, q.Len())
+	assert.Equal(t, int64(1), q.Dropped())
+
+	_, payload, err := q.Peek()
+	assert.Nil(t, err)
+	assert.Equal(t, "bbbb", string(payload))
+
+	assert.NotNil(t, q.Put("dp", []byte("this is too big")))
+}
+
+func TestAgeLimitDropsOld(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	q, err := New(dir, 0, 5*time.Minute)
+	assert.Nil(t, err)
+	q.timeNow = neotest.PinnedNow(time.Unix(1000, 0))
+
+	assert.Nil(t, q.Put("dp", []byte("old")))
+	q.timeNow = neotest.AdvancedNow(q.timeNow, 3*time.Minute)
+	assert.Nil(t, q.Put("dp", []byte("new")))
+	assert.Equal(t, 3*time.Minute, q.OldestAge())
+
+	q.timeNow = neotest.AdvancedNow(q.timeNow, 3*time.Minute)
+	_, payload, err := q.Peek()
+	assert.Nil(t, err)
+	assert.Equal(t, "new", string(payload))
+	assert.Equal(t, 1, q.Len())
+	assert.Equal(t, int64(1), q.Dropped())
+}
+
+func TestDiscardCountsAsDropped(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	q, err := New(dir, 0, 0)
+	assert.Nil(t, err)
+
+	assert.Nil(t, q.Put("dp", []byte("one")))
+	entry, _, err := q.Peek()
+	assert.Nil(t, err)
+
+	q.Discard(entry)
+	assert.Equal(t, 0, q.Len())
+	assert.Equal(t, int64(1), q.Dropped())
+
+	// Discarding again is a noop
+	q.Discard(entry)
+	assert.Equal(t, int64(1), q.Dropped())
+}
@@ -0,0 +1,54 @@
+package writer
+
+import (
+	"github.com/signalfx/golib/datapoint"
+	"github.com/signalfx/signalfx-agent/internal/core/dpfilters"
+	"github.com/signalfx/signalfx-agent/internal/utils"
+)
+
+// ExplainDatapointFilters returns how the top-level datapoint filters and the
+// filters of each additional destination, keyed by destination name, would
+// treat the given datapoint.  The datapoint should have the monitor type in
+// its Meta field if there is one.  Like when datapoints are sent, the
+// destination filters see the datapoint after the metric transforms and the
+// global and host dimensions are applied.  The given datapoint is not
+// modified.
+func (sw *SignalFxWriter) ExplainDatapointFilters(dp *datapoint.Datapoint) (dpfilters.Decision, map[string]dpfilters.Decision) {
+	var global dpfilters.Decision
+	if sw.datapointFilters != nil {
+		global = sw.datapointFilters.Explain(dp)
+	}
+
+	transformed := *dp
+	transformed.Dimensions = utils.CloneStringMap(dp.Dimensions)
+	sw.transformDatapoint(&transformed)
+	dp = &transformed
+
+	destinations := map[string]dpfilters.Decision{}
+	for _, dest := range sw.destinations[1:] {
+		if dest.datapointFilters != nil {
+			destinations[dest.name] = dest.datapointFilters.Explain(dp)
+		} else {
+			destinations[dest.name] = dpfilters.Decision{}
+		}
+	}
+	return global, destinations
+}
+
+// DatapointFilterStats returns the match counts of the top-level datapoint
+// filters and those of each additional destination, keyed by destination
+// name.
+func (sw *SignalFxWriter) DatapointFilterStats() ([]dpfilters.FilterStat, map[string][]dpfilters.FilterStat) {
+	var global []dpfilters.FilterStat
+	if sw.datapointFilters != nil {
+		global = sw.datapointFilters.Stats()
+	}
+
+	destinations := map[string][]dpfilters.FilterStat{}
+	for _, dest := range sw.destinations[1:] {
+		if dest.datapointFilters != nil {
+			destinations[dest.name] = dest.datapointFilters.Stats()
+		}
+	}
+	return global, destinations
+}
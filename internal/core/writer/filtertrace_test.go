@@ -0,0 +1,50 @@
+package writer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/signalfx/golib/datapoint"
+	"github.com/signalfx/signalfx-agent/internal/core/common/dpmeta"
+	"github.com/signalfx/signalfx-agent/internal/core/config"
+	"github.com/signalfx/signalfx-agent/internal/core/dptransforms"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExplainDatapointFiltersAfterTransforms(t *testing.T) {
+	transform, err := dptransforms.New("", []string{"jvm.heap"}, nil, "java.heap", nil, nil, nil, nil)
+	assert.Nil(t, err)
+
+	destFilters := func(filter config.MetricFilter) *destination {
+		filters, err := (&config.DestinationConfig{MetricsToExclude: []config.MetricFilter{filter}}).DatapointFilters()
+		assert.Nil(t, err)
+		return &destination{datapointFilters: filters}
+	}
+	renamed := destFilters(config.MetricFilter{MetricNames: []string{"java.heap"}})
+	renamed.name = "renamed"
+	global := destFilters(config.MetricFilter{Dimensions: map[string]string{"env": "prod"}})
+	global.name = "global"
+	host := destFilters(config.MetricFilter{Dimensions: map[string]string{"host": "myhost"}})
+	host.name = "host"
+
+	sw := &SignalFxWriter{
+		conf:         &config.WriterConfig{GlobalDimensions: map[string]string{"env": "prod"}},
+		hostIDDims:   map[string]string{"host": "myhost"},
+		dpTransforms: []*dptransforms.Transform{transform},
+		destinations: []*destination{{}, renamed, global, host},
+	}
+
+	dims := map[string]string{"app": "a"}
+	dp := datapoint.New("jvm.heap", dims, nil, datapoint.Gauge, time.Now())
+	dp.Meta = map[interface{}]interface{}{dpmeta.MonitorTypeMeta: "collectd/genericjmx"}
+
+	_, decisions := sw.ExplainDatapointFilters(dp)
+	assert.True(t, decisions["renamed"].Excluded)
+	assert.True(t, decisions["global"].Excluded)
+	assert.True(t, decisions["host"].Excluded)
+
+	// The datapoint itself is left alone
+	assert.Equal(t, "jvm.heap", dp.Metric)
+	assert.Equal(t, map[string]string{"app": "a"}, dims)
+	assert.Equal(t, map[string]string{"app": "a"}, dp.Dimensions)
+}
@@ -0,0 +1,233 @@
+package writer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/signalfx/golib/datapoint"
+	"github.com/signalfx/golib/event"
+	"github.com/signalfx/golib/trace"
+	"github.com/signalfx/signalfx-agent/internal/core/common/dpmeta"
+	"github.com/signalfx/signalfx-agent/internal/monitors/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// The value of `jsonLinesPath` that causes records to go to stdout
+const jsonLinesStdout = "-"
+
+// jsonLinesRecorder writes everything that the writer sends out as one JSON
+// object per line, either to stdout or to a file that is rotated when it
+// gets too big.
+type jsonLinesRecorder struct {
+	sync.Mutex
+	path       string
+	maxBytes   int64
+	maxBackups int
+
+	out  io.Writer
+	file *os.File
+	size int64
+}
+
+func newJSONLinesRecorder(path string, maxBytes int64, maxBackups int) (*jsonLinesRecorder, error) {
+	r := &jsonLinesRecorder{
+		path:       path,
+		maxBytes:   maxBytes,
+		maxBackups: maxBackups,
+	}
+
+	if path == jsonLinesStdout {
+		r.out = os.Stdout
+		return r, nil
+	}
+
+	if err := r.openFile(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *jsonLinesRecorder) openFile() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.file = f
+	r.out = f
+	r.size = info.Size()
+	return nil
+}
+
+// rotate moves the current file to `<path>.1`, shifting older backups up by
+// one and removing any beyond maxBackups, and then opens a fresh file.
+func (r *jsonLinesRecorder) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+
+	for i := r.maxBackups; i > 0; i-- {
+		src := r.path
+		if i > 1 {
+			src = fmt.Sprintf("%s.%d", r.path, i-1)
+		}
+		if _, err := os.Stat(src); err != nil {
+			continue
+		}
+		if err := os.Rename(src, fmt.Sprintf("%s.%d", r.path, i)); err != nil {
+			return err
+		}
+	}
+
+	if r.maxBackups <= 0 {
+		if err := os.Remove(r.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return r.openFile()
+}
+
+func (r *jsonLinesRecorder) write(record interface{}) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.WithError(err).Error("Could not serialize JSON lines record")
+		return
+	}
+	line = append(line, '\n')
+
+	r.Lock()
+	defer r.Unlock()
+
+	if r.file != nil && r.maxBytes > 0 && r.size > 0 && r.size+int64(len(line)) > r.maxBytes {
+		if err := r.rotate(); err != nil {
+			log.WithError(err).Errorf("Could not rotate JSON lines file %s", r.path)
+			return
+		}
+	}
+
+	n, err := r.out.Write(line)
+	r.size += int64(n)
+	if err != nil {
+		log.WithError(err).Error("Could not write JSON lines record")
+	}
+}
+
+// Close the underlying file, if any
+func (r *jsonLinesRecorder) Close() error {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	return r.file.Close()
+}
+
+type jsonLinesDatapoint struct {
+	Type        string            `json:"type"`
+	MonitorType string            `json:"monitorType,omitempty"`
+	Metric      string            `json:"metric"`
+	MetricType  string            `json:"metricType"`
+	Dimensions  map[string]string `json:"dimensions"`
+	Value       interface{}       `json:"value"`
+	Timestamp   time.Time         `json:"timestamp"`
+}
+
+func (r *jsonLinesRecorder) recordDatapoint(dp *datapoint.Datapoint) {
+	monitorType, _ := dp.Meta[dpmeta.MonitorTypeMeta].(string)
+
+	var value interface{}
+	switch v := dp.Value.(type) {
+	case datapoint.IntValue:
+		value = v.Int()
+	case datapoint.FloatValue:
+		value = v.Float()
+	default:
+		value = v.String()
+	}
+
+	r.write(&jsonLinesDatapoint{
+		Type:        "datapoint",
+		MonitorType: monitorType,
+		Metric:      dp.Metric,
+		MetricType:  jsonLinesMetricType(dp.MetricType),
+		Dimensions:  dp.Dimensions,
+		Value:       value,
+		Timestamp:   dp.Timestamp,
+	})
+}
+
+// Uses the same names as the SignalFx API
+func jsonLinesMetricType(t datapoint.MetricType) string {
+	switch t {
+	case datapoint.Gauge:
+		return "gauge"
+	case datapoint.Count:
+		return "counter"
+	case datapoint.Counter:
+		return "cumulative_counter"
+	default:
+		return fmt.Sprintf("unknown_%d", t)
+	}
+}
+
+type jsonLinesEvent struct {
+	Type        string                 `json:"type"`
+	MonitorType string                 `json:"monitorType,omitempty"`
+	EventType   string                 `json:"eventType"`
+	Category    event.Category         `json:"category"`
+	Dimensions  map[string]string      `json:"dimensions"`
+	Properties  map[string]interface{} `json:"properties"`
+	Timestamp   time.Time              `json:"timestamp"`
+}
+
+func (r *jsonLinesRecorder) recordEvent(ev *event.Event, monitorType string) {
+	r.write(&jsonLinesEvent{
+		Type:        "event",
+		MonitorType: monitorType,
+		EventType:   ev.EventType,
+		Category:    ev.Category,
+		Dimensions:  ev.Dimensions,
+		Properties:  ev.Properties,
+		Timestamp:   ev.Timestamp,
+	})
+}
+
+type jsonLinesSpan struct {
+	Type string `json:"type"`
+	*trace.Span
+}
+
+func (r *jsonLinesRecorder) recordSpan(span *trace.Span) {
+	r.write(&jsonLinesSpan{
+		Type: "span",
+		Span: span,
+	})
+}
+
+type jsonLinesDimProps struct {
+	Type       string            `json:"type"`
+	Dimension  string            `json:"dimension"`
+	Value      string            `json:"value"`
+	Properties map[string]string `json:"properties"`
+	Tags       map[string]bool   `json:"tags"`
+}
+
+func (r *jsonLinesRecorder) recordDimProps(dimProps *types.DimProperties) {
+	r.write(&jsonLinesDimProps{
+		Type:       "dimensionProperties",
+		Dimension:  dimProps.Name,
+		Value:      dimProps.Value,
+		Properties: dimProps.Properties,
+		Tags:       dimProps.Tags,
+	})
+}
@@ -0,0 +1,106 @@
+package writer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/signalfx/golib/datapoint"
+	"github.com/signalfx/golib/event"
+	"github.com/signalfx/signalfx-agent/internal/core/common/dpmeta"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONLinesDatapointRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jsonlines")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "out.jsonl")
+	r, err := newJSONLinesRecorder(path, 0, 0)
+	assert.Nil(t, err)
+
+	dp := datapoint.New("cpu.utilization", map[string]string{"host": "a"}, datapoint.NewIntValue(5), datapoint.Counter, time.Unix(10, 0).UTC())
+	dp.Meta = map[interface{}]interface{}{dpmeta.MonitorTypeMeta: "cpu"}
+	r.recordDatapoint(dp)
+	assert.Nil(t, r.Close())
+
+	content, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+
+	var record map[string]interface{}
+	assert.Nil(t, json.Unmarshal(content, &record))
+	assert.Equal(t, map[string]interface{}{
+		"type":        "datapoint",
+		"monitorType": "cpu",
+		"metric":      "cpu.utilization",
+		"metricType":  "cumulative_counter",
+		"dimensions":  map[string]interface{}{"host": "a"},
+		"value":       float64(5),
+		"timestamp":   "1970-01-01T00:00:10Z",
+	}, record)
+}
+
+func TestSendEventsStripsMeta(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jsonlines")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	sw := newRetryTestWriter()
+	defer sw.cancel()
+
+	path := filepath.Join(dir, "out.jsonl")
+	sw.jsonLines, err = newJSONLinesRecorder(path, 0, 0)
+	assert.Nil(t, err)
+
+	ev := event.NewWithProperties("restart", event.AGENT, map[string]string{"host": "a"},
+		map[string]interface{}{
+			"reason":                   "oom",
+			dpmeta.MonitorTypeMeta:     "docker-container-stats",
+			dpmeta.NotHostSpecificMeta: true,
+		}, time.Unix(10, 0).UTC())
+	sw.sendEvents([]*event.Event{ev})
+	assert.Nil(t, sw.jsonLines.Close())
+
+	// The meta values must not be sent to any destination
+	assert.Equal(t, map[string]interface{}{"reason": "oom"}, ev.Properties)
+
+	content, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+
+	var record map[string]interface{}
+	assert.Nil(t, json.Unmarshal(content, &record))
+	assert.Equal(t, "docker-container-stats", record["monitorType"])
+	assert.Equal(t, map[string]interface{}{"reason": "oom"}, record["properties"])
+}
+
+func TestJSONLinesRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jsonlines")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "out.jsonl")
+	r, err := newJSONLinesRecorder(path, 10, 2)
+	assert.Nil(t, err)
+
+	for _, s := range []string{"aaaaaa", "bbbbbb", "cccccc", "dddddd"} {
+		r.write(s)
+	}
+	assert.Nil(t, r.Close())
+
+	read := func(p string) string {
+		content, err := ioutil.ReadFile(p)
+		assert.Nil(t, err)
+		return strings.TrimSpace(string(content))
+	}
+
+	assert.Equal(t, `"dddddd"`, read(path))
+	assert.Equal(t, `"cccccc"`, read(path+".1"))
+	assert.Equal(t, `"bbbbbb"`, read(path+".2"))
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+}
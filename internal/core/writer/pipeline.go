@@ -0,0 +1,129 @@
+package writer
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// sendPipeline limits the requests and the amount of data pending for one
+// kind of data (datapoints or trace spans) going to a single destination.
+// Each destination has its own so that one that is slow or failing can't hold
+// up the others.
+type sendPipeline struct {
+	// These are first so that they are 64-bit aligned for atomic access
+	inFlight       int64
+	requestsActive int64
+	dropped        int64
+
+	// What is being sent and where, for logs
+	kind string
+	dest string
+
+	// This acts like a semaphore if a request goroutine pushes into it before
+	// making the request and pulling out of it when the request is done.
+	// Pushes will block if there are more than the max number of outstanding
+	// requests.
+	reqSema       chan struct{}
+	shedRequests  chan struct{}
+	shedCompleted chan struct{}
+}
+
+func newSendPipeline(kind string, dest *destination, maxRequests int) *sendPipeline {
+	return &sendPipeline{
+		kind:          kind,
+		dest:          dest.String(),
+		reqSema:       make(chan struct{}, maxRequests),
+		shedRequests:  make(chan struct{}),
+		shedCompleted: make(chan struct{}),
+	}
+}
+
+// reserve counts n new items as in flight.  If there are already more than
+// maxInFlight, pending batches are shed to make room for the new ones, which
+// is an imperfect process that could unnecessarily shed batches if the count
+// in flight drops below the threshold between the check and the shedding, but
+// it does guarantee that the number in flight doesn't exceed maxInFlight plus
+// a batch.  If nothing can be shed (e.g. because everything is in the middle
+// of being sent), the new items are counted as dropped and false is returned.
+func (p *sendPipeline) reserve(n int, maxInFlight uint) bool {
+	if atomic.LoadInt64(&p.inFlight) > int64(maxInFlight) && !p.attemptToShedPending(maxInFlight) {
+		log.Warnf("Dropping %d new %s for %s due to excess %s in flight", n, p.kind, p.dest, p.kind)
+		atomic.AddInt64(&p.dropped, int64(n))
+		return false
+	}
+	atomic.AddInt64(&p.inFlight, int64(n))
+	return true
+}
+
+func (p *sendPipeline) attemptToShedPending(maxInFlight uint) bool {
+	for {
+		select {
+		case p.shedRequests <- struct{}{}:
+			// There is always a 1:1 correspondance between the request and
+			// completion signal.  This guarantees that inFlight is
+			// decremented for the shed batch.
+			<-p.shedCompleted
+			if atomic.LoadInt64(&p.inFlight) < int64(maxInFlight) {
+				return true
+			}
+		default:
+			// No outstanding requests are available to shed so nothing to do
+			return false
+		}
+	}
+}
+
+// send sends a batch of n items that was reserved with reserve by calling try
+// with a request slot held until it says not to retry.  try returns how long
+// to wait before retrying and whether to retry.  The request slot is not held
+// during retry backoffs.  While waiting for a request slot or for a backoff to
+// finish, the batch can be shed to make room for newer data, in which case
+// send returns false.  It also returns false if ctx is done first.
+func (p *sendPipeline) send(ctx context.Context, n int, try func(attempt int) (time.Duration, bool)) bool {
+	// Returns false if the batch was shed or ctx is done while waiting on
+	// either of the given channels.
+	wait := func(backoff <-chan time.Time, semaPush chan<- struct{}) bool {
+		select {
+		case <-ctx.Done():
+			atomic.AddInt64(&p.inFlight, -int64(n))
+			return false
+		case <-p.shedRequests:
+			atomic.AddInt64(&p.dropped, int64(n))
+			log.Warnf("Aborting pending request with %d %s to %s due to excess %s in flight",
+				n, p.kind, p.dest, p.kind)
+			atomic.AddInt64(&p.inFlight, -int64(n))
+			p.shedCompleted <- struct{}{}
+			return false
+		case <-backoff:
+		case semaPush <- struct{}{}:
+		}
+		return true
+	}
+
+	var backoff <-chan time.Time
+	for attempt := 1; ; attempt++ {
+		// Wait until any backoff from a failed attempt is over and then if
+		// there are more than the max outstanding requests, but respond to
+		// requests to shed outstanding requests and the writer shutdown.
+		if backoff != nil && !wait(backoff, nil) {
+			return false
+		}
+		if !wait(nil, p.reqSema) {
+			return false
+		}
+
+		atomic.AddInt64(&p.requestsActive, 1)
+		delay, retry := try(attempt)
+		<-p.reqSema
+		atomic.AddInt64(&p.requestsActive, -1)
+
+		if !retry {
+			atomic.AddInt64(&p.inFlight, -int64(n))
+			return true
+		}
+		backoff = time.After(delay)
+	}
+}
@@ -0,0 +1,324 @@
+package writer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/signalfx/golib/datapoint"
+	"github.com/signalfx/signalfx-agent/internal/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// Dimension name collisions happen on every datapoint with the same
+// dimensions, so only log them occasionally
+var promLogger = utils.NewThrottledLogger(log.StandardLogger(), 5*time.Minute)
+
+// Prometheus metric type values from the remote-write MetricMetadata message
+const (
+	promMetricTypeCounter = 1
+	promMetricTypeGauge   = 2
+)
+
+// remoteWriteSink sends datapoints to a Prometheus remote-write endpoint,
+// such as Cortex or Thanos receive.
+type remoteWriteSink struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func newRemoteWriteSink(url string, headers map[string]string, timeout time.Duration, transport http.RoundTripper) *remoteWriteSink {
+	return &remoteWriteSink{
+		url:     url,
+		headers: headers,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   timeout,
+		},
+	}
+}
+
+// remoteWriteError is returned when the remote-write endpoint responds with a
+// non-2xx status code.
+type remoteWriteError struct {
+	StatusCode   int
+	ResponseBody string
+}
+
+func (e *remoteWriteError) Error() string {
+	return fmt.Sprintf("remote write endpoint returned status %d: %s", e.StatusCode, e.ResponseBody)
+}
+
+type promLabel struct {
+	name  string
+	value string
+}
+
+type promSample struct {
+	value     float64
+	timestamp int64
+}
+
+type promTimeSeries struct {
+	labels  []promLabel
+	samples []promSample
+}
+
+// AddDatapoints converts the datapoints to a remote-write request and sends
+// it synchronously.
+func (s *remoteWriteSink) AddDatapoints(ctx context.Context, dps []*datapoint.Datapoint) error {
+	series, metricTypes := toPromTimeSeries(dps, time.Now())
+	if len(series) == 0 {
+		return nil
+	}
+
+	body := snappy.Encode(nil, marshalWriteRequest(series, metricTypes))
+
+	req, err := http.NewRequest("POST", s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	req.Header.Set("User-Agent", "signalfx-agent")
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return &remoteWriteError{
+			StatusCode:   resp.StatusCode,
+			ResponseBody: string(respBody),
+		}
+	}
+	return nil
+}
+
+// toPromTimeSeries groups datapoints into Prometheus time series, keyed by
+// their sanitized metric name and labels.  Datapoints with string values are
+// skipped since Prometheus only supports numeric samples.  The second return
+// value maps metric names to their Prometheus metric type.
+func toPromTimeSeries(dps []*datapoint.Datapoint, now time.Time) ([]*promTimeSeries, map[string]int) {
+	var out []*promTimeSeries
+	seriesByKey := map[string]*promTimeSeries{}
+	metricTypes := map[string]int{}
+
+	for _, dp := range dps {
+		var value float64
+		switch v := dp.Value.(type) {
+		case datapoint.IntValue:
+			value = float64(v.Int())
+		case datapoint.FloatValue:
+			value = v.Float()
+		default:
+			continue
+		}
+
+		name := sanitizePromName(dp.Metric)
+		// Cumulative counters map directly onto Prometheus counters.  Delta
+		// counters have no equivalent so they are sent as gauges.
+		if dp.MetricType == datapoint.Counter {
+			metricTypes[name] = promMetricTypeCounter
+		} else {
+			metricTypes[name] = promMetricTypeGauge
+		}
+
+		labels := append(dimensionsToPromLabels(dp.Dimensions), promLabel{name: "__name__", value: name})
+		sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+
+		ts := dp.Timestamp
+		if ts.IsZero() {
+			ts = now
+		}
+
+		key := promSeriesKey(labels)
+		series := seriesByKey[key]
+		if series == nil {
+			series = &promTimeSeries{labels: labels}
+			seriesByKey[key] = series
+			out = append(out, series)
+		}
+		series.samples = append(series.samples, promSample{
+			value:     value,
+			timestamp: ts.UnixNano() / int64(time.Millisecond),
+		})
+	}
+
+	// Samples within a series must be in time order
+	for _, series := range out {
+		samples := series.samples
+		sort.SliceStable(samples, func(i, j int) bool { return samples[i].timestamp < samples[j].timestamp })
+	}
+
+	return out, metricTypes
+}
+
+// Converts dimensions to labels with sanitized names.  Different dimension
+// names can sanitize to the same label name (e.g. `host.name` and
+// `host_name`), and since a series can't have duplicate labels only one of
+// them is kept.  The dimension whose name is already a valid label name wins,
+// otherwise the one whose name sorts first.
+func dimensionsToPromLabels(dims map[string]string) []promLabel {
+	keys := make([]string, 0, len(dims))
+	for k, v := range dims {
+		if v != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	labels := make([]promLabel, 0, len(keys)+1)
+	// The dimension that each label came from, by label name
+	sourceKeys := make(map[string]string, len(keys))
+	indexes := make(map[string]int, len(keys))
+
+	for _, k := range keys {
+		labelName := sanitizePromLabelName(k)
+		existing, collides := sourceKeys[labelName]
+		if !collides {
+			sourceKeys[labelName] = k
+			indexes[labelName] = len(labels)
+			labels = append(labels, promLabel{name: labelName, value: dims[k]})
+			continue
+		}
+
+		kept := existing
+		if k == labelName {
+			kept = k
+			sourceKeys[labelName] = k
+			labels[indexes[labelName]].value = dims[k]
+		}
+		promLogger.ThrottledError(fmt.Sprintf("Dimensions %s and %s both map to the Prometheus label %s, only sending %s",
+			existing, k, labelName, kept))
+	}
+	return labels
+}
+
+func promSeriesKey(labels []promLabel) string {
+	var b strings.Builder
+	for _, l := range labels {
+		b.WriteString(l.name)
+		b.WriteByte(0)
+		b.WriteString(l.value)
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+// sanitizePromName makes the metric name conform to
+// [a-zA-Z_:][a-zA-Z0-9_:]* by replacing invalid characters with underscores.
+func sanitizePromName(name string) string {
+	return sanitizeProm(name, true)
+}
+
+// sanitizePromLabelName makes the label name conform to
+// [a-zA-Z_][a-zA-Z0-9_]* by replacing invalid characters with underscores.
+func sanitizePromLabelName(name string) string {
+	return sanitizeProm(name, false)
+}
+
+func sanitizeProm(name string, allowColon bool) string {
+	if name == "" {
+		return "_"
+	}
+
+	out := []byte(name)
+	for i, c := range out {
+		valid := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') ||
+			(allowColon && c == ':') || (i > 0 && c >= '0' && c <= '9')
+		if !valid {
+			out[i] = '_'
+		}
+	}
+	return string(out)
+}
+
+// Field numbers and wire types from the Prometheus remote-write protobuf
+// definitions (prompb/remote.proto and prompb/types.proto).  The messages are
+// simple enough that it isn't worth pulling in the generated Go code for them.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+func protoTag(field int, wireType int) uint64 {
+	return uint64(field<<3 | wireType)
+}
+
+func marshalWriteRequest(series []*promTimeSeries, metricTypes map[string]int) []byte {
+	req := proto.NewBuffer(nil)
+	for _, s := range series {
+		// WriteRequest.timeseries = 1
+		_ = req.EncodeVarint(protoTag(1, wireBytes))
+		_ = req.EncodeRawBytes(marshalTimeSeries(s))
+	}
+
+	names := make([]string, 0, len(metricTypes))
+	for name := range metricTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		md := proto.NewBuffer(nil)
+		// MetricMetadata.type = 1
+		_ = md.EncodeVarint(protoTag(1, wireVarint))
+		_ = md.EncodeVarint(uint64(metricTypes[name]))
+		// MetricMetadata.metric_family_name = 2
+		_ = md.EncodeVarint(protoTag(2, wireBytes))
+		_ = md.EncodeStringBytes(name)
+
+		// WriteRequest.metadata = 3
+		_ = req.EncodeVarint(protoTag(3, wireBytes))
+		_ = req.EncodeRawBytes(md.Bytes())
+	}
+	return req.Bytes()
+}
+
+func marshalTimeSeries(s *promTimeSeries) []byte {
+	buf := proto.NewBuffer(nil)
+	for _, l := range s.labels {
+		label := proto.NewBuffer(nil)
+		// Label.name = 1, Label.value = 2
+		_ = label.EncodeVarint(protoTag(1, wireBytes))
+		_ = label.EncodeStringBytes(l.name)
+		_ = label.EncodeVarint(protoTag(2, wireBytes))
+		_ = label.EncodeStringBytes(l.value)
+
+		// TimeSeries.labels = 1
+		_ = buf.EncodeVarint(protoTag(1, wireBytes))
+		_ = buf.EncodeRawBytes(label.Bytes())
+	}
+	for _, smp := range s.samples {
+		sample := proto.NewBuffer(nil)
+		// Sample.value = 1, Sample.timestamp = 2
+		_ = sample.EncodeVarint(protoTag(1, wireFixed64))
+		_ = sample.EncodeFixed64(math.Float64bits(smp.value))
+		_ = sample.EncodeVarint(protoTag(2, wireVarint))
+		_ = sample.EncodeVarint(uint64(smp.timestamp))
+
+		// TimeSeries.samples = 2
+		_ = buf.EncodeVarint(protoTag(2, wireBytes))
+		_ = buf.EncodeRawBytes(sample.Bytes())
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,124 @@
+package writer
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/signalfx/golib/datapoint"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSanitizePromNames(t *testing.T) {
+	assert.Equal(t, "cpu_utilization", sanitizePromName("cpu.utilization"))
+	assert.Equal(t, "_xx:yy", sanitizePromName("1xx:yy"))
+	assert.Equal(t, "xx_yy", sanitizePromLabelName("xx:yy"))
+	assert.Equal(t, "kubernetes_pod_name", sanitizePromLabelName("kubernetes-pod/name"))
+	assert.Equal(t, "_", sanitizePromName(""))
+}
+
+func TestToPromTimeSeries(t *testing.T) {
+	now := time.Unix(1000, 0)
+	dps := []*datapoint.Datapoint{
+		datapoint.New("requests.total", map[string]string{"host": "a", "empty": ""}, datapoint.NewIntValue(5), datapoint.Counter, now.Add(time.Second)),
+		datapoint.New("requests.total", map[string]string{"host": "a"}, datapoint.NewIntValue(3), datapoint.Counter, now),
+		datapoint.New("cpu", map[string]string{"host.name": "b"}, datapoint.NewFloatValue(1.5), datapoint.Gauge, time.Time{}),
+		datapoint.New("version", nil, datapoint.NewStringValue("1.0"), datapoint.Gauge, now),
+	}
+
+	series, types := toPromTimeSeries(dps, now)
+	assert.Len(t, series, 2)
+
+	assert.Equal(t, []promLabel{{"__name__", "requests_total"}, {"host", "a"}}, series[0].labels)
+	assert.Equal(t, []promSample{{3, 1000000}, {5, 1001000}}, series[0].samples)
+
+	assert.Equal(t, []promLabel{{"__name__", "cpu"}, {"host_name", "b"}}, series[1].labels)
+	assert.Equal(t, []promSample{{1.5, 1000000}}, series[1].samples)
+
+	assert.Equal(t, map[string]int{
+		"requests_total": promMetricTypeCounter,
+		"cpu":            promMetricTypeGauge,
+	}, types)
+}
+
+func TestDimensionsToPromLabelsCollisions(t *testing.T) {
+	for _, tc := range []struct {
+		dims     map[string]string
+		expected []promLabel
+	}{
+		{
+			// The dimension that is already a valid label name wins
+			map[string]string{"host.name": "a", "host_name": "b"},
+			[]promLabel{{"host_name", "b"}},
+		},
+		{
+			map[string]string{"host_name": "b", "host-name": "c", "host.name": "a"},
+			[]promLabel{{"host_name", "b"}},
+		},
+		{
+			// Otherwise the first one in sorted order wins
+			map[string]string{"host.name": "a", "host-name": "c"},
+			[]promLabel{{"host_name", "c"}},
+		},
+		{
+			map[string]string{"host.name": "a", "region": "us"},
+			[]promLabel{{"host_name", "a"}, {"region", "us"}},
+		},
+	} {
+		// Map iteration order is random so check a few times
+		for i := 0; i < 10; i++ {
+			labels := dimensionsToPromLabels(tc.dims)
+			sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+			assert.Equal(t, tc.expected, labels, "%v", tc.dims)
+		}
+	}
+}
+
+func TestRemoteWriteSinkTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	sink := newRemoteWriteSink(server.URL, nil, 50*time.Millisecond, http.DefaultTransport)
+	err := sink.AddDatapoints(context.Background(), []*datapoint.Datapoint{
+		datapoint.New("cpu", nil, datapoint.NewIntValue(1), datapoint.Gauge, time.Unix(1, 0)),
+	})
+	assert.NotNil(t, err)
+}
+
+func TestRemoteWriteSink(t *testing.T) {
+	var received []byte
+	var headers http.Header
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		headers = r.Header
+		body, _ := ioutil.ReadAll(r.Body)
+		received, _ = snappy.Decode(nil, body)
+		rw.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	sink := newRemoteWriteSink(server.URL, map[string]string{"X-Scope-OrgID": "team1"}, 5*time.Second, http.DefaultTransport)
+	dps := []*datapoint.Datapoint{
+		datapoint.New("cpu", nil, datapoint.NewIntValue(1), datapoint.Gauge, time.Unix(1, 0)),
+	}
+
+	assert.Nil(t, sink.AddDatapoints(context.Background(), dps))
+	assert.Equal(t, "snappy", headers.Get("Content-Encoding"))
+	assert.Equal(t, "team1", headers.Get("X-Scope-OrgID"))
+
+	series, types := toPromTimeSeries(dps, time.Now())
+	assert.Equal(t, marshalWriteRequest(series, types), received)
+
+	status = http.StatusServiceUnavailable
+	err := sink.AddDatapoints(context.Background(), dps)
+	if assert.IsType(t, &remoteWriteError{}, err) {
+		assert.Equal(t, http.StatusServiceUnavailable, err.(*remoteWriteError).StatusCode)
+	}
+}
@@ -2,6 +2,7 @@ package writer
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -15,12 +16,23 @@ import (
 
 	lru "github.com/hashicorp/golang-lru"
 	"github.com/pkg/errors"
+	"github.com/signalfx/golib/sfxclient"
 	"github.com/signalfx/signalfx-agent/internal/core/config"
 	"github.com/signalfx/signalfx-agent/internal/core/propfilters"
 	"github.com/signalfx/signalfx-agent/internal/monitors/types"
-	"github.com/signalfx/signalfx-agent/internal/utils"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
 )
 
+// A dimension property update that is waiting to be sent
+type pendingDimProps struct {
+	dimProps *types.DimProperties
+	// How many times sending this update has failed
+	failures int
+	// Don't send it before this time, used for retry backoff
+	notBefore time.Time
+}
+
 type dimensionPropertyClient struct {
 	client *http.Client
 	Token  string
@@ -28,14 +40,28 @@ type dimensionPropertyClient struct {
 	// Keeps track of what has been synced so we don't do unnecessary syncs
 	history *lru.Cache
 	lock    sync.Mutex
+	// Updates that are waiting to be sent, keyed by dimension so that
+	// multiple updates to the same dimension are coalesced into one request
+	pending map[types.Dimension]*pendingDimProps
+	// The order that dimensions were queued in
+	queue []types.Dimension
+	// The updates that currently have a request in flight, by dimension.
+	// Another update to the same dimension won't be sent until the request
+	// completes so that updates are applied in order.
+	inFlight    map[types.Dimension]*types.DimProperties
+	queueNotify chan struct{}
 	// A buffered channel that mimics a semaphore when performance isn't that
 	// big of a deal.
-	reqSema           chan struct{}
-	TotalPropUpdates  int64
-	PropertyFilterSet *propfilters.FilterSet
+	reqSema     chan struct{}
+	limiter     *rate.Limiter
+	retryPolicy *retryPolicy
+
+	TotalPropUpdates       int64
+	TotalPropUpdatesFailed int64
+	PropertyFilterSet      *propfilters.FilterSet
 }
 
-func newDimensionPropertyClient(conf *config.WriterConfig) (*dimensionPropertyClient, error) {
+func newDimensionPropertyClient(conf *config.WriterConfig, apiURL *url.URL, token string) (*dimensionPropertyClient, error) {
 	history, err := lru.New(int(conf.PropertiesHistorySize))
 	if err != nil {
 		panic("could not create properties history cache: " + err.Error())
@@ -46,9 +72,17 @@ func newDimensionPropertyClient(conf *config.WriterConfig) (*dimensionPropertyCl
 		return nil, err
 	}
 
+	retryPolicy := newRetryPolicy(conf)
+	retryPolicy.maxAttempts = conf.PropertiesMaxAttempts
+
+	limit := rate.Inf
+	if *conf.PropertiesMaxRequestsPerSecond > 0 {
+		limit = rate.Limit(*conf.PropertiesMaxRequestsPerSecond)
+	}
+
 	return &dimensionPropertyClient{
-		Token:  conf.SignalFxAccessToken,
-		APIURL: conf.ParsedAPIURL(),
+		Token:  token,
+		APIURL: apiURL,
 		client: &http.Client{
 			Timeout: 10 * time.Second,
 			Transport: &http.Transport{
@@ -65,69 +99,312 @@ func newDimensionPropertyClient(conf *config.WriterConfig) (*dimensionPropertyCl
 			},
 		},
 		history:           history,
+		pending:           make(map[types.Dimension]*pendingDimProps),
+		inFlight:          make(map[types.Dimension]*types.DimProperties),
+		queueNotify:       make(chan struct{}, 1),
 		reqSema:           make(chan struct{}, int(conf.PropertiesMaxRequests)),
+		limiter:           rate.NewLimiter(limit, 1),
+		retryPolicy:       retryPolicy,
 		PropertyFilterSet: propFilters,
 	}, nil
 }
 
-// SetPropertiesOnDimension will set custom properties on a specific dimension
-// value.  It will wipe out any description or tags on the dimension.  There is
-// no retry logic here so any failures are terminal.
-func (dpc *dimensionPropertyClient) SetPropertiesOnDimension(dimProps *types.DimProperties) error {
-	filteredDimProps := &(*dimProps)
-
-	filteredDimProps = dpc.PropertyFilterSet.FilterDimProps(filteredDimProps)
+// EnqueueProperties queues the properties and tags to be set on a specific
+// dimension value.  Each update is taken to be the full set of properties and
+// tags that the agent manages on the dimension.  Updates are sent as patches,
+// so properties and tags that were set by something other than the agent are
+// left alone, but those that were in the previous update from the agent and
+// are missing from this one are removed.  The previous update is only known
+// if the dimension is still in the properties history (see
+// `propertiesHistorySize`).  Properties with a blank value are also removed
+// from the dimension, as are tags with a false value.  If an update for the
+// same dimension is already waiting to be sent, the two are merged.
+func (dpc *dimensionPropertyClient) EnqueueProperties(dimProps *types.DimProperties) {
+	filteredDimProps := dpc.PropertyFilterSet.FilterDimProps(dimProps)
 	if filteredDimProps == nil {
-		return nil
+		return
 	}
 
-	if !dpc.isDuplicate(filteredDimProps) {
-		dpc.reqSema <- struct{}{}
-		err := dpc.doReq(filteredDimProps.Name, filteredDimProps.Value,
-			filteredDimProps.Properties, filteredDimProps.Tags)
-		<-dpc.reqSema
-		if err != nil {
-			return err
-		}
-		// Add it to the history only after successfully propagated.  This
-		// could lead to some duplicates if there are multiple concurrent calls
-		// for the same dim props, but that's ok.
-		dpc.history.Add(filteredDimProps.Dimension, filteredDimProps)
-		atomic.AddInt64(&dpc.TotalPropUpdates, int64(1))
+	dpc.lock.Lock()
+	defer dpc.lock.Unlock()
+
+	prev := dpc.lastKnown(filteredDimProps.Dimension)
+	if isDuplicate(prev, filteredDimProps) {
+		return
+	}
+	filteredDimProps = withRemovals(prev, filteredDimProps)
+
+	if p, ok := dpc.pending[filteredDimProps.Dimension]; ok {
+		p.dimProps = mergeDimProps(p.dimProps, filteredDimProps)
+		return
+	}
+
+	dpc.pending[filteredDimProps.Dimension] = &pendingDimProps{dimProps: filteredDimProps}
+	dpc.queue = append(dpc.queue, filteredDimProps.Dimension)
+
+	select {
+	case dpc.queueNotify <- struct{}{}:
+	default:
+	}
+}
+
+// Returns the latest update to the dimension that is queued, in flight or
+// was synced, or nil if there isn't one.  The lock must be held.
+func (dpc *dimensionPropertyClient) lastKnown(dim types.Dimension) *types.DimProperties {
+	if p, ok := dpc.pending[dim]; ok {
+		return withoutRemovals(p.dimProps)
+	}
+	if dimProps, ok := dpc.inFlight[dim]; ok {
+		return withoutRemovals(dimProps)
+	}
+	if prev, ok := dpc.history.Get(dim); ok {
+		return prev.(*types.DimProperties)
 	}
 	return nil
 }
 
-// isDuplicate returns true if the exact same dimension properties have been
-// synced before in the recent past.
-func (dpc *dimensionPropertyClient) isDuplicate(dimProps *types.DimProperties) bool {
-	prev, ok := dpc.history.Get(dimProps.Dimension)
-	return ok && reflect.DeepEqual(prev.(*types.DimProperties), dimProps)
+// isDuplicate returns true if dimProps would not change anything since the
+// previous update, which has no removals in it
+func isDuplicate(prev, dimProps *types.DimProperties) bool {
+	if prev == nil {
+		return false
+	}
+	set := withoutRemovals(dimProps)
+	// Removals are always sent in case something else set them
+	if len(set.Properties) != len(dimProps.Properties) || len(set.Tags) != len(dimProps.Tags) {
+		return false
+	}
+	return reflect.DeepEqual(prev, set)
+}
+
+// Returns a copy of dimProps that also removes the properties and tags that
+// prev set but that dimProps doesn't have
+func withRemovals(prev, dimProps *types.DimProperties) *types.DimProperties {
+	if prev == nil {
+		return dimProps
+	}
+	out := mergeDimProps(&types.DimProperties{}, dimProps)
+	for k := range prev.Properties {
+		if _, ok := out.Properties[k]; !ok {
+			out.Properties[k] = ""
+		}
+	}
+	for tag := range prev.Tags {
+		if _, ok := out.Tags[tag]; !ok {
+			out.Tags[tag] = false
+		}
+	}
+	return out
 }
 
-func (dpc *dimensionPropertyClient) doReq(key, value string, props map[string]string, tags map[string]bool) error {
+// Returns a copy of dimProps without the properties and tags that it removes,
+// which is what the dimension has once it is synced
+func withoutRemovals(dimProps *types.DimProperties) *types.DimProperties {
+	out := &types.DimProperties{
+		Dimension:  dimProps.Dimension,
+		Properties: make(map[string]string, len(dimProps.Properties)),
+		Tags:       make(map[string]bool, len(dimProps.Tags)),
+	}
+	for k, v := range dimProps.Properties {
+		if v != "" {
+			out.Properties[k] = v
+		}
+	}
+	for tag, set := range dimProps.Tags {
+		if set {
+			out.Tags[tag] = true
+		}
+	}
+	return out
+}
+
+// Returns a new DimProperties with the properties and tags of both, with
+// those in newer taking precedence.
+func mergeDimProps(older, newer *types.DimProperties) *types.DimProperties {
+	out := &types.DimProperties{
+		Dimension:  newer.Dimension,
+		Properties: make(map[string]string, len(older.Properties)+len(newer.Properties)),
+		Tags:       make(map[string]bool, len(older.Tags)+len(newer.Tags)),
+	}
+	for _, dp := range []*types.DimProperties{older, newer} {
+		for k, v := range dp.Properties {
+			out.Properties[k] = v
+		}
+		for k, v := range dp.Tags {
+			out.Tags[k] = v
+		}
+	}
+	return out
+}
+
+// PendingCount returns the number of dimensions with updates waiting to be
+// sent
+func (dpc *dimensionPropertyClient) PendingCount() int {
+	dpc.lock.Lock()
+	defer dpc.lock.Unlock()
+	return len(dpc.pending)
+}
+
+// Start sending queued updates until the context is cancelled
+func (dpc *dimensionPropertyClient) Start(ctx context.Context) {
+	go func() {
+		for {
+			next, wait := dpc.nextReady(time.Now())
+			if next == nil {
+				var timer <-chan time.Time
+				if wait > 0 {
+					timer = time.After(wait)
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-dpc.queueNotify:
+				case <-timer:
+				}
+				continue
+			}
+
+			if err := dpc.limiter.Wait(ctx); err != nil {
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case dpc.reqSema <- struct{}{}:
+			}
+
+			go func() {
+				err := dpc.doReq(ctx, next.dimProps)
+				<-dpc.reqSema
+				dpc.handleResult(next, err)
+			}()
+		}
+	}()
+}
+
+// nextReady takes the oldest queued update that can be sent now out of the
+// queue.  If there isn't one, it returns how long until a backed off update
+// becomes ready, or 0 if there are none.
+func (dpc *dimensionPropertyClient) nextReady(now time.Time) (*pendingDimProps, time.Duration) {
+	dpc.lock.Lock()
+	defer dpc.lock.Unlock()
+
+	var wait time.Duration
+	for i, dim := range dpc.queue {
+		if _, ok := dpc.inFlight[dim]; ok {
+			continue
+		}
+		p := dpc.pending[dim]
+		if until := p.notBefore.Sub(now); until > 0 {
+			if wait == 0 || until < wait {
+				wait = until
+			}
+			continue
+		}
+
+		dpc.queue = append(dpc.queue[:i], dpc.queue[i+1:]...)
+		delete(dpc.pending, dim)
+		dpc.inFlight[dim] = p.dimProps
+		return p, 0
+	}
+	return nil, wait
+}
+
+func (dpc *dimensionPropertyClient) handleResult(p *pendingDimProps, err error) {
+	dpc.lock.Lock()
+	defer dpc.lock.Unlock()
+
+	dim := p.dimProps.Dimension
+	delete(dpc.inFlight, dim)
+
+	if err == nil {
+		// Add it to the history only after successfully propagated.
+		dpc.history.Add(dim, withoutRemovals(p.dimProps))
+		atomic.AddInt64(&dpc.TotalPropUpdates, int64(1))
+	} else {
+		p.failures++
+		backoff, retry := dpc.retryPolicy.nextBackoff(p.failures, err, 0)
+		if !retry {
+			log.WithFields(log.Fields{
+				"error":    err,
+				"dimProps": p.dimProps,
+			}).Error("Could not sync properties to dimension")
+			atomic.AddInt64(&dpc.TotalPropUpdatesFailed, int64(1))
+		} else {
+			log.WithFields(log.Fields{
+				"error":     err,
+				"dimension": dim,
+				"attempt":   p.failures,
+				"backoff":   backoff,
+			}).Warn("Failed to sync properties to dimension, retrying")
+
+			p.notBefore = time.Now().Add(backoff)
+			// A newer update to the same dimension may have been queued
+			// while this one was in flight, in which case it takes
+			// precedence.
+			if newer, ok := dpc.pending[dim]; ok {
+				newer.dimProps = mergeDimProps(p.dimProps, newer.dimProps)
+				newer.failures = p.failures
+				newer.notBefore = p.notBefore
+			} else {
+				dpc.pending[dim] = p
+				dpc.queue = append(dpc.queue, dim)
+			}
+		}
+	}
+
+	// Anything else queued for this dimension can go now
+	select {
+	case dpc.queueNotify <- struct{}{}:
+	default:
+	}
+}
+
+func (dpc *dimensionPropertyClient) doReq(ctx context.Context, dimProps *types.DimProperties) error {
+	props := make(map[string]interface{}, len(dimProps.Properties))
+	for k, v := range dimProps.Properties {
+		if v == "" {
+			// A null value removes the property
+			props[k] = nil
+		} else {
+			props[k] = v
+		}
+	}
+
+	tagsToAdd := []string{}
+	tagsToRemove := []string{}
+	for tag, set := range dimProps.Tags {
+		if set {
+			tagsToAdd = append(tagsToAdd, tag)
+		} else {
+			tagsToRemove = append(tagsToRemove, tag)
+		}
+	}
+
 	json, err := json.Marshal(map[string]interface{}{
-		"key":              key,
-		"value":            value,
 		"customProperties": props,
-		"tags":             utils.StringSetToSlice(tags),
+		"tags":             tagsToAdd,
+		"tagsToRemove":     tagsToRemove,
 	})
 	if err != nil {
 		return err
 	}
 
-	url, err := dpc.APIURL.Parse(fmt.Sprintf("/v2/dimension/%s/%s", key, value))
+	reqURL, err := dpc.APIURL.Parse(fmt.Sprintf("/v2/dimension/%s/%s/_/sfxagent",
+		url.PathEscape(dimProps.Name), url.PathEscape(dimProps.Value)))
 	if err != nil {
-		return errors.Wrapf(err, "Could not construct dimension property PUT URL with %s / %s", key, value)
+		return errors.Wrapf(err, "Could not construct dimension property PATCH URL with %s / %s", dimProps.Name, dimProps.Value)
 	}
 
 	req, err := http.NewRequest(
-		"PUT",
-		url.String(),
+		"PATCH",
+		reqURL.String(),
 		bytes.NewReader(json))
 	if err != nil {
 		return err
 	}
+	req = req.WithContext(ctx)
 
 	req.Header.Add("Content-Type", "application/json")
 	req.Header.Add("X-SF-TOKEN", dpc.Token)
@@ -141,8 +418,11 @@ func (dpc *dimensionPropertyClient) doReq(key, value string, props map[string]st
 
 	if resp.StatusCode != 200 {
 		body, _ := ioutil.ReadAll(resp.Body)
-		return fmt.Errorf("Unexpected status code %d on response %s", resp.StatusCode, string(body))
+		return sfxclient.SFXAPIError{
+			StatusCode:   resp.StatusCode,
+			ResponseBody: string(body),
+		}
 	}
 
-	return err
+	return nil
 }
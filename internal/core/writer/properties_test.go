@@ -0,0 +1,158 @@
+package writer
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/signalfx/golib/pointer"
+	"github.com/signalfx/signalfx-agent/internal/core/config"
+	"github.com/signalfx/signalfx-agent/internal/monitors/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type propRequest struct {
+	method string
+	path   string
+	body   map[string]interface{}
+}
+
+func TestDimensionPropertyClient(t *testing.T) {
+	var lock sync.Mutex
+	var requests []propRequest
+	failuresLeft := 1
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		if failuresLeft > 0 {
+			failuresLeft--
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		var body map[string]interface{}
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&body))
+		requests = append(requests, propRequest{method: r.Method, path: r.URL.Path, body: body})
+	}))
+	defer server.Close()
+
+	apiURL, _ := url.Parse(server.URL)
+	dpc, err := newDimensionPropertyClient(&config.WriterConfig{
+		PropertiesMaxRequests:          2,
+		PropertiesHistorySize:          10,
+		PropertiesMaxRequestsPerSecond: pointer.Float64(100),
+		PropertiesMaxAttempts:          3,
+		RetryInitialBackoff:            10 * time.Millisecond,
+		RetryMaxBackoff:                50 * time.Millisecond,
+		RetryStatusCodes:               []int{503},
+	}, apiURL, "token")
+	assert.Nil(t, err)
+
+	dim := types.Dimension{Name: "host", Value: "a b"}
+	dpc.EnqueueProperties(&types.DimProperties{
+		Dimension:  dim,
+		Properties: map[string]string{"role": "web", "zone": "1"},
+		Tags:       map[string]bool{"old": false},
+	})
+	dpc.EnqueueProperties(&types.DimProperties{
+		Dimension:  dim,
+		Properties: map[string]string{"role": "web", "zone": ""},
+		Tags:       map[string]bool{"new": true},
+	})
+	assert.Equal(t, 1, dpc.PendingCount())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dpc.Start(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt64(&dpc.TotalPropUpdates) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	assert.Len(t, requests, 1)
+	assert.Equal(t, "PATCH", requests[0].method)
+	assert.Equal(t, "/v2/dimension/host/a b/_/sfxagent", requests[0].path)
+	assert.Equal(t, map[string]interface{}{
+		"customProperties": map[string]interface{}{"role": "web", "zone": nil},
+		"tags":             []interface{}{"new"},
+		"tagsToRemove":     []interface{}{"old"},
+	}, requests[0].body)
+}
+
+func TestDimensionPropertyClientRemovesDroppedKeys(t *testing.T) {
+	var lock sync.Mutex
+	var bodies []map[string]interface{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		var body map[string]interface{}
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&body))
+		bodies = append(bodies, body)
+	}))
+	defer server.Close()
+
+	apiURL, _ := url.Parse(server.URL)
+	dpc, err := newDimensionPropertyClient(&config.WriterConfig{
+		PropertiesMaxRequests:          1,
+		PropertiesHistorySize:          10,
+		PropertiesMaxRequestsPerSecond: pointer.Float64(0),
+		PropertiesMaxAttempts:          1,
+	}, apiURL, "token")
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dpc.Start(ctx)
+
+	syncProps := func(dimProps *types.DimProperties) {
+		expected := atomic.LoadInt64(&dpc.TotalPropUpdates) + 1
+		dpc.EnqueueProperties(dimProps)
+
+		deadline := time.Now().Add(5 * time.Second)
+		for atomic.LoadInt64(&dpc.TotalPropUpdates) < expected && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	dim := types.Dimension{Name: "kubernetes_pod_uid", Value: "abc"}
+	syncProps(&types.DimProperties{
+		Dimension:  dim,
+		Properties: map[string]string{"app": "web", "tier": "frontend"},
+		Tags:       map[string]bool{"canary": true},
+	})
+	syncProps(&types.DimProperties{
+		Dimension:  dim,
+		Properties: map[string]string{"app": "web"},
+	})
+
+	// Nothing has changed so this isn't sent
+	dpc.EnqueueProperties(&types.DimProperties{
+		Dimension:  dim,
+		Properties: map[string]string{"app": "web"},
+	})
+	assert.Equal(t, 0, dpc.PendingCount())
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	assert.Len(t, bodies, 2)
+	assert.Equal(t, map[string]interface{}{
+		"customProperties": map[string]interface{}{"app": "web", "tier": nil},
+		"tags":             []interface{}{},
+		"tagsToRemove":     []interface{}{"canary"},
+	}, bodies[1])
+}
@@ -0,0 +1,122 @@
+package writer
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/signalfx/golib/trace"
+	"github.com/signalfx/signalfx-agent/internal/core/config"
+	"github.com/signalfx/signalfx-agent/internal/utils/filter"
+)
+
+// Matches the parts of a SQL statement that are of interest when obfuscating
+// it.  Backquoted identifiers and positional parameters are matched so that
+// they can be left alone, and double-quoted values so that they can be left
+// alone if they are identifiers.  Everything else that matches is a literal.
+var sqlTokenRegexp = regexp.MustCompile(`'(?:[^']|'')*'|"(?:[^"]|"")*"|` + "`[^`]*`" +
+	`|\$\d+|\b0[xX][0-9a-fA-F]+\b|\b\d+(?:\.\d+)?(?:[eE][-+]?\d+)?\b|\.\d+\b`)
+
+type tagMask struct {
+	// Nil if the values of all tags should be masked
+	tagFilter   filter.StringFilter
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// spanRedactor removes sensitive information from span tags
+type spanRedactor struct {
+	deleteFilter filter.StringFilter
+	masks        []tagMask
+	sqlFilter    filter.StringFilter
+	// Whether double-quoted values in SQL are identifiers instead of strings
+	sqlDoubleQuotedIdentifiers bool
+	urlQueryFilter             filter.StringFilter
+}
+
+func newSpanRedactor(conf *config.SpanRedactionConfig) (*spanRedactor, error) {
+	sr := &spanRedactor{sqlDoubleQuotedIdentifiers: conf.SQLDoubleQuotesAreIdentifiers}
+
+	var err error
+	for _, f := range []struct {
+		filter *filter.StringFilter
+		keys   []string
+	}{
+		{&sr.deleteFilter, conf.TagsToDelete},
+		{&sr.sqlFilter, conf.ObfuscateSQLTags},
+		{&sr.urlQueryFilter, conf.StripURLQueryTags},
+	} {
+		if len(f.keys) == 0 {
+			continue
+		}
+		if *f.filter, err = filter.NewBasicStringFilter(f.keys); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, m := range conf.MaskValues {
+		mask := tagMask{replacement: *m.Replacement}
+		if len(m.Tags) > 0 {
+			if mask.tagFilter, err = filter.NewBasicStringFilter(m.Tags); err != nil {
+				return nil, err
+			}
+		}
+		if mask.pattern, err = regexp.Compile(m.Pattern); err != nil {
+			return nil, err
+		}
+		sr.masks = append(sr.masks, mask)
+	}
+
+	return sr, nil
+}
+
+// redact modifies the tags of the span in place
+func (sr *spanRedactor) redact(span *trace.Span) {
+	for k, v := range span.Tags {
+		if sr.deleteFilter != nil && sr.deleteFilter.Matches(k) {
+			delete(span.Tags, k)
+			continue
+		}
+
+		for _, m := range sr.masks {
+			if m.tagFilter == nil || m.tagFilter.Matches(k) {
+				v = m.pattern.ReplaceAllString(v, m.replacement)
+			}
+		}
+
+		if sr.sqlFilter != nil && sr.sqlFilter.Matches(k) {
+			v = obfuscateSQL(v, sr.sqlDoubleQuotedIdentifiers)
+		}
+
+		if sr.urlQueryFilter != nil && sr.urlQueryFilter.Matches(k) {
+			v = stripURLQuery(v)
+		}
+
+		span.Tags[k] = v
+	}
+}
+
+// obfuscateSQL replaces the string and numeric literals in a SQL statement
+// with `?`.  Double-quoted values are only kept if doubleQuotedIdentifiers is
+// true, since some databases (e.g. MySQL) treat them as strings.
+func obfuscateSQL(statement string, doubleQuotedIdentifiers bool) string {
+	return sqlTokenRegexp.ReplaceAllStringFunc(statement, func(token string) string {
+		switch token[0] {
+		case '`', '$':
+			return token
+		case '"':
+			if doubleQuotedIdentifiers {
+				return token
+			}
+		}
+		return "?"
+	})
+}
+
+// stripURLQuery removes the query string and fragment from a URL.  This
+// doesn't parse the URL so that it also works on relative URLs and paths.
+func stripURLQuery(u string) string {
+	if i := strings.IndexAny(u, "?#"); i != -1 {
+		return u[:i]
+	}
+	return u
+}
@@ -0,0 +1,64 @@
+package writer
+
+import (
+	"testing"
+
+	"github.com/signalfx/golib/pointer"
+	"github.com/signalfx/golib/trace"
+	"github.com/signalfx/signalfx-agent/internal/core/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObfuscateSQL(t *testing.T) {
+	for in, out := range map[string]string{
+		"SELECT * FROM users WHERE id = 5":                             "SELECT * FROM users WHERE id = ?",
+		"SELECT * FROM t1 WHERE name = 'O''Brien' AND score > 1.5e3":   "SELECT * FROM t1 WHERE name = ? AND score > ?",
+		`SELECT "col2" FROM ` + "`tbl3`" + ` WHERE a IN (1, 0xFF, .5)`: `SELECT "col2" FROM ` + "`tbl3`" + ` WHERE a IN (?, ?, ?)`,
+		"UPDATE users SET email = $1 WHERE id = $2":                    "UPDATE users SET email = $1 WHERE id = $2",
+	} {
+		assert.Equal(t, out, obfuscateSQL(in, true))
+	}
+
+	t.Run("Double-quoted values are strings by default", func(t *testing.T) {
+		for in, out := range map[string]string{
+			`SELECT * FROM users WHERE email = "bob@example.com"`: "SELECT * FROM users WHERE email = ?",
+			`SELECT * FROM t WHERE a = "say ""hi""" AND b = 2`:    "SELECT * FROM t WHERE a = ? AND b = ?",
+		} {
+			assert.Equal(t, out, obfuscateSQL(in, false))
+		}
+	})
+}
+
+func TestSpanRedactor(t *testing.T) {
+	sr, err := newSpanRedactor(&config.SpanRedactionConfig{
+		TagsToDelete: []string{"user.*"},
+		MaskValues: []config.SpanTagMask{
+			{Pattern: `[\w.]+@[\w.]+`, Replacement: pointer.String("<email>")},
+			{Tags: []string{"session"}, Pattern: `.+`, Replacement: pointer.String("")},
+		},
+		ObfuscateSQLTags:  []string{"db.statement"},
+		StripURLQueryTags: []string{"http.url"},
+	})
+	assert.Nil(t, err)
+
+	span := &trace.Span{
+		Tags: map[string]string{
+			"user.id":      "123",
+			"user.name":    "bob",
+			"message":      "sent to bob@example.com",
+			"session":      "abc123",
+			"db.statement": "SELECT * FROM users WHERE email = 'bob@example.com'",
+			"http.url":     "https://example.com/reset?token=abc#top",
+			"http.method":  "GET",
+		},
+	}
+	sr.redact(span)
+
+	assert.Equal(t, map[string]string{
+		"message":      "sent to <email>",
+		"session":      "",
+		"db.statement": "SELECT * FROM users WHERE email = ?",
+		"http.url":     "https://example.com/reset",
+		"http.method":  "GET",
+	}, span.Tags)
+}
@@ -0,0 +1,151 @@
+package writer
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/signalfx/golib/sfxclient"
+	"github.com/signalfx/signalfx-agent/internal/core/config"
+	log "github.com/sirupsen/logrus"
+)
+
+type retryAfterKeyType int
+
+const retryAfterKey retryAfterKeyType = 0
+
+// Holds the value of the Retry-After header of the last response received on
+// a request made with a context created by withRetryAfterCapture.
+type retryAfterHolder struct {
+	after time.Duration
+}
+
+func withRetryAfterCapture(ctx context.Context) (context.Context, *retryAfterHolder) {
+	holder := &retryAfterHolder{}
+	return context.WithValue(ctx, retryAfterKey, holder), holder
+}
+
+// retryAfterTransport wraps another transport and records the Retry-After
+// header of responses, since the sfxclient sink does not expose the response
+// headers on errors.
+type retryAfterTransport struct {
+	http.RoundTripper
+}
+
+func (t *retryAfterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err == nil {
+		if holder, ok := req.Context().Value(retryAfterKey).(*retryAfterHolder); ok {
+			holder.after = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		}
+	}
+	return resp, err
+}
+
+// The Retry-After header can be either a number of seconds or an HTTP date
+func parseRetryAfter(val string, now time.Time) time.Duration {
+	if val == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(val); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(val); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+type retryPolicy struct {
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	retryableCodes map[int]bool
+}
+
+func newRetryPolicy(conf *config.WriterConfig) *retryPolicy {
+	codes := make(map[int]bool, len(conf.RetryStatusCodes))
+	for _, c := range conf.RetryStatusCodes {
+		codes[c] = true
+	}
+	return &retryPolicy{
+		maxAttempts:    conf.RetryMaxAttempts,
+		initialBackoff: conf.RetryInitialBackoff,
+		maxBackoff:     conf.RetryMaxBackoff,
+		retryableCodes: codes,
+	}
+}
+
+func (rp *retryPolicy) isRetryable(err error) bool {
+	switch e := err.(type) {
+	case sfxclient.SFXAPIError:
+		return rp.retryableCodes[e.StatusCode]
+	case *remoteWriteError:
+		return rp.retryableCodes[e.StatusCode]
+	}
+	// Anything else is a failure to connect or to read the response, which
+	// are generally transient.
+	return true
+}
+
+// nextBackoff returns how long to wait before making attempt number
+// `attempt + 1`, given that attempt number `attempt` (starting at 1) failed
+// with err.  The second return value is false if no further attempt should be
+// made.
+func (rp *retryPolicy) nextBackoff(attempt int, err error, retryAfter time.Duration) (time.Duration, bool) {
+	if attempt >= rp.maxAttempts || !rp.isRetryable(err) {
+		return 0, false
+	}
+
+	backoff := rp.initialBackoff
+	for i := 1; i < attempt && backoff < rp.maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > rp.maxBackoff {
+		backoff = rp.maxBackoff
+	}
+
+	if retryAfter > backoff {
+		// Don't hold onto the data for longer than the user allowed for,
+		// even if the server asks us to.
+		if retryAfter > rp.maxBackoff {
+			return 0, false
+		}
+		backoff = retryAfter
+	}
+	return backoff, true
+}
+
+// sendWithRetries calls send until it succeeds or the retry policy is
+// exhausted, waiting between attempts as dictated by the policy.  The error of
+// the last attempt is returned.
+func (sw *SignalFxWriter) sendWithRetries(kind string, count int, send func(context.Context) error) error {
+	for attempt := 1; ; attempt++ {
+		ctx, retryAfter := withRetryAfterCapture(context.Background())
+		err := send(ctx)
+		if err == nil {
+			return nil
+		}
+
+		backoff, ok := sw.retryPolicy.nextBackoff(attempt, err, retryAfter.after)
+		if !ok {
+			return err
+		}
+
+		log.WithFields(log.Fields{
+			"error":   err,
+			"attempt": attempt,
+			"backoff": backoff,
+		}).Warnf("Failed to send %d %s, retrying", count, kind)
+
+		select {
+		case <-sw.ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+	}
+}
@@ -0,0 +1,392 @@
+package writer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/signalfx/golib/datapoint"
+	"github.com/signalfx/golib/sfxclient"
+	"github.com/signalfx/golib/trace"
+	"github.com/signalfx/signalfx-agent/internal/core/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		val      string
+		expected time.Duration
+	}{
+		{"", 0},
+		{"0", 0},
+		{"30", 30 * time.Second},
+		{"-5", 0},
+		{"Fri, 01 Mar 2019 12:01:30 GMT", 90 * time.Second},
+		// Dates in the past mean no wait
+		{"Fri, 01 Mar 2019 11:59:00 GMT", 0},
+		{"soon", 0},
+		{"1.5", 0},
+	} {
+		assert.Equal(t, tc.expected, parseRetryAfter(tc.val, now), "Retry-After: %q", tc.val)
+	}
+}
+
+func testRetryPolicy() *retryPolicy {
+	return newRetryPolicy(&config.WriterConfig{
+		RetryMaxAttempts:    5,
+		RetryInitialBackoff: time.Second,
+		RetryMaxBackoff:     5 * time.Second,
+		RetryStatusCodes:    []int{429, 503},
+	})
+}
+
+func TestIsRetryable(t *testing.T) {
+	rp := testRetryPolicy()
+
+	for _, tc := range []struct {
+		err       error
+		retryable bool
+	}{
+		{sfxclient.SFXAPIError{StatusCode: 429}, true},
+		{sfxclient.SFXAPIError{StatusCode: 503}, true},
+		{sfxclient.SFXAPIError{StatusCode: 400}, false},
+		{sfxclient.SFXAPIError{StatusCode: 401}, false},
+		{sfxclient.SFXAPIError{StatusCode: 413}, false},
+		{&remoteWriteError{StatusCode: 503}, true},
+		{&remoteWriteError{StatusCode: 400}, false},
+		// Connection errors are assumed to be transient
+		{errors.New("connection refused"), true},
+	} {
+		assert.Equal(t, tc.retryable, rp.isRetryable(tc.err), "%v", tc.err)
+	}
+}
+
+func TestNextBackoff(t *testing.T) {
+	rp := testRetryPolicy()
+	unavailable := sfxclient.SFXAPIError{StatusCode: 503}
+
+	for _, tc := range []struct {
+		desc       string
+		attempt    int
+		err        error
+		retryAfter time.Duration
+		expected   time.Duration
+		retry      bool
+	}{
+		{"first retry uses initial backoff", 1, unavailable, 0, time.Second, true},
+		{"doubles", 2, unavailable, 0, 2 * time.Second, true},
+		{"doubles again", 3, unavailable, 0, 4 * time.Second, true},
+		{"capped at max backoff", 4, unavailable, 0, 5 * time.Second, true},
+		{"gives up after max attempts", 5, unavailable, 0, 0, false},
+		{"non-retryable status", 1, sfxclient.SFXAPIError{StatusCode: 400}, 0, 0, false},
+		{"longer Retry-After is honored", 1, unavailable, 3 * time.Second, 3 * time.Second, true},
+		{"shorter Retry-After is ignored", 3, unavailable, time.Second, 4 * time.Second, true},
+		{"Retry-After beyond max backoff gives up", 1, unavailable, time.Minute, 0, false},
+	} {
+		backoff, retry := rp.nextBackoff(tc.attempt, tc.err, tc.retryAfter)
+		assert.Equal(t, tc.retry, retry, tc.desc)
+		assert.Equal(t, tc.expected, backoff, tc.desc)
+	}
+}
+
+func newRetryTestWriter() *SignalFxWriter {
+	sw := &SignalFxWriter{
+		conf: &config.WriterConfig{MaxTraceSpansInFlight: 1, MaxDatapointsInFlight: 1},
+		retryPolicy: newRetryPolicy(&config.WriterConfig{
+			RetryMaxAttempts:    3,
+			RetryInitialBackoff: time.Millisecond,
+			RetryMaxBackoff:     10 * time.Millisecond,
+			RetryStatusCodes:    []int{503},
+		}),
+		dpBufferPool: &sync.Pool{
+			New: func() interface{} { return []*datapoint.Datapoint{} },
+		},
+		spanBufferPool: &sync.Pool{
+			New: func() interface{} { return []*trace.Span{} },
+		},
+	}
+	sw.ctx, sw.cancel = context.WithCancel(context.Background())
+	return sw
+}
+
+func TestSendWithRetries(t *testing.T) {
+	sw := newRetryTestWriter()
+	defer sw.cancel()
+
+	for _, tc := range []struct {
+		desc     string
+		errs     []error
+		attempts int
+		failed   bool
+	}{
+		{"succeeds first time", nil, 1, false},
+		{"succeeds after retry", []error{sfxclient.SFXAPIError{StatusCode: 503}}, 2, false},
+		{"stops on non-retryable status", []error{sfxclient.SFXAPIError{StatusCode: 400}}, 1, true},
+		{"gives up after max attempts", []error{
+			sfxclient.SFXAPIError{StatusCode: 503},
+			sfxclient.SFXAPIError{StatusCode: 503},
+			sfxclient.SFXAPIError{StatusCode: 503},
+		}, 3, true},
+	} {
+		attempts := 0
+		err := sw.sendWithRetries("datapoints", 1, func(ctx context.Context) error {
+			attempts++
+			if attempts <= len(tc.errs) {
+				return tc.errs[attempts-1]
+			}
+			return nil
+		})
+		assert.Equal(t, tc.attempts, attempts, tc.desc)
+		assert.Equal(t, tc.failed, err != nil, tc.desc)
+	}
+}
+
+// Returns a destination that sends spans to a server that responds with the
+// given statuses in order, and 200 once they run out
+func newSpanTestDestination(statuses ...int) (*destination, *int64, func()) {
+	var requests int64
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&requests, 1)
+		if int(n) <= len(statuses) {
+			rw.WriteHeader(statuses[n-1])
+			return
+		}
+		rw.Write([]byte(`"OK"`))
+	}))
+
+	client := sfxclient.NewHTTPSink()
+	client.TraceEndpoint = server.URL
+	return &destination{client: client, sendTraceSpans: true}, &requests, server.Close
+}
+
+// Sets the writer's destinations, giving them the pipelines that New would,
+// each with a single request slot
+func setTestDestinations(sw *SignalFxWriter, dests ...*destination) {
+	for _, dest := range dests {
+		dest.dpPipeline = newSendPipeline("datapoints", dest, 1)
+		dest.spanPipeline = newSendPipeline("trace spans", dest, 1)
+	}
+	sw.destinations = dests
+}
+
+// Waits until nothing is in flight to any of the destinations
+func waitForDestinations(t *testing.T, sw *SignalFxWriter) {
+	deadline := time.Now().Add(5 * time.Second)
+	for _, dest := range sw.destinations {
+		for atomic.LoadInt64(&dest.dpPipeline.inFlight) > 0 || atomic.LoadInt64(&dest.spanPipeline.inFlight) > 0 {
+			if time.Now().After(deadline) {
+				t.Fatalf("Data to %s was never sent", dest)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+func TestSendSpansRetries(t *testing.T) {
+	t.Run("Retries retryable failures", func(t *testing.T) {
+		sw := newRetryTestWriter()
+		defer sw.cancel()
+
+		dest, requests, cleanup := newSpanTestDestination(503)
+		defer cleanup()
+		setTestDestinations(sw, dest)
+
+		sw.sendSpans([]*trace.Span{{ID: "1"}})
+		waitForDestinations(t, sw)
+
+		assert.Equal(t, int64(2), atomic.LoadInt64(requests))
+		assert.Equal(t, int64(1), atomic.LoadInt64(&dest.traceSpansSent))
+		assert.Equal(t, int64(0), atomic.LoadInt64(&dest.traceSpansFailedToSend))
+	})
+
+	t.Run("Gives up on non-retryable failures", func(t *testing.T) {
+		sw := newRetryTestWriter()
+		defer sw.cancel()
+
+		dest, requests, cleanup := newSpanTestDestination(400)
+		defer cleanup()
+		setTestDestinations(sw, dest)
+
+		sw.sendSpans([]*trace.Span{{ID: "1"}})
+		waitForDestinations(t, sw)
+
+		assert.Equal(t, int64(1), atomic.LoadInt64(requests))
+		assert.Equal(t, int64(0), atomic.LoadInt64(&dest.traceSpansSent))
+		assert.Equal(t, int64(1), atomic.LoadInt64(&dest.traceSpansFailedToSend))
+	})
+
+	t.Run("Only retries the destinations that failed", func(t *testing.T) {
+		sw := newRetryTestWriter()
+		defer sw.cancel()
+
+		failing, failingRequests, cleanup := newSpanTestDestination(503, 503)
+		defer cleanup()
+		healthy, healthyRequests, cleanup2 := newSpanTestDestination()
+		defer cleanup2()
+		setTestDestinations(sw, failing, healthy)
+
+		sw.sendSpans([]*trace.Span{{ID: "1"}})
+		waitForDestinations(t, sw)
+
+		assert.Equal(t, int64(3), atomic.LoadInt64(failingRequests))
+		assert.Equal(t, int64(1), atomic.LoadInt64(healthyRequests))
+		assert.Equal(t, int64(1), atomic.LoadInt64(&failing.traceSpansSent))
+		assert.Equal(t, int64(1), atomic.LoadInt64(&healthy.traceSpansSent))
+	})
+}
+
+func TestShedPendingSpans(t *testing.T) {
+	sw := newRetryTestWriter()
+	defer sw.cancel()
+
+	dest, requests, cleanup := newSpanTestDestination()
+	defer cleanup()
+	setTestDestinations(sw, dest)
+	pipeline := dest.spanPipeline
+
+	// The only request slot is taken so the batch has to wait
+	pipeline.reqSema <- struct{}{}
+	sw.sendSpans([]*trace.Span{{ID: "1"}, {ID: "2"}})
+
+	// Wait for the pending batch to be sheddable
+	deadline := time.Now().Add(5 * time.Second)
+	for !pipeline.attemptToShedPending(sw.conf.MaxTraceSpansInFlight) {
+		if time.Now().After(deadline) {
+			t.Fatal("Pending spans were never shed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	assert.Equal(t, int64(0), atomic.LoadInt64(requests))
+	assert.Equal(t, int64(2), atomic.LoadInt64(&pipeline.dropped))
+	assert.Equal(t, int64(0), atomic.LoadInt64(&pipeline.inFlight))
+
+	// Nothing left to shed
+	assert.False(t, pipeline.attemptToShedPending(sw.conf.MaxTraceSpansInFlight))
+}
+
+// Sends a batch of datapoints the way that listenForDatapoints does and waits
+// for it to be sent
+func sendTestDatapoints(t *testing.T, sw *SignalFxWriter, dps []*datapoint.Datapoint) {
+	sw.sendDatapoints(dps)
+	waitForDestinations(t, sw)
+}
+
+func TestSendDatapointsRetries(t *testing.T) {
+	t.Run("Only retries the destinations that failed", func(t *testing.T) {
+		sw := newRetryTestWriter()
+		defer sw.cancel()
+
+		failingSink := &fakeDatapointSink{errs: []error{sfxclient.SFXAPIError{StatusCode: 503}}}
+		healthySink := &fakeDatapointSink{}
+		setTestDestinations(sw,
+			&destination{name: "failing", dpSink: failingSink, sendDatapoints: true},
+			&destination{name: "healthy", dpSink: healthySink, sendDatapoints: true})
+
+		sendTestDatapoints(t, sw, testDatapoints("cpu.utilization"))
+
+		assert.Equal(t, 2, failingSink.attemptCount())
+		assert.Equal(t, 1, healthySink.attemptCount())
+		assert.Equal(t, int64(1), atomic.LoadInt64(&sw.destinations[0].dpsSent))
+		assert.Equal(t, int64(1), atomic.LoadInt64(&sw.destinations[1].dpsSent))
+	})
+
+	t.Run("Gives up on non-retryable failures", func(t *testing.T) {
+		sw := newRetryTestWriter()
+		defer sw.cancel()
+
+		sink := &fakeDatapointSink{errs: []error{sfxclient.SFXAPIError{StatusCode: 400}}}
+		setTestDestinations(sw, &destination{dpSink: sink, sendDatapoints: true})
+
+		sendTestDatapoints(t, sw, testDatapoints("cpu.utilization"))
+
+		assert.Equal(t, 1, sink.attemptCount())
+		assert.Equal(t, int64(0), atomic.LoadInt64(&sw.destinations[0].dpsSent))
+	})
+}
+
+func TestDatapointRequestSlotIsFreeDuringBackoff(t *testing.T) {
+	sw := newRetryTestWriter()
+	defer sw.cancel()
+	sw.retryPolicy.initialBackoff = time.Minute
+	sw.retryPolicy.maxBackoff = time.Minute
+
+	sink := &fakeDatapointSink{errs: []error{sfxclient.SFXAPIError{StatusCode: 503}}}
+	setTestDestinations(sw, &destination{dpSink: sink, sendDatapoints: true})
+	dest := sw.destinations[0]
+
+	sw.sendDatapoints(testDatapoints("first"))
+
+	// Wait for the first batch to fail and start backing off
+	deadline := time.Now().Add(5 * time.Second)
+	for sink.attemptCount() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("First batch was never sent")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	sw.sendDatapoints(testDatapoints("second"))
+
+	for atomic.LoadInt64(&dest.dpsSent) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Second batch was held up by the first one's backoff")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The first batch gives up waiting when the writer shuts down
+	sw.cancel()
+	waitForDestinations(t, sw)
+}
+
+func TestShedPendingDatapoints(t *testing.T) {
+	sw := newRetryTestWriter()
+	defer sw.cancel()
+
+	sink := &fakeDatapointSink{}
+	setTestDestinations(sw, &destination{dpSink: sink, sendDatapoints: true})
+	pipeline := sw.destinations[0].dpPipeline
+
+	// The only request slot is taken so the batch has to wait
+	pipeline.reqSema <- struct{}{}
+	sw.sendDatapoints(append(testDatapoints("a"), testDatapoints("b")...))
+
+	// Wait for the pending batch to be sheddable
+	deadline := time.Now().Add(5 * time.Second)
+	for !pipeline.attemptToShedPending(sw.conf.MaxDatapointsInFlight) {
+		if time.Now().After(deadline) {
+			t.Fatal("Pending datapoints were never shed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	assert.Equal(t, 0, sink.attemptCount())
+	assert.Equal(t, int64(2), atomic.LoadInt64(&pipeline.dropped))
+	assert.Equal(t, int64(0), atomic.LoadInt64(&pipeline.inFlight))
+}
+
+func TestDropNewDatapointsWhenNothingCanBeShed(t *testing.T) {
+	sw := newRetryTestWriter()
+	defer sw.cancel()
+
+	sink := &fakeDatapointSink{}
+	setTestDestinations(sw, &destination{dpSink: sink, sendDatapoints: true})
+	pipeline := sw.destinations[0].dpPipeline
+
+	// More than the max is in the middle of being sent, so there is nothing
+	// pending to shed
+	atomic.StoreInt64(&pipeline.inFlight, 2)
+	sw.sendDatapoints(testDatapoints("a"))
+
+	assert.Equal(t, int64(1), atomic.LoadInt64(&pipeline.dropped))
+	assert.Equal(t, int64(2), atomic.LoadInt64(&pipeline.inFlight))
+	assert.Equal(t, 0, sink.attemptCount())
+}
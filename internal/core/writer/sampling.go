@@ -0,0 +1,138 @@
+package writer
+
+import (
+	"hash/fnv"
+	"sync"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/signalfx/golib/trace"
+	"github.com/signalfx/signalfx-agent/internal/core/config"
+	"github.com/signalfx/signalfx-agent/internal/utils/filter"
+	"golang.org/x/time/rate"
+)
+
+// How many rate limited sampling decisions to remember so that later spans
+// of the same trace get the same decision
+const samplingDecisionCacheSize = 10000
+
+type samplingRule struct {
+	serviceFilter filter.StringFilter
+	tagFilter     filter.StringMapFilter
+	percentage    float64
+
+	maxTracesPerSecond float64
+	// Rate limiters keyed by service name
+	limiters map[string]*rate.Limiter
+}
+
+func (r *samplingRule) matches(serviceName string, tags map[string]string) bool {
+	return (r.serviceFilter == nil || r.serviceFilter.Matches(serviceName)) &&
+		(r.tagFilter == nil || r.tagFilter.Matches(tags))
+}
+
+// spanSampler decides which trace spans are sent on by the writer
+type spanSampler struct {
+	sync.Mutex
+	percentage float64
+	rules      []*samplingRule
+	// Rate limited decisions keyed by trace id and service
+	decisions *lru.Cache
+}
+
+func newSpanSampler(conf *config.TraceSamplingConfig) (*spanSampler, error) {
+	decisions, err := lru.New(samplingDecisionCacheSize)
+	if err != nil {
+		return nil, err
+	}
+
+	ss := &spanSampler{
+		percentage: *conf.SamplingPercentage,
+		decisions:  decisions,
+	}
+
+	for _, rc := range conf.Rules {
+		rule := &samplingRule{
+			percentage:         *rc.SamplingPercentage,
+			maxTracesPerSecond: rc.MaxTracesPerSecond,
+			limiters:           map[string]*rate.Limiter{},
+		}
+		if rc.ServiceName != "" {
+			rule.serviceFilter, err = filter.NewBasicStringFilter([]string{rc.ServiceName})
+			if err != nil {
+				return nil, err
+			}
+		}
+		if len(rc.Tags) > 0 {
+			rule.tagFilter, err = filter.NewStringMapFilter(rc.Tags)
+			if err != nil {
+				return nil, err
+			}
+		}
+		ss.rules = append(ss.rules, rule)
+	}
+	return ss, nil
+}
+
+// shouldKeep returns whether the span should be sent
+func (ss *spanSampler) shouldKeep(span *trace.Span) bool {
+	var serviceName string
+	if span.LocalEndpoint != nil && span.LocalEndpoint.ServiceName != nil {
+		serviceName = *span.LocalEndpoint.ServiceName
+	}
+
+	for _, rule := range ss.rules {
+		if !rule.matches(serviceName, span.Tags) {
+			continue
+		}
+		if !traceIDInPercentage(span.TraceID, rule.percentage) {
+			return false
+		}
+		if rule.maxTracesPerSecond > 0 {
+			return ss.allowedByRateLimit(rule, serviceName, span.TraceID)
+		}
+		return true
+	}
+
+	return traceIDInPercentage(span.TraceID, ss.percentage)
+}
+
+// Each trace is only counted once against the rate limit and all of its spans
+// get the same decision, as long as the decision is still in the cache.
+func (ss *spanSampler) allowedByRateLimit(rule *samplingRule, serviceName, traceID string) bool {
+	key := traceID + "\x00" + serviceName
+
+	ss.Lock()
+	defer ss.Unlock()
+
+	if keep, ok := ss.decisions.Get(key); ok {
+		return keep.(bool)
+	}
+
+	limiter := rule.limiters[serviceName]
+	if limiter == nil {
+		burst := int(rule.maxTracesPerSecond)
+		if burst < 1 {
+			burst = 1
+		}
+		limiter = rate.NewLimiter(rate.Limit(rule.maxTracesPerSecond), burst)
+		rule.limiters[serviceName] = limiter
+	}
+
+	keep := limiter.Allow()
+	ss.decisions.Add(key, keep)
+	return keep
+}
+
+// Maps the trace id onto a number in [0, 100) so that the same trace id
+// always gets the same result
+func traceIDInPercentage(traceID string, percentage float64) bool {
+	if percentage >= 100 {
+		return true
+	}
+	if percentage <= 0 {
+		return false
+	}
+	h := fnv.New64a()
+	h.Write([]byte(traceID))
+	return float64(h.Sum64()%10000)/100 < percentage
+}
@@ -0,0 +1,120 @@
+package writer
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/signalfx/golib/datapoint"
+	"github.com/signalfx/golib/pointer"
+	"github.com/signalfx/golib/trace"
+	"github.com/signalfx/signalfx-agent/internal/core/config"
+	"github.com/signalfx/signalfx-agent/internal/core/writer/tracetracker"
+	"github.com/stretchr/testify/assert"
+)
+
+func makeSpan(traceID, service string, tags map[string]string) *trace.Span {
+	return &trace.Span{
+		TraceID:       traceID,
+		ID:            traceID + "-1",
+		LocalEndpoint: &trace.Endpoint{ServiceName: pointer.String(service)},
+		Tags:          tags,
+	}
+}
+
+func TestSpanSampler(t *testing.T) {
+	t.Run("Probabilistic sampling is consistent per trace", func(t *testing.T) {
+		ss, err := newSpanSampler(&config.TraceSamplingConfig{SamplingPercentage: pointer.Float64(25)})
+		assert.Nil(t, err)
+
+		kept := 0
+		for i := 0; i < 10000; i++ {
+			traceID := fmt.Sprintf("%016x", i)
+			keep := ss.shouldKeep(makeSpan(traceID, "api", nil))
+			assert.Equal(t, keep, ss.shouldKeep(makeSpan(traceID, "db", nil)))
+			if keep {
+				kept++
+			}
+		}
+		assert.InDelta(t, 2500, kept, 250)
+	})
+
+	t.Run("Rules override the default percentage", func(t *testing.T) {
+		ss, err := newSpanSampler(&config.TraceSamplingConfig{
+			SamplingPercentage: pointer.Float64(0),
+			Rules: []config.TraceSamplingRule{
+				{Tags: map[string]string{"error": "true"}, SamplingPercentage: pointer.Float64(100)},
+				{ServiceName: "checkout*", SamplingPercentage: pointer.Float64(100)},
+			},
+		})
+		assert.Nil(t, err)
+
+		assert.True(t, ss.shouldKeep(makeSpan("1", "api", map[string]string{"error": "true"})))
+		assert.True(t, ss.shouldKeep(makeSpan("2", "checkout-svc", nil)))
+		assert.False(t, ss.shouldKeep(makeSpan("3", "api", nil)))
+	})
+
+	t.Run("A 0% rule drops everything it matches", func(t *testing.T) {
+		ss, err := newSpanSampler(&config.TraceSamplingConfig{
+			SamplingPercentage: pointer.Float64(100),
+			Rules: []config.TraceSamplingRule{
+				{ServiceName: "healthcheck", SamplingPercentage: pointer.Float64(0)},
+			},
+		})
+		assert.Nil(t, err)
+
+		for i := 0; i < 1000; i++ {
+			traceID := fmt.Sprintf("%016x", i)
+			assert.False(t, ss.shouldKeep(makeSpan(traceID, "healthcheck", nil)))
+			assert.True(t, ss.shouldKeep(makeSpan(traceID, "api", nil)))
+		}
+	})
+
+	t.Run("Rate limits traces per service", func(t *testing.T) {
+		ss, err := newSpanSampler(&config.TraceSamplingConfig{
+			SamplingPercentage: pointer.Float64(100),
+			Rules: []config.TraceSamplingRule{
+				{SamplingPercentage: pointer.Float64(100), MaxTracesPerSecond: 2},
+			},
+		})
+		assert.Nil(t, err)
+
+		assert.True(t, ss.shouldKeep(makeSpan("1", "api", nil)))
+		assert.True(t, ss.shouldKeep(makeSpan("2", "api", nil)))
+		assert.False(t, ss.shouldKeep(makeSpan("3", "api", nil)))
+		// Other spans of an already kept trace are kept
+		assert.True(t, ss.shouldKeep(makeSpan("1", "api", nil)))
+		// Each service has its own limit
+		assert.True(t, ss.shouldKeep(makeSpan("3", "db", nil)))
+	})
+}
+
+func TestSpanMetricsIncludeSampledOutSpans(t *testing.T) {
+	sw := newRetryTestWriter()
+	defer sw.cancel()
+
+	var err error
+	sw.spanSampler, err = newSpanSampler(&config.TraceSamplingConfig{SamplingPercentage: pointer.Float64(10)})
+	assert.Nil(t, err)
+	sw.spanMetrics = tracetracker.NewSpanMetricsAggregator(nil, nil, nil, time.Minute, 0)
+
+	var buf []*trace.Span
+	for i := 0; i < 1000; i++ {
+		span := makeSpan(fmt.Sprintf("%016x", i), "api", nil)
+		span.Name = pointer.String("GET /users")
+		buf = append(buf, span)
+	}
+	sent := sw.processSpans(buf)
+
+	assert.InDelta(t, 100, len(sent), 50)
+	assert.Equal(t, int64(len(sent)), sw.traceSpansSampled)
+	assert.Equal(t, int64(1000-len(sent)), sw.traceSpansSampledOut)
+
+	var count datapoint.Value
+	for _, dp := range sw.spanMetrics.Datapoints() {
+		if dp.Metric == "spans.count" {
+			count = dp.Value
+		}
+	}
+	assert.Equal(t, datapoint.NewIntValue(1000), count)
+}
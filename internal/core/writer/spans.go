@@ -3,6 +3,7 @@ package writer
 import (
 	"context"
 	"sync/atomic"
+	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/signalfx/golib/datapoint"
@@ -14,13 +15,12 @@ import (
 )
 
 func (sw *SignalFxWriter) listenForTraceSpans() {
-	reqSema := make(chan struct{}, sw.conf.MaxRequests)
-
 	// The only reason this is on the struct and not a local var is so we can
 	// easily get diagnostic metrics from it
 	sw.serviceTracker = sw.startGeneratingHostCorrelationMetrics()
-	shedRequests := make(chan struct{})
-	shedCompleted := make(chan struct{})
+	if sw.spanMetrics != nil {
+		sw.startSendingSpanMetrics()
+	}
 
 	for {
 		select {
@@ -28,105 +28,94 @@ func (sw *SignalFxWriter) listenForTraceSpans() {
 			return
 
 		case span := <-sw.spanChan:
+			if sw.isSpanFiltered(span) {
+				continue
+			}
 			buf := append(sw.spanBufferPool.Get().([]*trace.Span), span)
-			buf = sw.drainSpanChan(buf)
-
-			for i := range buf {
-				sw.preprocessSpan(buf[i])
+			buf = sw.processSpans(sw.drainSpanChan(buf))
+			if len(buf) == 0 {
+				sw.spanBufferPool.Put(buf)
+				continue
 			}
 
 			if *sw.conf.SendTraceHostCorrelationMetrics {
 				sw.serviceTracker.AddSpans(sw.ctx, buf)
 			}
 
-			if atomic.LoadInt64(&sw.traceSpansInFlight) > int64(sw.conf.MaxTraceSpansInFlight) {
-				// Attempt to prioritize new spans over old spans if we get in
-				// a situation where we need to drop spans.  If we can't shed
-				// any pending spans (e.g. because they are in the middle of
-				// being sent) then drop the current set of spans we are
-				// processing.  This is an imperfect process that could
-				// unnecessarily shed spans if the count of spans in flight
-				// drops below the threshold between the above check and the
-				// shedding, but it does guarantee that the number of pending
-				// spans doesn't exceed MaxTraceSpansInFlight +
-				// TraceSpanMaxBatchSize.
-				if !sw.attemptToShedPendingSpans(shedRequests, shedCompleted) {
-					log.Warnf("Dropping %d new trace spans due to excess trace spans in flight", len(buf))
-					atomic.AddInt64(&sw.traceSpansDropped, int64(len(buf)))
-					continue
-				}
-			}
-
-			atomic.AddInt64(&sw.traceSpansInFlight, int64(len(buf)))
-
-			go func() {
-				defer sw.spanBufferPool.Put(buf[:0])
-
-				// Wait if there are more than the max outstanding requests,
-				// but respond to requests to shed outstandand requests and the
-				// writer shutdown.
-				select {
-				case <-sw.ctx.Done():
-					atomic.AddInt64(&sw.traceSpansInFlight, -int64(len(buf)))
-					return
-				case <-shedRequests:
-					atomic.AddInt64(&sw.traceSpansDropped, int64(len(buf)))
-					log.Warnf("Aborting pending trace span request with %d spans "+
-						"due to excess trace spans in flight", len(buf))
-					atomic.AddInt64(&sw.traceSpansInFlight, -int64(len(buf)))
-					shedCompleted <- struct{}{}
-					return
-				case reqSema <- struct{}{}:
-					break
-				}
-
-				// Don't put this above because shed requests need to control
-				// the order that they decrement this and notify completion.
-				defer atomic.AddInt64(&sw.traceSpansInFlight, -int64(len(buf)))
-
-				atomic.AddInt64(&sw.traceSpanRequestsActive, 1)
-				// This sends synchonously
-				err := sw.client.AddSpans(context.Background(), buf)
-				<-reqSema
-				atomic.AddInt64(&sw.traceSpanRequestsActive, -1)
-
-				if err != nil {
-					log.WithFields(log.Fields{
-						"error": err,
-					}).Errorf("Error shipping %d trace spans to SignalFx", len(buf))
-					atomic.AddInt64(&sw.traceSpansFailedToSend, int64(len(buf)))
-					// If there is an error sending spans then just forget about them.
-					return
-				}
-				atomic.AddInt64(&sw.traceSpansSent, int64(len(buf)))
-				log.Debugf("Sent %d trace spans to SignalFx", len(buf))
-			}()
+			sw.sendSpans(buf)
 		}
 	}
 }
 
-func (sw *SignalFxWriter) attemptToShedPendingSpans(shedRequests chan struct{}, shedCompleted chan struct{}) bool {
-	for {
-		select {
-		case shedRequests <- struct{}{}:
-			// There is always a 1:1 correspondance between the request and
-			// completion signal.  This guarantees that traceSpansInFlight is
-			// decremented for the shed spans.
-			<-shedCompleted
-			if atomic.LoadInt64(&sw.traceSpansInFlight) < int64(sw.conf.MaxTraceSpansInFlight) {
-				return true
-			}
-		default:
-			// No outstanding requests are available to shed so nothing to do
-			return false
+// sendSpans hands a batch of spans off to each destination that takes them.
+// Like datapoints, each destination sends and retries the batch on its own,
+// with its own request slots and limit on spans in flight.  buf is returned to
+// the pool once all of the destinations are done with it.
+func (sw *SignalFxWriter) sendSpans(buf []*trace.Span) {
+	var dests []*destination
+	for _, dest := range sw.destinations {
+		if dest.sendTraceSpans && dest.spanPipeline.reserve(len(buf), sw.conf.MaxTraceSpansInFlight) {
+			dests = append(dests, dest)
 		}
 	}
+
+	if len(dests) == 0 {
+		sw.spanBufferPool.Put(buf[:0])
+		return
+	}
+
+	remaining := int32(len(dests))
+	for _, dest := range dests {
+		dest := dest
+		go func() {
+			sw.sendSpansToDestination(dest, buf)
+			if atomic.AddInt32(&remaining, -1) == 0 {
+				sw.spanBufferPool.Put(buf[:0])
+			}
+		}()
+	}
+}
+
+// sendSpansToDestination sends spans to dest, retrying according to the retry
+// policy, unless the batch gets shed while waiting.  The spans must have been
+// reserved in the destination's span pipeline.
+func (sw *SignalFxWriter) sendSpansToDestination(dest *destination, buf []*trace.Span) {
+	dest.spanPipeline.send(sw.ctx, len(buf), func(attempt int) (time.Duration, bool) {
+		// This sends synchonously
+		ctx, retryAfter := withRetryAfterCapture(context.Background())
+		err := dest.client.AddSpans(ctx, buf)
+		if err == nil {
+			atomic.AddInt64(&dest.traceSpansSent, int64(len(buf)))
+			log.Debugf("Sent %d trace spans to %s", len(buf), dest)
+			return 0, false
+		}
+
+		delay, ok := sw.retryPolicy.nextBackoff(attempt, err, retryAfter.after)
+		if !ok {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Errorf("Error shipping %d trace spans to %s", len(buf), dest)
+			atomic.AddInt64(&dest.traceSpansFailedToSend, int64(len(buf)))
+			// If there is an error sending spans then just forget about them.
+			return 0, false
+		}
+
+		log.WithFields(log.Fields{
+			"error":   err,
+			"attempt": attempt,
+			"backoff": delay,
+		}).Warnf("Failed to send %d trace spans to %s, retrying", len(buf), dest)
+		return delay, true
+	})
 }
 
 func (sw *SignalFxWriter) drainSpanChan(buf []*trace.Span) []*trace.Span {
 	for {
 		select {
 		case span := <-sw.spanChan:
+			if sw.isSpanFiltered(span) {
+				continue
+			}
 			buf = append(buf, span)
 			if len(buf) >= sw.conf.TraceSpanMaxBatchSize {
 				return buf
@@ -137,6 +126,57 @@ func (sw *SignalFxWriter) drainSpanChan(buf []*trace.Span) []*trace.Span {
 	}
 }
 
+func (sw *SignalFxWriter) isSpanFiltered(span *trace.Span) bool {
+	if sw.spanFilters != nil && sw.spanFilters.Matches(span) {
+		atomic.AddInt64(&sw.traceSpansFiltered, 1)
+		return true
+	}
+	return false
+}
+
+// processSpans prepares a batch of spans that got through the filters to be
+// sent and returns the ones that should be sent, reusing buf.  The span
+// metrics are generated from all of the spans, including those that are
+// sampled out, so that they reflect the real request rate.
+func (sw *SignalFxWriter) processSpans(buf []*trace.Span) []*trace.Span {
+	// Sampling decisions are made on the spans as they came in, before they
+	// are changed by preprocessing
+	kept := sw.sampleSpans(buf)
+
+	for i := range buf {
+		sw.preprocessSpan(buf[i])
+	}
+
+	if sw.spanMetrics != nil {
+		sw.spanMetrics.AddSpans(buf)
+	}
+
+	buf = buf[:kept]
+	for i := range buf {
+		sw.recordSpan(buf[i])
+	}
+	return buf
+}
+
+// sampleSpans moves the spans that are sampled out to the end of buf and
+// returns how many are kept
+func (sw *SignalFxWriter) sampleSpans(buf []*trace.Span) int {
+	if sw.spanSampler == nil {
+		return len(buf)
+	}
+
+	kept := 0
+	for i := range buf {
+		if sw.spanSampler.shouldKeep(buf[i]) {
+			buf[kept], buf[i] = buf[i], buf[kept]
+			kept++
+		}
+	}
+	atomic.AddInt64(&sw.traceSpansSampled, int64(kept))
+	atomic.AddInt64(&sw.traceSpansSampledOut, int64(len(buf)-kept))
+	return kept
+}
+
 func (sw *SignalFxWriter) preprocessSpan(span *trace.Span) {
 	// Some spans aren't really specific to the host they are running
 	// on and shouldn't have any host-specific tags.  This is indicated by a
@@ -148,9 +188,20 @@ func (sw *SignalFxWriter) preprocessSpan(span *trace.Span) {
 		delete(span.Tags, dpmeta.NotHostSpecificMeta)
 	}
 
+	if sw.spanRedactor != nil {
+		sw.spanRedactor.redact(span)
+	}
+}
+
+// recordSpan logs and records a span that is going to be sent
+func (sw *SignalFxWriter) recordSpan(span *trace.Span) {
 	if sw.conf.LogTraceSpans {
 		log.Debugf("Sending trace span:\n%s", spew.Sdump(span))
 	}
+
+	if sw.jsonLines != nil {
+		sw.jsonLines.recordSpan(span)
+	}
 }
 
 func (sw *SignalFxWriter) startGeneratingHostCorrelationMetrics() *tracetracker.ActiveServiceTracker {
@@ -169,3 +220,11 @@ func (sw *SignalFxWriter) startGeneratingHostCorrelationMetrics() *tracetracker.
 
 	return tracker
 }
+
+func (sw *SignalFxWriter) startSendingSpanMetrics() {
+	utils.RunOnInterval(sw.ctx, func() {
+		for _, dp := range sw.spanMetrics.Datapoints() {
+			sw.dpChan <- dp
+		}
+	}, sw.conf.SpanMetrics.Interval)
+}
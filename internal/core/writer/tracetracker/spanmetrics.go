@@ -0,0 +1,278 @@
+package tracetracker
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/signalfx/golib/datapoint"
+	"github.com/signalfx/golib/sfxclient"
+	"github.com/signalfx/golib/trace"
+	"github.com/signalfx/signalfx-agent/internal/utils"
+)
+
+const (
+	spanCountMetricName       = "spans.count"
+	spanErrorsMetricName      = "spans.errors"
+	spanDurationSumMetricName = "spans.duration.sum"
+	spanDurationBucketName    = "spans.duration.bucket"
+	spanDurationPercentile    = "spans.duration.p"
+)
+
+// The most span durations to hold per series within a single interval for
+// calculating percentiles.  Past this, durations are sampled so that memory
+// use is bounded.
+const maxDurationSamples = 1000
+
+// The dimensions of the series that spans are counted in once there are
+// already the max number of series
+var otherSeriesDims = map[string]string{
+	"service":   "other",
+	"operation": "other",
+}
+
+var otherSeriesKey = seriesKey(otherSeriesDims)
+
+type spanSeries struct {
+	dims     map[string]string
+	lastSeen time.Time
+
+	count       int64
+	errors      int64
+	durationSum int64
+	// Cumulative counts of spans in each latency bucket, with the last
+	// element for spans longer than the largest bucket
+	bucketCounts []int64
+
+	// The durations seen since the last time metrics were sent, and how
+	// many there were in total if some were dropped by sampling
+	durations     []int64
+	durationsSeen int64
+}
+
+// SpanMetricsAggregator derives request rate, error and latency metrics from
+// trace spans, grouped by service, operation and selected span tags.
+type SpanMetricsAggregator struct {
+	sync.Mutex
+	dimensions  []string
+	percentiles []float64
+	// Bucket upper bounds in microseconds
+	buckets      []int64
+	bucketLabels []string
+	staleTimeout time.Duration
+	// The most series to track, not counting the other series.  0 means no
+	// limit.
+	maxSeries int
+
+	series map[string]*spanSeries
+	// How many spans were counted in the other series because their own
+	// series would have gone over maxSeries
+	overflowSpans int64
+
+	timeNow func() time.Time
+	rand    *rand.Rand
+}
+
+// NewSpanMetricsAggregator creates a new aggregator.  The latency buckets are
+// upper bounds in milliseconds.  Once maxSeries series are being tracked,
+// spans for new combinations of dimensions are counted in a single series
+// whose service and operation are both `other`.  If maxSeries is 0, the
+// number of series is not limited.
+func NewSpanMetricsAggregator(dimensions []string, percentiles []float64, latencyBucketsMs []float64,
+	staleTimeout time.Duration, maxSeries int) *SpanMetricsAggregator {
+	a := &SpanMetricsAggregator{
+		dimensions:   dimensions,
+		percentiles:  percentiles,
+		staleTimeout: staleTimeout,
+		maxSeries:    maxSeries,
+		series:       make(map[string]*spanSeries),
+		timeNow:      time.Now,
+		rand:         rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for _, b := range latencyBucketsMs {
+		a.buckets = append(a.buckets, int64(b*1000))
+		a.bucketLabels = append(a.bucketLabels, strconv.FormatFloat(b, 'f', -1, 64))
+	}
+	a.bucketLabels = append(a.bucketLabels, "+Inf")
+	return a
+}
+
+// AddSpans updates the metrics with the given spans.  This is thread-safe.
+func (a *SpanMetricsAggregator) AddSpans(spans []*trace.Span) {
+	now := a.timeNow()
+
+	a.Lock()
+	defer a.Unlock()
+
+	for i := range spans {
+		a.addSpan(spans[i], now)
+	}
+}
+
+func (a *SpanMetricsAggregator) addSpan(span *trace.Span, now time.Time) {
+	// Can't do anything if the spans don't have a local service name
+	if span.LocalEndpoint == nil || span.LocalEndpoint.ServiceName == nil {
+		return
+	}
+
+	dims := map[string]string{
+		"service": *span.LocalEndpoint.ServiceName,
+	}
+	if span.Name != nil {
+		dims["operation"] = *span.Name
+	}
+	for _, key := range a.dimensions {
+		if v, ok := span.Tags[key]; ok {
+			dims[key] = v
+		}
+	}
+
+	key := seriesKey(dims)
+	s, ok := a.series[key]
+	if !ok && a.maxSeries > 0 && a.trackedSeries() >= a.maxSeries {
+		a.overflowSpans++
+		dims = otherSeriesDims
+		key = otherSeriesKey
+		s, ok = a.series[key]
+	}
+	if !ok {
+		s = &spanSeries{
+			dims:         dims,
+			bucketCounts: make([]int64, len(a.buckets)+1),
+		}
+		a.series[key] = s
+	}
+	s.lastSeen = now
+
+	s.count++
+	if isErrorSpan(span) {
+		s.errors++
+	}
+
+	var duration int64
+	if span.Duration != nil {
+		duration = *span.Duration
+	}
+	s.durationSum += duration
+	s.bucketCounts[sort.Search(len(a.buckets), func(i int) bool { return duration <= a.buckets[i] })]++
+
+	if len(a.percentiles) > 0 {
+		s.durationsSeen++
+		if len(s.durations) < maxDurationSamples {
+			s.durations = append(s.durations, duration)
+		} else if i := a.rand.Int63n(s.durationsSeen); i < maxDurationSamples {
+			// Reservoir sampling so every span has the same chance of
+			// being included
+			s.durations[i] = duration
+		}
+	}
+}
+
+// Datapoints returns the current metrics for all series that have been seen
+// within the stale timeout and resets the interval used for percentiles.
+func (a *SpanMetricsAggregator) Datapoints() []*datapoint.Datapoint {
+	now := a.timeNow()
+
+	a.Lock()
+	defer a.Unlock()
+
+	var out []*datapoint.Datapoint
+	for key, s := range a.series {
+		if now.Sub(s.lastSeen) >= a.staleTimeout {
+			delete(a.series, key)
+			continue
+		}
+
+		// The writer adds dimensions to datapoints in place, so each
+		// datapoint needs its own copy
+		out = append(out,
+			sfxclient.Cumulative(spanCountMetricName, utils.CloneStringMap(s.dims), s.count),
+			sfxclient.Cumulative(spanErrorsMetricName, utils.CloneStringMap(s.dims), s.errors),
+			sfxclient.Cumulative(spanDurationSumMetricName, utils.CloneStringMap(s.dims), s.durationSum))
+
+		if len(a.buckets) > 0 {
+			for i, c := range s.bucketCounts {
+				dims := utils.MergeStringMaps(s.dims, map[string]string{"upper_bound": a.bucketLabels[i]})
+				out = append(out, sfxclient.Cumulative(spanDurationBucketName, dims, c))
+			}
+		}
+
+		if len(s.durations) > 0 {
+			sort.Slice(s.durations, func(i, j int) bool { return s.durations[i] < s.durations[j] })
+			for _, p := range a.percentiles {
+				out = append(out, sfxclient.Gauge(percentileMetricName(p), utils.CloneStringMap(s.dims), percentile(s.durations, p)))
+			}
+			s.durations = s.durations[:0]
+			s.durationsSeen = 0
+		}
+	}
+
+	for i := range out {
+		out[i].Timestamp = now
+	}
+	return out
+}
+
+// SeriesCount returns how many combinations of dimensions are being tracked
+func (a *SpanMetricsAggregator) SeriesCount() int {
+	a.Lock()
+	defer a.Unlock()
+	return len(a.series)
+}
+
+// OverflowSpans returns how many spans have been counted in the `other`
+// series because their own series would have gone over the max
+func (a *SpanMetricsAggregator) OverflowSpans() int64 {
+	a.Lock()
+	defer a.Unlock()
+	return a.overflowSpans
+}
+
+// The number of series being tracked that count towards maxSeries
+func (a *SpanMetricsAggregator) trackedSeries() int {
+	if _, ok := a.series[otherSeriesKey]; ok {
+		return len(a.series) - 1
+	}
+	return len(a.series)
+}
+
+// Follows the convention that a span with an error tag is an error unless
+// the tag is explicitly false
+func isErrorSpan(span *trace.Span) bool {
+	v, ok := span.Tags["error"]
+	return ok && !strings.EqualFold(v, "false")
+}
+
+// Uses the nearest-rank method on the sorted durations
+func percentile(sorted []int64, p float64) int64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func percentileMetricName(p float64) string {
+	return spanDurationPercentile + strconv.FormatFloat(p, 'f', -1, 64)
+}
+
+func seriesKey(dims map[string]string) string {
+	keys := make([]string, 0, len(dims))
+	for k := range dims {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for _, k := range keys {
+		sb.WriteString(k)
+		sb.WriteByte(0)
+		sb.WriteString(dims[k])
+		sb.WriteByte(0)
+	}
+	return sb.String()
+}
@@ -0,0 +1,105 @@
+package tracetracker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/signalfx/golib/datapoint"
+	"github.com/signalfx/golib/pointer"
+	"github.com/signalfx/golib/trace"
+	"github.com/signalfx/signalfx-agent/internal/neotest"
+	"github.com/stretchr/testify/assert"
+)
+
+func spanWithDuration(service, operation string, durationMs int64, tags map[string]string) *trace.Span {
+	return &trace.Span{
+		Name:          pointer.String(operation),
+		Duration:      pointer.Int64(durationMs * 1000),
+		LocalEndpoint: &trace.Endpoint{ServiceName: pointer.String(service)},
+		Tags:          tags,
+	}
+}
+
+func findDatapoint(dps []*datapoint.Datapoint, metric string, dims map[string]string) *datapoint.Datapoint {
+	for _, dp := range dps {
+		if dp.Metric == metric && assert.ObjectsAreEqual(dims, dp.Dimensions) {
+			return dp
+		}
+	}
+	return nil
+}
+
+func TestSpanMetricsAggregator(t *testing.T) {
+	a := NewSpanMetricsAggregator([]string{"http.method"}, []float64{50, 90}, []float64{10, 100}, 5*time.Minute, 0)
+	a.timeNow = neotest.PinnedNow(time.Unix(1000, 0))
+
+	var spans []*trace.Span
+	for i := int64(1); i <= 10; i++ {
+		spans = append(spans, spanWithDuration("api", "GET /users", i*10, map[string]string{"http.method": "GET"}))
+	}
+	spans = append(spans,
+		spanWithDuration("api", "GET /users", 500, map[string]string{"http.method": "GET", "error": "true"}),
+		spanWithDuration("api", "POST /users", 5, map[string]string{"error": "false"}),
+		// No service name so can't be used
+		&trace.Span{Name: pointer.String("unknown")})
+	a.AddSpans(spans)
+
+	assert.Equal(t, 2, a.SeriesCount())
+
+	dps := a.Datapoints()
+	getDims := map[string]string{"service": "api", "operation": "GET /users", "http.method": "GET"}
+	postDims := map[string]string{"service": "api", "operation": "POST /users"}
+
+	assert.Equal(t, datapoint.NewIntValue(11), findDatapoint(dps, "spans.count", getDims).Value)
+	assert.Equal(t, datapoint.NewIntValue(1), findDatapoint(dps, "spans.errors", getDims).Value)
+	assert.Equal(t, datapoint.NewIntValue(1050000), findDatapoint(dps, "spans.duration.sum", getDims).Value)
+	assert.Equal(t, datapoint.NewIntValue(0), findDatapoint(dps, "spans.errors", postDims).Value)
+
+	assert.Equal(t, datapoint.NewIntValue(60000), findDatapoint(dps, "spans.duration.p50", getDims).Value)
+	assert.Equal(t, datapoint.NewIntValue(100000), findDatapoint(dps, "spans.duration.p90", getDims).Value)
+
+	bucket := func(bound string) datapoint.Value {
+		dims := map[string]string{"service": "api", "operation": "GET /users", "http.method": "GET", "upper_bound": bound}
+		return findDatapoint(dps, "spans.duration.bucket", dims).Value
+	}
+	assert.Equal(t, datapoint.NewIntValue(1), bucket("10"))
+	assert.Equal(t, datapoint.NewIntValue(9), bucket("100"))
+	assert.Equal(t, datapoint.NewIntValue(1), bucket("+Inf"))
+
+	t.Run("Percentiles are reset each interval", func(t *testing.T) {
+		dps := a.Datapoints()
+		assert.Nil(t, findDatapoint(dps, "spans.duration.p50", getDims))
+		assert.Equal(t, datapoint.NewIntValue(11), findDatapoint(dps, "spans.count", getDims).Value)
+	})
+
+	t.Run("Stale series are dropped", func(t *testing.T) {
+		a.timeNow = neotest.AdvancedNow(a.timeNow, 5*time.Minute)
+		assert.Len(t, a.Datapoints(), 0)
+		assert.Equal(t, 0, a.SeriesCount())
+	})
+}
+
+func TestSpanMetricsMaxSeries(t *testing.T) {
+	a := NewSpanMetricsAggregator(nil, nil, nil, 5*time.Minute, 2)
+	a.timeNow = neotest.PinnedNow(time.Unix(1000, 0))
+
+	a.AddSpans([]*trace.Span{
+		spanWithDuration("api", "GET /users", 10, nil),
+		spanWithDuration("api", "POST /users", 10, nil),
+		spanWithDuration("api", "GET /users/1", 10, nil),
+		spanWithDuration("api", "GET /users/2", 20, nil),
+		// Existing series are still counted
+		spanWithDuration("api", "GET /users", 10, nil),
+	})
+
+	assert.Equal(t, 3, a.SeriesCount())
+	assert.Equal(t, int64(2), a.OverflowSpans())
+
+	dps := a.Datapoints()
+	otherDims := map[string]string{"service": "other", "operation": "other"}
+	assert.Equal(t, datapoint.NewIntValue(2), findDatapoint(dps, "spans.count", otherDims).Value)
+	assert.Equal(t, datapoint.NewIntValue(30000), findDatapoint(dps, "spans.duration.sum", otherDims).Value)
+	assert.Equal(t, datapoint.NewIntValue(2),
+		findDatapoint(dps, "spans.count", map[string]string{"service": "api", "operation": "GET /users"}).Value)
+	assert.Nil(t, findDatapoint(dps, "spans.count", map[string]string{"service": "api", "operation": "GET /users/1"}))
+}
@@ -19,11 +19,13 @@ import (
 	"github.com/davecgh/go-spew/spew"
 	"github.com/signalfx/golib/datapoint"
 	"github.com/signalfx/golib/event"
-	"github.com/signalfx/golib/sfxclient"
 	"github.com/signalfx/golib/trace"
 	"github.com/signalfx/signalfx-agent/internal/core/common/dpmeta"
 	"github.com/signalfx/signalfx-agent/internal/core/config"
 	"github.com/signalfx/signalfx-agent/internal/core/dpfilters"
+	"github.com/signalfx/signalfx-agent/internal/core/dptransforms"
+	"github.com/signalfx/signalfx-agent/internal/core/eventfilters"
+	"github.com/signalfx/signalfx-agent/internal/core/spanfilters"
 	"github.com/signalfx/signalfx-agent/internal/core/writer/tracetracker"
 	"github.com/signalfx/signalfx-agent/internal/monitors/types"
 	"github.com/signalfx/signalfx-agent/internal/utils"
@@ -40,8 +42,9 @@ const (
 // receives events/datapoints on two buffered channels and writes them to
 // SignalFx on a regular interval.
 type SignalFxWriter struct {
-	client        *sfxclient.HTTPSink
-	dimPropClient *dimensionPropertyClient
+	// The primary destination is always first
+	destinations []*destination
+	retryPolicy  *retryPolicy
 
 	// Monitors should send datapoints to this
 	dpChan chan *datapoint.Datapoint
@@ -57,6 +60,17 @@ type SignalFxWriter struct {
 	// map that holds host-specific ids like AWSUniqueID
 	hostIDDims       map[string]string
 	datapointFilters *dpfilters.FilterSet
+	eventFilters     *eventfilters.FilterSet
+	spanFilters      *spanfilters.FilterSet
+	dpTransforms     []*dptransforms.Transform
+	// Nil unless trace sampling is configured
+	spanSampler *spanSampler
+	// Nil unless span redaction is configured
+	spanRedactor *spanRedactor
+	// Nil unless span metrics are enabled
+	spanMetrics *tracetracker.SpanMetricsAggregator
+	// Nil unless `jsonLinesPath` is configured
+	jsonLines *jsonLinesRecorder
 
 	dpBufferPool   *sync.Pool
 	spanBufferPool *sync.Pool
@@ -66,37 +80,26 @@ type SignalFxWriter struct {
 	// emitted by the agent
 	serviceTracker *tracetracker.ActiveServiceTracker
 
-	dpRequestsActive        int64
-	dpsInFlight             int64
-	dpsSent                 int64
-	traceSpanRequestsActive int64
-	traceSpansInFlight      int64
-	traceSpansSent          int64
-	traceSpansDropped       int64
-	traceSpansFailedToSend  int64
-	eventsSent              int64
-	startTime               time.Time
+	traceSpansFiltered   int64
+	traceSpansSampled    int64
+	traceSpansSampledOut int64
+	eventsFiltered       int64
+	startTime            time.Time
 }
 
 // New creates a new un-configured writer
 func New(conf *config.WriterConfig, dpChan chan *datapoint.Datapoint, eventChan chan *event.Event,
 	propertyChan chan *types.DimProperties, spanChan chan *trace.Span) (*SignalFxWriter, error) {
 
-	dimPropClient, err := newDimensionPropertyClient(conf)
-	if err != nil {
-		return nil, err
-	}
-
 	sw := &SignalFxWriter{
-		conf:          conf,
-		client:        sfxclient.NewHTTPSink(),
-		dimPropClient: dimPropClient,
-		hostIDDims:    conf.HostIDDims,
-		dpChan:        dpChan,
-		eventChan:     eventChan,
-		spanChan:      spanChan,
-		propertyChan:  propertyChan,
-		startTime:     time.Now(),
+		conf:         conf,
+		retryPolicy:  newRetryPolicy(conf),
+		hostIDDims:   conf.HostIDDims,
+		dpChan:       dpChan,
+		eventChan:    eventChan,
+		spanChan:     spanChan,
+		propertyChan: propertyChan,
+		startTime:    time.Now(),
 		dpBufferPool: &sync.Pool{
 			New: func() interface{} {
 				return make([]*datapoint.Datapoint, 0, conf.DatapointMaxBatchSize)
@@ -110,58 +113,89 @@ func New(conf *config.WriterConfig, dpChan chan *datapoint.Datapoint, eventChan
 	}
 	sw.ctx, sw.cancel = context.WithCancel(context.Background())
 
-	sw.client.AuthToken = conf.SignalFxAccessToken
-
-	sw.client.Client.Transport = &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-		DialContext: (&net.Dialer{
-			Timeout:   3 * time.Second,
-			KeepAlive: 90 * time.Second,
-			DualStack: true,
-		}).DialContext,
-		MaxIdleConns:        100,
-		MaxIdleConnsPerHost: conf.MaxRequests,
-		IdleConnTimeout:     90 * time.Second,
-		TLSHandshakeTimeout: 10 * time.Second,
+	transport := &retryAfterTransport{
+		RoundTripper: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   3 * time.Second,
+				KeepAlive: 90 * time.Second,
+				DualStack: true,
+			}).DialContext,
+			MaxIdleConns:        100,
+			MaxIdleConnsPerHost: conf.MaxRequests,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+	}
+
+	primary, err := newDestination("", conf.PrimaryDestination(), conf, transport)
+	if err != nil {
+		return nil, err
+	}
+	sw.destinations = append(sw.destinations, primary)
+
+	for i := range conf.Destinations {
+		dest, err := newDestination(conf.Destinations[i].Name, &conf.Destinations[i], conf, transport)
+		if err != nil {
+			return nil, err
+		}
+		sw.destinations = append(sw.destinations, dest)
+	}
+
+	sw.datapointFilters, err = sw.conf.DatapointFilters()
+	if err != nil {
+		return nil, err
+	}
+
+	sw.eventFilters, err = sw.conf.EventFilters()
+	if err != nil {
+		return nil, err
 	}
 
-	dpEndpointURL, err := conf.ParsedIngestURL().Parse("v2/datapoint")
+	sw.spanFilters, err = sw.conf.SpanFilters()
 	if err != nil {
-		log.WithFields(log.Fields{
-			"error":     err,
-			"ingestURL": conf.ParsedIngestURL().String(),
-		}).Error("Could not construct datapoint ingest URL")
 		return nil, err
 	}
-	sw.client.DatapointEndpoint = dpEndpointURL.String()
 
-	eventEndpointURL, err := conf.ParsedIngestURL().Parse("v2/event")
+	sw.dpTransforms, err = sw.conf.DatapointTransforms()
 	if err != nil {
-		log.WithFields(log.Fields{
-			"error":     err,
-			"ingestURL": conf.ParsedIngestURL().String(),
-		}).Error("Could not construct event ingest URL")
 		return nil, err
 	}
-	sw.client.EventEndpoint = eventEndpointURL.String()
 
-	traceEndpointURL := conf.ParsedTraceEndpointURL()
-	if traceEndpointURL == nil {
-		var err error
-		traceEndpointURL, err = conf.ParsedIngestURL().Parse("v1/trace")
+	if conf.TraceSampling.Enabled() {
+		sw.spanSampler, err = newSpanSampler(&conf.TraceSampling)
 		if err != nil {
-			log.WithFields(log.Fields{
-				"error":     err,
-				"ingestURL": conf.ParsedIngestURL().String(),
-			}).Error("Could not construct trace ingest URL")
 			return nil, err
 		}
 	}
-	sw.client.TraceEndpoint = traceEndpointURL.String()
 
-	sw.datapointFilters, err = sw.conf.DatapointFilters()
-	if err != nil {
-		return nil, err
+	if conf.SpanRedaction.Enabled() {
+		sw.spanRedactor, err = newSpanRedactor(&conf.SpanRedaction)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if conf.SpanMetrics.Enabled {
+		sw.spanMetrics = tracetracker.NewSpanMetricsAggregator(conf.SpanMetrics.Dimensions,
+			conf.SpanMetrics.Percentiles, conf.SpanMetrics.LatencyBucketsMs, conf.SpanMetrics.StaleTimeout,
+			conf.SpanMetrics.MaxSeries)
+	}
+
+	if conf.JSONLinesPath != "" {
+		sw.jsonLines, err = newJSONLinesRecorder(conf.JSONLinesPath, int64(*conf.JSONLinesMaxSizeMB)*1024*1024, *conf.JSONLinesMaxBackups)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, dest := range sw.destinations {
+		if dest.diskBuffer != nil {
+			sw.startReplayingDiskBuffer(dest)
+		}
+		if dest.dimPropClient != nil {
+			dest.dimPropClient.Start(sw.ctx)
+		}
 	}
 
 	go sw.listenForDatapoints()
@@ -176,47 +210,51 @@ func (sw *SignalFxWriter) shouldSendDatapoint(dp *datapoint.Datapoint) bool {
 }
 
 func (sw *SignalFxWriter) preprocessDatapoint(dp *datapoint.Datapoint) {
-	dp.Dimensions = sw.addGlobalDims(dp.Dimensions)
-
-	// Some metrics aren't really specific to the host they are running
-	// on and shouldn't have any host-specific dims
-	if b, ok := dp.Meta[dpmeta.NotHostSpecificMeta].(bool); !ok || !b {
-		dp.Dimensions = sw.addhostIDFields(dp.Dimensions)
-	}
+	sw.transformDatapoint(dp)
 
 	if sw.conf.LogDatapoints {
 		log.Debugf("Sending datapoint:\n%s", utils.DatapointToString(dp))
 	}
+
+	if sw.jsonLines != nil {
+		sw.jsonLines.recordDatapoint(dp)
+	}
 }
 
-func (sw *SignalFxWriter) sendDatapoints(dps []*datapoint.Datapoint) error {
-	// This sends synchonously
-	err := sw.client.AddDatapoints(context.Background(), dps)
-	if err != nil {
-		log.WithFields(log.Fields{
-			"error": err,
-		}).Error("Error shipping datapoints to SignalFx")
-		// If there is an error sending datapoints then just forget about them.
-		return err
+// transformDatapoint makes the changes to the datapoint that happen before
+// it reaches the destinations: the metric transforms and the global and host
+// dimensions.
+func (sw *SignalFxWriter) transformDatapoint(dp *datapoint.Datapoint) {
+	for i := range sw.dpTransforms {
+		sw.dpTransforms[i].Apply(dp)
 	}
-	atomic.AddInt64(&sw.dpsSent, int64(len(dps)))
-	log.Debugf("Sent %d datapoints to SignalFx", len(dps))
 
-	return nil
+	dp.Dimensions = sw.addGlobalDims(dp.Dimensions)
+
+	// Some metrics aren't really specific to the host they are running
+	// on and shouldn't have any host-specific dims
+	if b, ok := dp.Meta[dpmeta.NotHostSpecificMeta].(bool); !ok || !b {
+		dp.Dimensions = sw.addhostIDFields(dp.Dimensions)
+	}
 }
 
-func (sw *SignalFxWriter) sendEvents(events []*event.Event) error {
+func (sw *SignalFxWriter) sendEvents(events []*event.Event) {
 	for i := range events {
 		events[i].Dimensions = sw.addGlobalDims(events[i].Dimensions)
 
 		ps := events[i].Properties
 		var notHostSpecific bool
+		var monitorType string
 		if ps != nil {
 			if b, ok := ps[dpmeta.NotHostSpecificMeta].(bool); ok {
 				notHostSpecific = b
 				// Clear this so it doesn't leak through to ingest
 				delete(ps, dpmeta.NotHostSpecificMeta)
 			}
+			if t, ok := ps[dpmeta.MonitorTypeMeta].(string); ok {
+				monitorType = t
+				delete(ps, dpmeta.MonitorTypeMeta)
+			}
 		}
 		// Only override host dimension for now and omit other host id dims.
 		if !notHostSpecific && sw.hostIDDims != nil && sw.hostIDDims["host"] != "" {
@@ -228,17 +266,15 @@ func (sw *SignalFxWriter) sendEvents(events []*event.Event) error {
 				"event": spew.Sdump(events[i]),
 			}).Debug("Sending event")
 		}
-	}
 
-	err := sw.client.AddEvents(context.Background(), events)
-	if err != nil {
-		log.WithError(err).Error("Error shipping events to SignalFx")
-		return err
+		if sw.jsonLines != nil {
+			sw.jsonLines.recordEvent(events[i], monitorType)
+		}
 	}
-	sw.eventsSent += int64(len(events))
-	log.Debugf("Sent %d events to SignalFx", len(events))
 
-	return nil
+	sw.forEachDestination(func(d *destination) bool { return d.sendEvents }, func(dest *destination) {
+		sw.sendEventsToDestination(dest, events)
+	})
 }
 
 // Mutates datapoint dimensions in place to add global dimensions.  Also
@@ -272,13 +308,6 @@ func (sw *SignalFxWriter) addhostIDFields(fields map[string]string) map[string]s
 // listenForDatapoints waits for datapoints to come in on the provided
 // channels and forwards them to SignalFx.
 func (sw *SignalFxWriter) listenForDatapoints() {
-
-	// This acts like a semaphore if a request goroutine pushes into it before
-	// making the request and pulling out of it when the request is done.
-	// Pushes will block if there are more than the max number of outstanding
-	// requests.
-	dpSema := make(chan struct{}, sw.conf.DatapointMaxRequests)
-
 	for {
 		select {
 		case <-sw.ctx.Done():
@@ -298,24 +327,44 @@ func (sw *SignalFxWriter) listenForDatapoints() {
 				sw.preprocessDatapoint(buf[i])
 			}
 
-			atomic.AddInt64(&sw.dpsInFlight, int64(len(buf)))
-
-			go func() {
-				// Wait if there are more than the max outstanding requests
-				dpSema <- struct{}{}
+			sw.sendDatapoints(buf)
+		}
 
-				atomic.AddInt64(&sw.dpRequestsActive, 1)
-				sw.sendDatapoints(buf)
+	}
+}
 
-				<-dpSema
+// sendDatapoints hands a batch of datapoints off to each destination that
+// takes them.  Each destination sends and retries its part of the batch on its
+// own, with its own request slots and limit on datapoints in flight, so that
+// one that is slow or failing doesn't hold up the others.  buf is returned to
+// the pool once all of the destinations are done with it.
+func (sw *SignalFxWriter) sendDatapoints(buf []*datapoint.Datapoint) {
+	batches := map[*destination][]*datapoint.Datapoint{}
+	for _, dest := range sw.destinations {
+		if !dest.sendDatapoints {
+			continue
+		}
+		dps := dest.filterDatapoints(buf)
+		if len(dps) == 0 || !dest.dpPipeline.reserve(len(dps), sw.conf.MaxDatapointsInFlight) {
+			continue
+		}
+		batches[dest] = dps
+	}
 
-				atomic.AddInt64(&sw.dpRequestsActive, -1)
-				atomic.AddInt64(&sw.dpsInFlight, -int64(len(buf)))
+	if len(batches) == 0 {
+		sw.dpBufferPool.Put(buf[:0])
+		return
+	}
 
+	remaining := int32(len(batches))
+	for dest, dps := range batches {
+		dest, dps := dest, dps
+		go func() {
+			sw.sendDatapointsToDestination(dest, dps)
+			if atomic.AddInt32(&remaining, -1) == 0 {
 				sw.dpBufferPool.Put(buf[:0])
-			}()
-		}
-
+			}
+		}()
 	}
 }
 
@@ -357,6 +406,10 @@ func (sw *SignalFxWriter) listenForEventsAndDimProps() {
 			return
 
 		case event := <-sw.eventChan:
+			if sw.eventFilters != nil && sw.eventFilters.Matches(event) {
+				atomic.AddInt64(&sw.eventsFiltered, 1)
+				continue
+			}
 			if len(sw.eventBuffer) > eventBufferCapacity {
 				log.WithFields(log.Fields{
 					"eventType":         event.EventType,
@@ -372,16 +425,10 @@ func (sw *SignalFxWriter) listenForEventsAndDimProps() {
 				initEventBuffer()
 			}
 		case dimProps := <-sw.propertyChan:
-			// Run the sync async so we don't block other cases in this select
-			go func(innerProps *types.DimProperties) {
-				err := sw.dimPropClient.SetPropertiesOnDimension(innerProps)
-				if err != nil {
-					log.WithFields(log.Fields{
-						"error":    err,
-						"dimProps": innerProps,
-					}).Error("Could not sync properties to dimension")
-				}
-			}(dimProps)
+			if sw.jsonLines != nil {
+				sw.jsonLines.recordDimProps(dimProps)
+			}
+			sw.sendDimPropsToDestinations(dimProps)
 		}
 	}
 }
@@ -391,5 +438,8 @@ func (sw *SignalFxWriter) Shutdown() {
 	if sw.cancel != nil {
 		sw.cancel()
 	}
+	if sw.jsonLines != nil {
+		sw.jsonLines.Close()
+	}
 	log.Debug("Stopped datapoint writer")
 }
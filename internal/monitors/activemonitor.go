@@ -3,6 +3,7 @@ package monitors
 import (
 	"fmt"
 	"reflect"
+	"sync/atomic"
 
 	"github.com/creasty/defaults"
 	"github.com/pkg/errors"
@@ -114,6 +115,24 @@ func (am *ActiveMonitor) injectAgentMetaIfNeeded() bool {
 	return true
 }
 
+// Returns the limiter on the number of metric time series the monitor can
+// send, or nil if there is no limit.
+func (am *ActiveMonitor) seriesLimiter() *seriesLimiter {
+	if mo, ok := am.output.(*monitorOutput); ok {
+		return mo.seriesLimiter
+	}
+	return nil
+}
+
+// Returns the number of events and trace spans that have been dropped by the
+// monitor's filters
+func (am *ActiveMonitor) filteredCounts() (events int64, spans int64) {
+	if mo, ok := am.output.(*monitorOutput); ok {
+		return atomic.LoadInt64(&mo.eventsFiltered), atomic.LoadInt64(&mo.spansFiltered)
+	}
+	return 0, 0
+}
+
 // Shutdown calls Shutdown on the monitor instance if it is provided.
 func (am *ActiveMonitor) Shutdown() {
 	if sh, ok := am.instance.(Shutdownable); ok {
@@ -0,0 +1,115 @@
+package monitors
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/signalfx/golib/datapoint"
+)
+
+const (
+	// Series that haven't been seen for this long no longer count towards the
+	// limit
+	seriesStaleTimeout = 10 * time.Minute
+	// How often to look for stale series, at most, once the limit is reached
+	seriesPurgeInterval = time.Minute
+)
+
+// seriesLimiter keeps track of the distinct metric time series (metric name
+// plus dimension set) that a single monitor has sent and rejects new ones
+// once there are too many.
+type seriesLimiter struct {
+	sync.Mutex
+	max int
+	// Keyed by a hash of the series with the last time it was seen
+	series    map[uint64]time.Time
+	lastPurge time.Time
+	// Whether the last new series was rejected, so that we can tell when the
+	// limit is first reached
+	limited bool
+	dropped int64
+
+	timeNow func() time.Time
+}
+
+func newSeriesLimiter(max int) *seriesLimiter {
+	return &seriesLimiter{
+		max:     max,
+		series:  make(map[uint64]time.Time),
+		timeNow: time.Now,
+	}
+}
+
+// allow returns whether the datapoint should be sent.  The second return
+// value is true if the datapoint is the first to be rejected since the limit
+// was last reached.
+func (sl *seriesLimiter) allow(dp *datapoint.Datapoint) (bool, bool) {
+	key := seriesKey(dp)
+	now := sl.timeNow()
+
+	sl.Lock()
+	defer sl.Unlock()
+
+	if _, ok := sl.series[key]; ok {
+		sl.series[key] = now
+		return true, false
+	}
+
+	if len(sl.series) >= sl.max && now.Sub(sl.lastPurge) >= seriesPurgeInterval {
+		sl.purgeStale(now)
+	}
+
+	if len(sl.series) >= sl.max {
+		sl.dropped++
+		firstRejection := !sl.limited
+		sl.limited = true
+		return false, firstRejection
+	}
+
+	sl.series[key] = now
+	sl.limited = false
+	return true, false
+}
+
+func (sl *seriesLimiter) purgeStale(now time.Time) {
+	for k, lastSeen := range sl.series {
+		if now.Sub(lastSeen) > seriesStaleTimeout {
+			delete(sl.series, k)
+		}
+	}
+	sl.lastPurge = now
+}
+
+// Count returns the number of series currently being tracked
+func (sl *seriesLimiter) Count() int {
+	sl.Lock()
+	defer sl.Unlock()
+	return len(sl.series)
+}
+
+// Dropped returns the total number of datapoints that have been rejected
+func (sl *seriesLimiter) Dropped() int64 {
+	sl.Lock()
+	defer sl.Unlock()
+	return sl.dropped
+}
+
+func seriesKey(dp *datapoint.Datapoint) uint64 {
+	keys := make([]string, 0, len(dp.Dimensions))
+	for k := range dp.Dimensions {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := fnv.New64a()
+	h.Write([]byte(dp.Metric))
+	for _, k := range keys {
+		h.Write([]byte{0})
+		h.Write([]byte(k))
+		h.Write([]byte{0})
+		h.Write([]byte(dp.Dimensions[k]))
+	}
+	return h.Sum64()
+}
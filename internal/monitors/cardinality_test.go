@@ -0,0 +1,53 @@
+package monitors
+
+import (
+	"testing"
+	"time"
+
+	"github.com/signalfx/golib/datapoint"
+	"github.com/signalfx/signalfx-agent/internal/neotest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeriesLimiter(t *testing.T) {
+	sl := newSeriesLimiter(2)
+	sl.timeNow = neotest.PinnedNow(time.Unix(1000, 0))
+
+	dp := func(metric string, dims map[string]string) *datapoint.Datapoint {
+		return datapoint.New(metric, dims, datapoint.NewIntValue(1), datapoint.Gauge, time.Time{})
+	}
+
+	allowed, limitReached := sl.allow(dp("cpu", map[string]string{"a": "1", "b": "2"}))
+	assert.True(t, allowed)
+	assert.False(t, limitReached)
+
+	// Same series with dimensions in a different map
+	allowed, _ = sl.allow(dp("cpu", map[string]string{"b": "2", "a": "1"}))
+	assert.True(t, allowed)
+	assert.Equal(t, 1, sl.Count())
+
+	allowed, _ = sl.allow(dp("memory", nil))
+	assert.True(t, allowed)
+
+	allowed, limitReached = sl.allow(dp("disk", nil))
+	assert.False(t, allowed)
+	assert.True(t, limitReached)
+
+	allowed, limitReached = sl.allow(dp("network", nil))
+	assert.False(t, allowed)
+	assert.False(t, limitReached, "limit should only be reported once")
+	assert.Equal(t, int64(2), sl.Dropped())
+
+	// Existing series keep being sent
+	allowed, _ = sl.allow(dp("memory", nil))
+	assert.True(t, allowed)
+
+	// Once cpu goes stale there is room for a new series
+	sl.timeNow = neotest.AdvancedNow(sl.timeNow, seriesStaleTimeout-time.Minute)
+	sl.allow(dp("memory", nil))
+	sl.timeNow = neotest.AdvancedNow(sl.timeNow, 2*time.Minute)
+
+	allowed, _ = sl.allow(dp("disk", nil))
+	assert.True(t, allowed)
+	assert.Equal(t, 2, sl.Count())
+}
@@ -0,0 +1,158 @@
+package monitors
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/signalfx/golib/datapoint"
+	"github.com/signalfx/signalfx-agent/internal/core/config"
+	"github.com/signalfx/signalfx-agent/internal/core/dpfilters"
+)
+
+type cumulativeConversion struct {
+	filter dpfilters.DatapointFilter
+	mode   string
+}
+
+type cumulativeState struct {
+	value     datapoint.Value
+	timestamp time.Time
+	lastSeen  time.Time
+}
+
+// cumulativeConverter turns cumulative counters into rates or deltas by
+// remembering the previous value of each time series.
+type cumulativeConverter struct {
+	sync.Mutex
+	conversions []cumulativeConversion
+	// Keyed by the same series hash as the seriesLimiter
+	previous  map[uint64]*cumulativeState
+	lastPurge time.Time
+
+	timeNow func() time.Time
+}
+
+func newCumulativeConverter(confs []config.CumulativeConversion) (*cumulativeConverter, error) {
+	var conversions []cumulativeConversion
+	for i := range confs {
+		switch confs[i].Mode {
+		case config.CumulativeToRate, config.CumulativeToDelta:
+		default:
+			return nil, fmt.Errorf("cumulative conversion mode %s is invalid", confs[i].Mode)
+		}
+
+		f, err := confs[i].MakeFilter()
+		if err != nil {
+			return nil, err
+		}
+		conversions = append(conversions, cumulativeConversion{
+			filter: f,
+			mode:   confs[i].Mode,
+		})
+	}
+
+	return &cumulativeConverter{
+		conversions: conversions,
+		previous:    make(map[uint64]*cumulativeState),
+		timeNow:     time.Now,
+	}, nil
+}
+
+// convert changes the datapoint in place if it should be converted.  It
+// returns false if the datapoint should not be sent, which is the case for
+// the first datapoint of each converted time series.
+func (cc *cumulativeConverter) convert(dp *datapoint.Datapoint) bool {
+	if dp.MetricType != datapoint.Counter {
+		return true
+	}
+
+	var mode string
+	for i := range cc.conversions {
+		if cc.conversions[i].filter.Matches(dp) {
+			mode = cc.conversions[i].mode
+			break
+		}
+	}
+	if mode == "" {
+		return true
+	}
+
+	if _, ok := dp.Value.(datapoint.IntValue); !ok {
+		if _, ok := dp.Value.(datapoint.FloatValue); !ok {
+			return true
+		}
+	}
+
+	now := cc.timeNow()
+	ts := dp.Timestamp
+	if ts.IsZero() {
+		ts = now
+	}
+
+	key := seriesKey(dp)
+
+	cc.Lock()
+	defer cc.Unlock()
+
+	if now.Sub(cc.lastPurge) >= seriesPurgeInterval {
+		cc.purgeStale(now)
+	}
+
+	prev := cc.previous[key]
+	cc.previous[key] = &cumulativeState{
+		value:     dp.Value,
+		timestamp: ts,
+		lastSeen:  now,
+	}
+
+	if prev == nil || !ts.After(prev.timestamp) {
+		return false
+	}
+
+	delta := subtractValues(dp.Value, prev.value)
+	if valueToFloat(delta) < 0 {
+		// The counter was reset (e.g. the process restarted), so assume it
+		// started again from 0.
+		delta = dp.Value
+	}
+
+	if mode == config.CumulativeToRate {
+		dp.Value = datapoint.NewFloatValue(valueToFloat(delta) / ts.Sub(prev.timestamp).Seconds())
+		dp.MetricType = datapoint.Gauge
+	} else {
+		dp.Value = delta
+		dp.MetricType = datapoint.Count
+	}
+	return true
+}
+
+func (cc *cumulativeConverter) purgeStale(now time.Time) {
+	for k, state := range cc.previous {
+		if now.Sub(state.lastSeen) > seriesStaleTimeout {
+			delete(cc.previous, k)
+		}
+	}
+	cc.lastPurge = now
+}
+
+// The values must be int or float values.  The result is an int value only
+// if both values are.
+func subtractValues(a, b datapoint.Value) datapoint.Value {
+	aInt, aIsInt := a.(datapoint.IntValue)
+	bInt, bIsInt := b.(datapoint.IntValue)
+	if aIsInt && bIsInt {
+		return datapoint.NewIntValue(aInt.Int() - bInt.Int())
+	}
+	return datapoint.NewFloatValue(valueToFloat(a) - valueToFloat(b))
+}
+
+func valueToFloat(v datapoint.Value) float64 {
+	switch val := v.(type) {
+	case datapoint.IntValue:
+		return float64(val.Int())
+	case datapoint.FloatValue:
+		return val.Float()
+	}
+	return 0
+}
@@ -0,0 +1,73 @@
+package monitors
+
+import (
+	"testing"
+	"time"
+
+	"github.com/signalfx/golib/datapoint"
+	"github.com/signalfx/signalfx-agent/internal/core/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCumulativeConverter(t *testing.T) {
+	cc, err := newCumulativeConverter([]config.CumulativeConversion{
+		{MetricName: "requests", Mode: config.CumulativeToRate},
+		{MetricName: "bytes", Mode: config.CumulativeToDelta},
+	})
+	assert.Nil(t, err)
+
+	start := time.Unix(1000, 0)
+	// Pin the clock to the datapoint timestamps so that nothing depends on
+	// the real time
+	cc.timeNow = func() time.Time { return start }
+	dp := func(metric string, val int64, typ datapoint.MetricType, offset time.Duration) *datapoint.Datapoint {
+		return datapoint.New(metric, map[string]string{"host": "a"}, datapoint.NewIntValue(val), typ, start.Add(offset))
+	}
+
+	t.Run("Converts to rate", func(t *testing.T) {
+		assert.False(t, cc.convert(dp("requests", 100, datapoint.Counter, 0)), "first datapoint is not sent")
+
+		d := dp("requests", 150, datapoint.Counter, 10*time.Second)
+		assert.True(t, cc.convert(d))
+		assert.Equal(t, datapoint.Gauge, d.MetricType)
+		assert.Equal(t, datapoint.NewFloatValue(5), d.Value)
+
+		// Counter reset
+		d = dp("requests", 20, datapoint.Counter, 20*time.Second)
+		assert.True(t, cc.convert(d))
+		assert.Equal(t, datapoint.NewFloatValue(2), d.Value)
+	})
+
+	t.Run("Converts to delta", func(t *testing.T) {
+		assert.False(t, cc.convert(dp("bytes", 1000, datapoint.Counter, 0)))
+
+		d := dp("bytes", 1500, datapoint.Counter, 10*time.Second)
+		assert.True(t, cc.convert(d))
+		assert.Equal(t, datapoint.Count, d.MetricType)
+		assert.Equal(t, datapoint.NewIntValue(500), d.Value)
+	})
+
+	t.Run("Leaves other datapoints alone", func(t *testing.T) {
+		d := dp("requests", 5, datapoint.Gauge, 0)
+		assert.True(t, cc.convert(d))
+		assert.Equal(t, datapoint.NewIntValue(5), d.Value)
+
+		d = dp("other", 5, datapoint.Counter, 0)
+		assert.True(t, cc.convert(d))
+		assert.Equal(t, datapoint.Counter, d.MetricType)
+	})
+
+	t.Run("Evicts stale series", func(t *testing.T) {
+		// A series that was seen recently is kept
+		cc.timeNow = func() time.Time { return start.Add(seriesStaleTimeout / 2) }
+		assert.True(t, cc.convert(dp("bytes", 2000, datapoint.Counter, 30*time.Second)))
+
+		cc.timeNow = func() time.Time { return start.Add(seriesStaleTimeout/2 + 2*seriesStaleTimeout) }
+		assert.False(t, cc.convert(dp("bytes", 3000, datapoint.Counter, 40*time.Second)))
+	})
+
+	t.Run("Rejects invalid modes", func(t *testing.T) {
+		_, err := newCumulativeConverter([]config.CumulativeConversion{{MetricName: "a", Mode: "average"}})
+		assert.NotNil(t, err)
+	})
+}
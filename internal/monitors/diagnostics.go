@@ -8,6 +8,7 @@ import (
 	"github.com/signalfx/signalfx-agent/internal/core/config"
 	"github.com/signalfx/signalfx-agent/internal/core/services"
 	"github.com/signalfx/signalfx-agent/internal/monitors/kubernetes/leadership"
+	"github.com/signalfx/signalfx-agent/internal/monitors/types"
 	"github.com/signalfx/signalfx-agent/internal/utils"
 )
 
@@ -57,6 +58,21 @@ func (mm *MonitorManager) DiagnosticText() string {
 				am.config.MonitorConfigCore().DiscoveryRule,
 				am.endpoint.Core().ID)
 		}
+		if limiter := am.seriesLimiter(); limiter != nil {
+			serviceStats += fmt.Sprintf(
+				"Metric Time Series: %d (limit %d, %d datapoints dropped)\n",
+				limiter.Count(),
+				limiter.max,
+				limiter.Dropped())
+		}
+		if conf := am.config.MonitorConfigCore(); conf.EventFilter != nil || conf.SpanFilter != nil {
+			eventsFiltered, spansFiltered := am.filteredCounts()
+			serviceStats += fmt.Sprintf(
+				"Events Filtered: %d\n"+
+					"Trace Spans Filtered: %d\n",
+				eventsFiltered,
+				spansFiltered)
+		}
 		activeMonText += fmt.Sprintf(
 			"%s. %s\n"+
 				"    Reporting Interval (seconds): %d\n"+
@@ -97,12 +113,65 @@ func (mm *MonitorManager) DiagnosticText() string {
 // InternalMetrics returns a list of datapoints about the internal status of
 // the monitors
 func (mm *MonitorManager) InternalMetrics() []*datapoint.Datapoint {
-	return []*datapoint.Datapoint{
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+
+	out := []*datapoint.Datapoint{
 		sfxclient.Gauge("sfxagent.active_monitors", nil, int64(len(mm.activeMonitors))),
 		sfxclient.Gauge("sfxagent.configured_monitors", nil, int64(len(mm.monitorConfigs))),
 		sfxclient.Gauge("sfxagent.discovered_endpoints", nil, int64(len(mm.discoveredEndpoints))),
 		sfxclient.Gauge("sfxagent.k8s_leader", map[string]string{"leader_node": leadership.CurrentLeader()}, 1),
 	}
+
+	for _, am := range mm.activeMonitors {
+		dims := map[string]string{
+			"monitor_id":   string(am.id),
+			"monitor_type": am.config.MonitorConfigCore().Type,
+		}
+		if limiter := am.seriesLimiter(); limiter != nil {
+			out = append(out,
+				sfxclient.Gauge("sfxagent.monitor_metric_time_series", dims, int64(limiter.Count())),
+				sfxclient.Cumulative("sfxagent.monitor_metric_time_series_dropped", dims, limiter.Dropped()))
+		}
+		if conf := am.config.MonitorConfigCore(); conf.EventFilter != nil || conf.SpanFilter != nil {
+			eventsFiltered, spansFiltered := am.filteredCounts()
+			out = append(out,
+				sfxclient.Cumulative("sfxagent.monitor_events_filtered", dims, eventsFiltered),
+				sfxclient.Cumulative("sfxagent.monitor_trace_spans_filtered", dims, spansFiltered))
+		}
+	}
+	return out
+}
+
+// ActiveMonitorInfo describes a running monitor instance
+type ActiveMonitorInfo struct {
+	ID types.MonitorID
+	// The hash of the generic config that the monitor was created from
+	ConfigHash uint64
+	Config     config.MonitorCustomConfig
+	// The ID of the discovered endpoint that is being monitored, if any
+	EndpointID services.ID
+}
+
+// ActiveMonitors returns information about all of the running monitor
+// instances
+func (mm *MonitorManager) ActiveMonitors() []ActiveMonitorInfo {
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+
+	out := make([]ActiveMonitorInfo, 0, len(mm.activeMonitors))
+	for _, am := range mm.activeMonitors {
+		info := ActiveMonitorInfo{
+			ID:         am.id,
+			ConfigHash: am.configHash,
+			Config:     am.config,
+		}
+		if am.endpoint != nil {
+			info.EndpointID = am.endpoint.Core().ID
+		}
+		out = append(out, info)
+	}
+	return out
 }
 
 func badConfigText(confs map[uint64]*config.MonitorConfig) string {
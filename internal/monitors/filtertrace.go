@@ -0,0 +1,80 @@
+package monitors
+
+import (
+	"github.com/signalfx/golib/datapoint"
+	"github.com/signalfx/signalfx-agent/internal/core/dpfilters"
+	"github.com/signalfx/signalfx-agent/internal/monitors/types"
+)
+
+// MonitorFilterDecision is how the filters of a single active monitor would
+// treat a datapoint
+type MonitorFilterDecision struct {
+	MonitorID   types.MonitorID
+	MonitorType string
+	dpfilters.Decision
+}
+
+// MonitorFilterStats are the match counts of the filters of a monitor config.
+// All of the monitors created from the same config share the filters and
+// their counts.
+type MonitorFilterStats struct {
+	MonitorIDs  []types.MonitorID
+	MonitorType string
+	Stats       []dpfilters.FilterStat
+}
+
+// ExplainDatapointFilters returns how the filters of each active monitor of
+// the given type would treat the given datapoint.  If monitorID is not blank,
+// only that monitor is considered.
+func (mm *MonitorManager) ExplainDatapointFilters(dp *datapoint.Datapoint, monitorType string, monitorID types.MonitorID) []MonitorFilterDecision {
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+
+	var out []MonitorFilterDecision
+	for _, am := range mm.activeMonitors {
+		conf := am.config.MonitorConfigCore()
+		if conf.Type != monitorType || (monitorID != "" && am.id != monitorID) {
+			continue
+		}
+
+		d := MonitorFilterDecision{
+			MonitorID:   am.id,
+			MonitorType: conf.Type,
+		}
+		if conf.Filter != nil {
+			d.Decision = conf.Filter.Explain(dp)
+		}
+		out = append(out, d)
+	}
+	return out
+}
+
+// DatapointFilterStats returns the match counts of the filters of every
+// active monitor config that has any
+func (mm *MonitorManager) DatapointFilterStats() []*MonitorFilterStats {
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+
+	var out []*MonitorFilterStats
+	byFilterSet := map[*dpfilters.FilterSet]*MonitorFilterStats{}
+	for _, am := range mm.activeMonitors {
+		conf := am.config.MonitorConfigCore()
+		if conf.Filter == nil {
+			continue
+		}
+		if s, ok := byFilterSet[conf.Filter]; ok {
+			s.MonitorIDs = append(s.MonitorIDs, am.id)
+			continue
+		}
+		if stats := conf.Filter.Stats(); len(stats) > 0 {
+			s := &MonitorFilterStats{
+				MonitorIDs:  []types.MonitorID{am.id},
+				MonitorType: conf.Type,
+				Stats:       stats,
+			}
+			byFilterSet[conf.Filter] = s
+			out = append(out, s)
+		}
+	}
+	return out
+}
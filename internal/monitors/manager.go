@@ -334,6 +334,8 @@ func (mm *MonitorManager) createAndConfigureNewMonitor(config config.MonitorCust
 		notHostSpecific:           config.MonitorConfigCore().DisableHostDimensions,
 		disableEndpointDimensions: config.MonitorConfigCore().DisableEndpointDimensions,
 		filter:                    config.MonitorConfigCore().Filter,
+		eventFilter:               config.MonitorConfigCore().EventFilter,
+		spanFilter:                config.MonitorConfigCore().SpanFilter,
 		configHash:                configHash,
 		endpoint:                  endpoint,
 		dpChan:                    mm.DPs,
@@ -342,6 +344,16 @@ func (mm *MonitorManager) createAndConfigureNewMonitor(config config.MonitorCust
 		spanChan:                  mm.TraceSpans,
 		extraDims:                 map[string]string{},
 	}
+	if max := config.MonitorConfigCore().MaxMetricTimeSeries; max > 0 {
+		output.seriesLimiter = newSeriesLimiter(max)
+	}
+	if conversions := config.MonitorConfigCore().CumulativeConversions; len(conversions) > 0 {
+		var err error
+		output.cumulativeConverter, err = newCumulativeConverter(conversions)
+		if err != nil {
+			return errors.Wrap(err, "could not set up cumulativeConversions")
+		}
+	}
 
 	am := &ActiveMonitor{
 		id:         id,
@@ -95,6 +95,12 @@ type Shutdownable interface {
 	Shutdown()
 }
 
+// DecodeConfig returns the monitor-specific config struct for the given
+// generic monitor config, with the defaults for the monitor type filled in.
+func DecodeConfig(conf *config.MonitorConfig) (config.MonitorCustomConfig, error) {
+	return getCustomConfigForMonitor(conf)
+}
+
 // Takes a generic MonitorConfig and pulls out monitor-specific config to
 // populate a clone of the config template that was registered for the monitor
 // type specified in conf.  This will also validate the config and return nil
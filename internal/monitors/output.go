@@ -1,14 +1,21 @@
 package monitors
 
 import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
 	"github.com/signalfx/golib/datapoint"
 	"github.com/signalfx/golib/event"
 	"github.com/signalfx/golib/trace"
 	"github.com/signalfx/signalfx-agent/internal/core/common/dpmeta"
 	"github.com/signalfx/signalfx-agent/internal/core/dpfilters"
+	"github.com/signalfx/signalfx-agent/internal/core/eventfilters"
 	"github.com/signalfx/signalfx-agent/internal/core/services"
+	"github.com/signalfx/signalfx-agent/internal/core/spanfilters"
 	"github.com/signalfx/signalfx-agent/internal/monitors/types"
 	"github.com/signalfx/signalfx-agent/internal/utils"
+	log "github.com/sirupsen/logrus"
 )
 
 // The default implementation of Output
@@ -18,6 +25,8 @@ type monitorOutput struct {
 	notHostSpecific           bool
 	disableEndpointDimensions bool
 	filter                    *dpfilters.FilterSet
+	eventFilter               *eventfilters.FilterSet
+	spanFilter                *spanfilters.FilterSet
 	configHash                uint64
 	endpoint                  services.Endpoint
 	dpChan                    chan<- *datapoint.Datapoint
@@ -25,6 +34,13 @@ type monitorOutput struct {
 	spanChan                  chan<- *trace.Span
 	dimPropChan               chan<- *types.DimProperties
 	extraDims                 map[string]string
+	// Nil if the number of metric time series is not limited
+	seriesLimiter *seriesLimiter
+	// Nil if no cumulative counters are converted
+	cumulativeConverter *cumulativeConverter
+
+	eventsFiltered int64
+	spansFiltered  int64
 }
 
 var _ types.Output = &monitorOutput{}
@@ -53,22 +69,80 @@ func (mo *monitorOutput) SendDatapoint(dp *datapoint.Datapoint) {
 
 	dp.Dimensions = utils.MergeStringMaps(dp.Dimensions, mo.extraDims, endpointDims)
 
+	// Limit the series first so that the converter never keeps state for
+	// series that are dropped anyway
+	if mo.seriesLimiter != nil {
+		allowed, limitReached := mo.seriesLimiter.allow(dp)
+		if limitReached {
+			mo.sendSeriesLimitEvent()
+		}
+		if !allowed {
+			return
+		}
+	}
+
+	if mo.cumulativeConverter != nil && !mo.cumulativeConverter.convert(dp) {
+		return
+	}
+
 	mo.dpChan <- dp
 }
 
-func (mo *monitorOutput) SendEvent(event *event.Event) {
+func (mo *monitorOutput) sendSeriesLimitEvent() {
+	log.WithFields(log.Fields{
+		"monitorType": mo.monitorType,
+		"monitorID":   mo.monitorID,
+		"limit":       mo.seriesLimiter.max,
+	}).Warn("Monitor reached its metric time series limit, datapoints for new series will be dropped")
+
+	ev := event.NewWithProperties(
+		"signalfx-agent.mts-limit-reached",
+		event.AGENT,
+		map[string]string{
+			"monitorType": mo.monitorType,
+			"monitorID":   string(mo.monitorID),
+		},
+		map[string]interface{}{
+			"limit": mo.seriesLimiter.max,
+			"message": fmt.Sprintf("Monitor %s (%s) reached its limit of %d metric time series, "+
+				"datapoints for new series are being dropped", mo.monitorID, mo.monitorType, mo.seriesLimiter.max),
+		},
+		time.Now())
+	mo.addEventMeta(ev)
+
+	// This is about the agent itself and not something the monitor observed,
+	// so it bypasses the monitor's event filter.
+	mo.eventChan <- ev
+}
+
+func (mo *monitorOutput) addEventMeta(event *event.Event) {
+	if event.Properties == nil {
+		event.Properties = make(map[string]interface{})
+	}
+	// Events don't have a non-serialized meta field, so just use properties
+	// and make sure to remove these in the writer.
+	event.Properties[dpmeta.MonitorTypeMeta] = mo.monitorType
 	if mo.notHostSpecific {
-		if event.Properties == nil {
-			event.Properties = make(map[string]interface{})
-		}
-		// Events don't have a non-serialized meta field, so just use
-		// properties and make sure to remove this in the writer.
 		event.Properties[dpmeta.NotHostSpecificMeta] = true
 	}
+}
+
+func (mo *monitorOutput) SendEvent(event *event.Event) {
+	mo.addEventMeta(event)
+
+	if mo.eventFilter != nil && mo.eventFilter.Matches(event) {
+		atomic.AddInt64(&mo.eventsFiltered, 1)
+		return
+	}
+
 	mo.eventChan <- event
 }
 
 func (mo *monitorOutput) SendSpan(span *trace.Span) {
+	if mo.spanFilter != nil && mo.spanFilter.Matches(span) {
+		atomic.AddInt64(&mo.spansFiltered, 1)
+		return
+	}
 	mo.spanChan <- span
 }
 
@@ -0,0 +1,73 @@
+package monitors
+
+import (
+	"testing"
+	"time"
+
+	"github.com/signalfx/golib/datapoint"
+	"github.com/signalfx/golib/event"
+	"github.com/signalfx/signalfx-agent/internal/core/config"
+	"github.com/signalfx/signalfx-agent/internal/core/eventfilters"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeriesLimitEventBypassesEventFilter(t *testing.T) {
+	// Excludes every event from the monitor
+	filter, err := eventfilters.New("", []string{"*"}, nil, nil, false)
+	assert.Nil(t, err)
+
+	dpChan := make(chan *datapoint.Datapoint, 10)
+	eventChan := make(chan *event.Event, 10)
+	mo := &monitorOutput{
+		monitorType:   "cpu",
+		monitorID:     "cpu-1",
+		eventFilter:   &eventfilters.FilterSet{ExcludeFilters: []eventfilters.EventFilter{filter}},
+		dpChan:        dpChan,
+		eventChan:     eventChan,
+		extraDims:     map[string]string{},
+		seriesLimiter: newSeriesLimiter(1),
+	}
+
+	mo.SendEvent(event.New("other", event.AGENT, nil, time.Now()))
+	assert.Len(t, eventChan, 0)
+
+	mo.SendDatapoint(datapoint.New("a", nil, datapoint.NewIntValue(1), datapoint.Gauge, time.Now()))
+	mo.SendDatapoint(datapoint.New("b", nil, datapoint.NewIntValue(1), datapoint.Gauge, time.Now()))
+
+	assert.Len(t, dpChan, 1)
+	if assert.Len(t, eventChan, 1) {
+		ev := <-eventChan
+		assert.Equal(t, "signalfx-agent.mts-limit-reached", ev.EventType)
+		assert.Equal(t, int64(1), mo.eventsFiltered)
+	}
+}
+
+func TestSeriesLimitAppliesBeforeCumulativeConversion(t *testing.T) {
+	cc, err := newCumulativeConverter([]config.CumulativeConversion{
+		{MetricName: "*", Mode: config.CumulativeToDelta},
+	})
+	assert.Nil(t, err)
+
+	dpChan := make(chan *datapoint.Datapoint, 10)
+	mo := &monitorOutput{
+		monitorType:         "cpu",
+		monitorID:           "cpu-1",
+		dpChan:              dpChan,
+		eventChan:           make(chan *event.Event, 10),
+		extraDims:           map[string]string{},
+		seriesLimiter:       newSeriesLimiter(1),
+		cumulativeConverter: cc,
+	}
+
+	start := time.Unix(1000, 0)
+	for i := 0; i < 2; i++ {
+		ts := start.Add(time.Duration(i) * 10 * time.Second)
+		mo.SendDatapoint(datapoint.New("a", nil, datapoint.NewIntValue(int64(i)), datapoint.Counter, ts))
+		mo.SendDatapoint(datapoint.New("b", nil, datapoint.NewIntValue(int64(i)), datapoint.Counter, ts))
+	}
+
+	// Only the second datapoint of the allowed series is sent, and the
+	// converter never saw the series that was over the limit
+	assert.Len(t, dpChan, 1)
+	assert.Len(t, cc.previous, 1)
+}
@@ -8,10 +8,61 @@ import (
 	"github.com/signalfx/signalfx-agent/internal/core/config"
 	"github.com/signalfx/signalfx-agent/internal/core/config/validation"
 	"github.com/signalfx/signalfx-agent/internal/core/services"
+	"github.com/signalfx/signalfx-agent/internal/utils"
 )
 
+// ValidateConfigs decodes and validates the given monitor configs the same way
+// that they would be when the monitors are created, without creating any
+// monitors.  intervalSeconds is the agent's default monitor interval.  The
+// errors are keyed by the index of the config they are for.
+func ValidateConfigs(confs []config.MonitorConfig, intervalSeconds int) map[int]error {
+	errs := make(map[int]error)
+	instancesOfType := make(map[string]int)
+
+	for i := range confs {
+		confs[i].IntervalSeconds = utils.FirstNonZero(confs[i].IntervalSeconds, intervalSeconds)
+
+		monConfig, err := getCustomConfigForMonitor(&confs[i])
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+
+		if configOnlyAllowsSingleInstance(monConfig) {
+			instancesOfType[confs[i].Type]++
+			if instancesOfType[confs[i].Type] > 1 {
+				errs[i] = fmt.Errorf("Monitor type %s only allows a single instance at a time", confs[i].Type)
+				continue
+			}
+		}
+
+		validate := validateConfig
+		if confs[i].DiscoveryRule != "" {
+			// The rest of the config gets filled in from discovered endpoints
+			validate = validateCommonConfig
+		}
+		if err := validate(monConfig); err != nil {
+			errs[i] = err
+		}
+	}
+	return errs
+}
+
 // Used to validate configuration that is common to all monitors up front
 func validateConfig(monConfig config.MonitorCustomConfig) error {
+	if err := validateCommonConfig(monConfig); err != nil {
+		return err
+	}
+
+	if err := validation.ValidateStruct(monConfig); err != nil {
+		return err
+	}
+
+	return validation.ValidateCustomConfig(monConfig)
+}
+
+// Validates the parts of the config that don't depend on endpoints
+func validateCommonConfig(monConfig config.MonitorCustomConfig) error {
 	conf := monConfig.MonitorConfigCore()
 
 	if _, ok := MonitorFactories[conf.Type]; !ok {
@@ -38,12 +89,7 @@ func validateConfig(monConfig config.MonitorCustomConfig) error {
 	if len(conf.ConfigEndpointMappings) > 0 && len(conf.DiscoveryRule) == 0 {
 		return errors.New("configEndpointMappings is not useful without a discovery rule")
 	}
-
-	if err := validation.ValidateStruct(monConfig); err != nil {
-		return err
-	}
-
-	return validation.ValidateCustomConfig(monConfig)
+	return nil
 }
 
 func configAcceptsEndpoints(monConfig config.MonitorCustomConfig) bool {
@@ -0,0 +1,92 @@
+package monitors
+
+import (
+	"testing"
+
+	"github.com/signalfx/signalfx-agent/internal/core/config"
+	"github.com/stretchr/testify/assert"
+)
+
+type SingleConfig struct {
+	config.MonitorConfig `singleInstance:"true"`
+}
+
+type Single struct{ _MockMonitor }
+
+func (m *Single) Configure(conf *SingleConfig) error {
+	return nil
+}
+
+func registerValidationTestMonitors() {
+	DeregisterAll()
+	RegisterFakeMonitors()
+	Register("single", func() interface{} { return &Single{} }, &SingleConfig{})
+}
+
+func TestValidateConfigs(t *testing.T) {
+	registerValidationTestMonitors()
+	defer DeregisterAll()
+
+	for _, tc := range []struct {
+		desc  string
+		confs []config.MonitorConfig
+		// The error messages by config index
+		errs map[int]string
+	}{
+		{
+			desc: "valid configs",
+			confs: []config.MonitorConfig{
+				{Type: "static1"},
+				{Type: "dynamic1", OtherConfig: map[string]interface{}{"host": "localhost", "port": 80}},
+				{Type: "dynamic1", DiscoveryRule: `container_image =~ "redis"`},
+				{Type: "single"},
+			},
+			errs: map[int]string{},
+		},
+		{
+			desc:  "unknown monitor type",
+			confs: []config.MonitorConfig{{Type: "static1"}, {Type: "not-a-monitor"}},
+			errs:  map[int]string{1: "Unknown monitor type not-a-monitor"},
+		},
+		{
+			desc:  "bad discovery rule",
+			confs: []config.MonitorConfig{{Type: "dynamic1", DiscoveryRule: `container_image =~`}},
+			errs:  map[int]string{0: "discovery rule is invalid"},
+		},
+		{
+			desc:  "discovery rule on a monitor that doesn't take endpoints",
+			confs: []config.MonitorConfig{{Type: "static1", DiscoveryRule: `container_image =~ "redis"`}},
+			errs:  map[int]string{0: "monitor static1 does not support discovery but has a discovery rule"},
+		},
+		{
+			desc:  "duplicate single instance monitor",
+			confs: []config.MonitorConfig{{Type: "single"}, {Type: "static1"}, {Type: "single"}},
+			errs:  map[int]string{2: "Monitor type single only allows a single instance at a time"},
+		},
+		{
+			desc:  "missing required field",
+			confs: []config.MonitorConfig{{Type: "dynamic1", OtherConfig: map[string]interface{}{"host": "localhost"}}},
+			errs:  map[int]string{0: "Validation error in field 'port': required"},
+		},
+	} {
+		errs := ValidateConfigs(tc.confs, 10)
+		assert.Len(t, errs, len(tc.errs), tc.desc)
+		for i, msg := range tc.errs {
+			if assert.NotNil(t, errs[i], "%s: config %d", tc.desc, i) {
+				assert.Contains(t, errs[i].Error(), msg, tc.desc)
+			}
+		}
+	}
+}
+
+func TestValidateConfigsFillsInInterval(t *testing.T) {
+	registerValidationTestMonitors()
+	defer DeregisterAll()
+
+	confs := []config.MonitorConfig{{Type: "static1"}, {Type: "static1", IntervalSeconds: 5}}
+	assert.Len(t, ValidateConfigs(confs, 10), 0)
+	assert.Equal(t, 10, confs[0].IntervalSeconds)
+	assert.Equal(t, 5, confs[1].IntervalSeconds)
+
+	assert.Len(t, ValidateConfigs([]config.MonitorConfig{{Type: "static1"}}, 0), 1)
+}
@@ -55,14 +55,28 @@ type ServiceCallbacks struct {
 	Removed func(services.Endpoint)
 }
 
+// DecodeConfig returns the observer-specific config struct for the given
+// generic observer config, with the defaults for the observer type filled in.
+func DecodeConfig(conf *config.ObserverConfig) (interface{}, error) {
+	template, ok := ConfigTemplates[conf.Type]
+	if !ok {
+		return nil, errors.Errorf("Unknown observer type %s", conf.Type)
+	}
+	finalConfig := utils.CloneInterface(template)
+
+	if err := config.FillInConfigTemplate("ObserverConfig", finalConfig, conf); err != nil {
+		return nil, err
+	}
+	return finalConfig, nil
+}
+
 func configureObserver(observer interface{}, conf *config.ObserverConfig) error {
 	log.WithFields(log.Fields{
 		"config": *conf,
 	}).Debug("Configuring observer")
 
-	finalConfig := utils.CloneInterface(ConfigTemplates[conf.Type])
-
-	if err := config.FillInConfigTemplate("ObserverConfig", finalConfig, conf); err != nil {
+	finalConfig, err := DecodeConfig(conf)
+	if err != nil {
 		return err
 	}
 
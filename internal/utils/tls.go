@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// MakeTLSConfig returns a TLS client config that trusts the CA cert at
+// caCertPath in addition to the system certs and presents the given client
+// cert, if any.  Blank paths are ignored.
+func MakeTLSConfig(caCertPath, clientCertPath, clientKeyPath string, skipVerify bool) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: skipVerify,
+	}
+
+	if caCertPath != "" {
+		certs, err := x509.SystemCertPool()
+		if err != nil {
+			certs = x509.NewCertPool()
+		}
+		caCert, err := ioutil.ReadFile(caCertPath)
+		if err != nil {
+			return nil, fmt.Errorf("could not read CA cert %s: %v", caCertPath, err)
+		}
+		if !certs.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certs could be parsed from %s", caCertPath)
+		}
+		tlsConfig.RootCAs = certs
+	}
+
+	if clientCertPath != "" {
+		cert, err := tls.LoadX509KeyPair(clientCertPath, clientKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client cert %s with key %s: %v",
+				clientCertPath, clientKeyPath, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}